@@ -5,16 +5,14 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/monstercat/gologx/logxhost"
 	"github.com/monstercat/gologx/utils"
 )
 
 func showDetails(name string, args []string) error {
-	var postgres string
 	var id string
 
 	set := flag.NewFlagSet(name, flag.ExitOnError)
-	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	source := addSourceFlags(set)
 	set.StringVar(&id, "id", "", "Log ID for detailed info")
 
 	if err := set.Parse(args); err != nil {
@@ -26,13 +24,13 @@ func showDetails(name string, args []string) error {
 		os.Exit(1)
 	}
 
-	db, err := logxhost.GetPostgresConnection(postgres)
+	src, err := source.open()
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer src.Close()
 
-	log, err := logxhost.GetLog(db, id)
+	log, err := src.GetLog(id)
 	if err != nil {
 		return err
 	}
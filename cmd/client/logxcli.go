@@ -22,6 +22,7 @@ func main() {
 		"status":  showStatus,
 		"search":  showSearch,
 		"details": showDetails,
+		"tail":    showTail,
 	})
 
 	if err != nil {
@@ -4,67 +4,147 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/monstercat/gologx/logxhost"
 	"github.com/monstercat/gologx/utils"
 	"github.com/olekukonko/tablewriter"
 )
 
+// Flag which can be provided multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+var dateFormats = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05Z07:00", "2006-01-02"}
+
+func parseDate(name, v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	for _, f := range dateFormats {
+		if t, err := time.ParseInLocation(f, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("yyyy-mm-dd or yyyy-mm-dd hh:mm:ss format is required for %s", name)
+}
+
+// Filters shared by the search and tail commands.
+type filterFlags struct {
+	services, machines, types, severities string
+	matcher                               string
+	query                                 string
+	contexts                              stringsFlag
+}
+
+func addFilterFlags(set *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	set.StringVar(&f.services, "service", "", "Defines services to be filtered, separated by commas. Supports % wildcards")
+	set.StringVar(&f.machines, "machine", "", "Defines machines to be filtered, separated by commas. Supports % wildcards")
+	set.StringVar(&f.types, "type", "", "Defines log types to be filtered, separated by commas")
+	set.StringVar(&f.severities, "severity", "", "Defines severities to be filtered, separated by commas")
+	set.StringVar(&f.matcher, "matcher", "", "Searches the message. Supports \"phrases\", prefix* and -exclusions")
+	set.Var(&f.contexts, "context", "Filters on a context field, e.g. Method=POST. Can be repeated")
+	set.StringVar(&f.query, "query", "", "Filter expression, e.g. 'service:api severity>=WARN ctx.Path~\"/users/*\"'")
+	return f
+}
+
+// Sets the filters on the query. Invalid filters exit.
+func (f *filterFlags) apply(q *logxhost.LogQuery) {
+	for _, c := range f.contexts {
+		p, err := logxhost.ParseContextPredicate(c)
+		if err != nil {
+			fmt.Println(utils.StyleDanger(err.Error()))
+			os.Exit(1)
+		}
+		q.Context = append(q.Context, p)
+	}
+	filter, err := logxhost.ParseFilter(f.query)
+	if err != nil {
+		fmt.Println(utils.StyleDanger(err.Error()))
+		os.Exit(1)
+	}
+	q.Filter = filter
+	q.Services = utils.ParseFlagStrToArray(f.services, ",")
+	q.Machines = utils.ParseFlagStrToArray(f.machines, ",")
+	q.Types = utils.ParseFlagStrToArray(f.types, ",")
+	q.Severities = utils.ParseFlagStrToArray(f.severities, ",")
+	q.Text = f.matcher
+}
+
 func showSearch(name string, args []string) error {
-	var postgres string
-	var service string
-	var matcher string
-	var limit int
 	var dateBefore string
 	var dateAfter string
 	var orderby string
-
-	limitDefault := 50
+	var after, before string
+	var all bool
+	var q logxhost.LogQuery
 
 	set := flag.NewFlagSet(name, flag.ExitOnError)
-	set.StringVar(&postgres, "postgres", "", "Postgres database")
-	set.StringVar(&service, "service", "", "Defines a service to be filtered")
-	set.StringVar(&matcher, "matcher", "", "Defines a matcher to be filtered. Searches machine and name")
-	set.IntVar(&limit, "limit", limitDefault, "Defines the limit of results in the list")
+	source := addSourceFlags(set)
+	filters := addFilterFlags(set)
+	set.IntVar(&q.Limit, "limit", logxhost.DefaultLogQueryLimit, "Defines the limit of results in the list")
 	set.StringVar(&dateBefore, "datebefore", "", "Filters before a specific date time")
 	set.StringVar(&dateAfter, "dateafter", "", "Filters after a specific date time")
-	set.StringVar(&orderby, "orderby", "", "Orders list by a defined column")
+	set.StringVar(&orderby, "orderby", "", "Orders list by a defined column, or relevance for the matcher")
+	set.StringVar(&after, "after", "", "Shows the page after a cursor (older logs)")
+	set.StringVar(&before, "before", "", "Shows the page before a cursor (newer logs)")
+	set.BoolVar(&all, "all", false, "Streams every matching log, fetching limit logs at a time")
 
 	if err := set.Parse(args); err != nil {
 		return err
 	}
 
-	if service == "" {
-		fmt.Println(utils.StyleDanger("service flag is required"))
+	var err error
+	if q.Before, err = parseDate("datebefore", dateBefore); err != nil {
+		fmt.Println(utils.StyleDanger(err.Error()))
 		os.Exit(1)
 	}
-
-	dateRegex := regexp.MustCompile("((19|20)\\d\\d)-(0?[1-9]|1[012])-(0?[1-9]|[12][0-9]|3[01])")
-	if valid := dateRegex.MatchString(dateBefore); !valid && dateBefore != "" {
-		fmt.Println(utils.StyleDanger("yyyy-mm-dd format is required for datebefore"))
+	if q.After, err = parseDate("dateafter", dateAfter); err != nil {
+		fmt.Println(utils.StyleDanger(err.Error()))
 		os.Exit(1)
 	}
-
-	if valid := dateRegex.MatchString(dateAfter); !valid && dateAfter != "" {
-		fmt.Println(utils.StyleDanger("yyyy-mm-dd format is required for dateafter"))
-		os.Exit(1)
+	filters.apply(&q)
+	q.OrderBy = utils.ParseFlagStrToArray(orderby, ",")
+	if after != "" {
+		if q.PageAfter, err = logxhost.ParseCursor(after); err != nil {
+			fmt.Println(utils.StyleDanger("after: " + err.Error()))
+			os.Exit(1)
+		}
+	}
+	if before != "" {
+		if q.PageBefore, err = logxhost.ParseCursor(before); err != nil {
+			fmt.Println(utils.StyleDanger("before: " + err.Error()))
+			os.Exit(1)
+		}
 	}
 
-	db, err := logxhost.GetPostgresConnection(postgres)
+	src, err := source.open()
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer src.Close()
 
-	search := logxhost.GetLogSearchQuery(service, matcher, limit, dateBefore, dateAfter, orderby)
+	if all {
+		return streamSearch(src, &q)
+	}
 
-	logs, err := logxhost.SelectLogs(db, search)
+	page, err := src.SearchLogPage(&q)
 	if err != nil {
 		return err
 	}
 
-	if len(logs) == 0 {
+	if len(page.Logs) == 0 {
 		fmt.Println(utils.StyleWarning("No logs were found"))
 		os.Exit(1)
 	}
@@ -72,12 +152,42 @@ func showSearch(name string, args []string) error {
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader([]string{"Id", "Machine", "Service", "Log Type", "Message", "Log Time"})
 
-	for _, l := range logs {
+	for _, l := range page.Logs {
 		row := []string{l.Id, l.Machine, l.Service, l.LogType, l.Message, l.LogTime.Format("2006-01-02 15:04:05")}
 		table.Append(row)
 	}
 
 	table.Render()
 
+	if page.Prev != nil {
+		fmt.Printf("%s --before %s\n", utils.StyleHighlight("Newer logs:"), page.Prev)
+	}
+	if page.Next != nil {
+		fmt.Printf("%s --after %s\n", utils.StyleHighlight("Older logs:"), page.Next)
+	}
+
+	return nil
+}
+
+// Prints every log as it is fetched, so the output is not held in memory.
+func streamSearch(src logSource, q *logxhost.LogQuery) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMACHINE\tSERVICE\tLOG TYPE\tMESSAGE\tLOG TIME")
+
+	var count int
+	err := src.StreamLogs(q, func(logs []*logxhost.Log) error {
+		for _, l := range logs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", l.Id, l.Machine, l.Service, l.LogType, l.Message, l.LogTime.Format("2006-01-02 15:04:05"))
+		}
+		count += len(logs)
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Println(utils.StyleWarning("No logs were found"))
+		os.Exit(1)
+	}
 	return nil
 }
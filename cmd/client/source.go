@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/monstercat/gologx/logxhost"
+)
+
+// Where the logs are read from: the database directly, or the API of a
+// log server.
+type logSource interface {
+	SearchLogPage(q *logxhost.LogQuery) (*logxhost.LogPage, error)
+	StreamLogs(q *logxhost.LogQuery, fn func([]*logxhost.Log) error) error
+	GetLog(id string) (*logxhost.Log, error)
+	SelectInstances(services []string) ([]*logxhost.Instance, error)
+	SelectSessions(services []string, from, to time.Time) ([]*logxhost.Session, error)
+	TailLogs(q *logxhost.LogQuery, die chan bool, fn func([]*logxhost.Log) error, eh func(error)) error
+	Close() error
+}
+
+type sourceFlags struct {
+	postgres string
+	api      logxhost.APIClientConfig
+}
+
+func addSourceFlags(set *flag.FlagSet) *sourceFlags {
+	f := &sourceFlags{}
+	set.StringVar(&f.postgres, "postgres", "", "Postgres database")
+	set.StringVar(&f.api.URL, "server", "", "URL of the log server API, used instead of --postgres")
+	set.StringVar(&f.api.Token, "token", os.Getenv("LOGX_TOKEN"), "API token. Defaults to $LOGX_TOKEN")
+	set.StringVar(&f.api.CertFile, "cert", "", "Client certificate, to authenticate to the server without a token")
+	set.StringVar(&f.api.KeyFile, "key", "", "Client key")
+	set.StringVar(&f.api.CAFile, "ca", "", "Certificate to verify the server with. Defaults to the system authorities")
+	set.BoolVar(&f.api.Insecure, "insecure", false, "Skips verifying the certificate of the server")
+	return f
+}
+
+func (f *sourceFlags) open() (logSource, error) {
+	if f.api.URL != "" {
+		c, err := logxhost.NewAPIClient(f.api)
+		if err != nil {
+			return nil, err
+		}
+		return apiSource{c}, nil
+	}
+	db, err := logxhost.GetPostgresConnection(f.postgres)
+	if err != nil {
+		return nil, err
+	}
+	return &dbSource{db: db, url: f.postgres}, nil
+}
+
+type apiSource struct {
+	*logxhost.APIClient
+}
+
+func (apiSource) Close() error {
+	return nil
+}
+
+type dbSource struct {
+	db  *sqlx.DB
+	url string
+}
+
+func (s *dbSource) SearchLogPage(q *logxhost.LogQuery) (*logxhost.LogPage, error) {
+	return logxhost.SearchLogPage(s.db, q)
+}
+
+func (s *dbSource) StreamLogs(q *logxhost.LogQuery, fn func([]*logxhost.Log) error) error {
+	return logxhost.StreamLogs(s.db, q, fn)
+}
+
+func (s *dbSource) GetLog(id string) (*logxhost.Log, error) {
+	return logxhost.GetLog(s.db, id)
+}
+
+func (s *dbSource) SelectInstances(services []string) ([]*logxhost.Instance, error) {
+	return logxhost.SelectInstances(s.db, services)
+}
+
+func (s *dbSource) SelectSessions(services []string, from, to time.Time) ([]*logxhost.Session, error) {
+	return logxhost.SelectSessions(s.db, services, from, to, nil)
+}
+
+func (s *dbSource) TailLogs(q *logxhost.LogQuery, die chan bool, fn func([]*logxhost.Log) error, eh func(error)) error {
+	return logxhost.TailLogs(s.db, s.url, q, die, fn, eh)
+}
+
+func (s *dbSource) Close() error {
+	return s.db.Close()
+}
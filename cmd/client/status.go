@@ -12,40 +12,73 @@ import (
 )
 
 func showStatus(name string, args []string) error {
-	var postgres string
 	var services string
+	var history bool
+	var since time.Duration
+	var dateAfter, dateBefore string
 
 	set := flag.NewFlagSet(name, flag.ExitOnError)
-	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	source := addSourceFlags(set)
 	set.StringVar(&services, "services", "", "List of services")
+	set.BoolVar(&history, "history", false, "Shows the uptime and a timeline of up and down periods of each instance")
+	set.DurationVar(&since, "since", 24*time.Hour, "Time range of the history, until now")
+	set.StringVar(&dateAfter, "dateafter", "", "Start of the history, instead of --since")
+	set.StringVar(&dateBefore, "datebefore", "", "End of the history. Defaults to now")
 
 	if err := set.Parse(args); err != nil {
 		return err
 	}
 
-	db, err := logxhost.GetPostgresConnection(postgres)
+	now := time.Now()
+	to, err := parseDate("datebefore", dateBefore)
+	if err != nil {
+		fmt.Println(utils.StyleDanger(err.Error()))
+		os.Exit(1)
+	}
+	from, err := parseDate("dateafter", dateAfter)
+	if err != nil {
+		fmt.Println(utils.StyleDanger(err.Error()))
+		os.Exit(1)
+	}
+	if to.IsZero() {
+		to = now
+	}
+	if from.IsZero() {
+		from = to.Add(-since)
+	}
+
+	src, err := source.open()
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer src.Close()
 
 	srvcsArr := utils.ParseFlagStrToArray(services, ",")
 
-	srvcs, err := logxhost.SelectServices(db, srvcsArr)
+	instances, err := src.SelectInstances(srvcsArr)
 	if err != nil {
 		return err
 	}
 
-	if len(srvcs) == 0 {
+	if len(instances) == 0 {
 		fmt.Println(utils.StyleWarning("No services were found"))
 		os.Exit(1)
 	}
 
+	if history {
+		sessions, err := src.SelectSessions(srvcsArr, from, to)
+		if err != nil {
+			return err
+		}
+		showHistory(instances, sessions, from, to, now)
+		return nil
+	}
+
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Id", "Machine", "Status", "Name", "Last Seen"})
+	table.SetHeader([]string{"Id", "Service", "Machine", "Status", "Last Seen"})
 
-	for _, s := range srvcs {
-		row := []string{s.Id, s.Machine, getStatus(s.LastSeen), s.Name, s.LastSeen.Format("2006-01-02 15:04:05")}
+	for _, i := range instances {
+		row := []string{i.Id, i.Service, i.Machine, getStatus(i), i.LastSeen.Format("2006-01-02 15:04:05")}
 		table.Append(row)
 	}
 
@@ -54,11 +87,89 @@ func showStatus(name string, args []string) error {
 	return nil
 }
 
-func getStatus(date time.Time) string {
+func getStatus(i *logxhost.Instance) string {
+	if !i.IsActive() {
+		return utils.StyleDanger(string(i.Status))
+	}
 
-	if time.Now().Sub(date) <= 60*time.Second {
+	switch i.LivenessAt(time.Now()) {
+	case logxhost.LivenessAlive:
 		return utils.StyleSuccess("Active")
+	case logxhost.LivenessStale:
+		return utils.StyleWarning("Stale")
 	}
 
 	return utils.StyleDanger("Inactive")
 }
+
+// Width of the timeline bar of each instance.
+const timelineWidth = 60
+
+func showHistory(instances []*logxhost.Instance, sessions []*logxhost.Session, from, to, now time.Time) {
+	fmt.Printf("%s %s - %s\n\n", utils.StyleHighlight("Uptime"), from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05"))
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Service", "Uptime"})
+	for _, u := range logxhost.ServiceUptimes(instances, sessions, from, to, now) {
+		table.Append([]string{u.Service, formatUptime(u)})
+	}
+	table.Render()
+
+	for _, u := range logxhost.InstanceUptimes(instances, sessions, from, to, now) {
+		fmt.Printf("\n%s %s %s\n", utils.StyleWhiteBold(u.Service+" on "+u.Machine), formatUptime(u), utils.StyleWhite(u.InstanceId))
+		fmt.Printf("%s\n", timelineBar(u, from, to))
+		for _, p := range u.Periods {
+			state := utils.StyleSuccess("UP  ")
+			if !p.Up {
+				state = utils.StyleDanger("DOWN")
+			}
+			line := fmt.Sprintf("  %s %s - %s  %s", state, p.Start.Format("2006-01-02 15:04:05"), p.End.Format("2006-01-02 15:04:05"), p.Duration().Round(time.Second))
+			if p.Reason != "" {
+				line += " (" + p.Reason + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+func formatUptime(u *logxhost.Uptime) string {
+	v := fmt.Sprintf("%.2f%%", u.Percent)
+	if u.Percent >= 99 {
+		return utils.StyleSuccess(v)
+	}
+	return utils.StyleDanger(v)
+}
+
+// Draws the periods over the time range, each character being up if the
+// instance was up for most of it.
+func timelineBar(u *logxhost.Uptime, from, to time.Time) string {
+	step := to.Sub(from) / timelineWidth
+	if step <= 0 {
+		return ""
+	}
+	bar := ""
+	for c := 0; c < timelineWidth; c++ {
+		start := from.Add(time.Duration(c) * step)
+		end := start.Add(step)
+		var up time.Duration
+		for _, p := range u.Periods {
+			if !p.Up || !p.End.After(start) || !p.Start.Before(end) {
+				continue
+			}
+			s, e := p.Start, p.End
+			if s.Before(start) {
+				s = start
+			}
+			if e.After(end) {
+				e = end
+			}
+			up += e.Sub(s)
+		}
+		if up*2 >= step {
+			bar += utils.StyleSuccess("█")
+		} else {
+			bar += utils.StyleDanger("▁")
+		}
+	}
+	return "  " + bar
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/monstercat/gologx/logxhost"
+	"github.com/monstercat/gologx/utils"
+)
+
+// Colors the severity of a log.
+func styleSeverity(severity string) string {
+	switch severity {
+	case "FATAL", "ERROR":
+		return utils.StyleDanger(severity)
+	case "WARN":
+		return utils.StyleWarning(severity)
+	case "INFO":
+		return utils.StyleSuccess(severity)
+	default:
+		return utils.StyleWhite(severity)
+	}
+}
+
+func printTailLog(l *logxhost.Log) {
+	var ctx struct {
+		Severity string
+	}
+	json.Unmarshal([]byte(l.Context), &ctx)
+
+	line := fmt.Sprintf("%s %s", utils.StyleWhite(l.LogTime.Format("2006-01-02 15:04:05")), utils.StyleHighlight(l.Service+"@"+l.Machine))
+	if ctx.Severity != "" {
+		line += " " + styleSeverity(ctx.Severity)
+	}
+	fmt.Println(line + " " + l.Message)
+}
+
+func showTail(name string, args []string) error {
+	var q logxhost.LogQuery
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	source := addSourceFlags(set)
+	filters := addFilterFlags(set)
+
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	filters.apply(&q)
+
+	src, err := source.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	die := make(chan bool)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		close(die)
+	}()
+
+	fmt.Println(utils.StyleHighlight("Waiting for logs..."))
+	return src.TailLogs(&q, die, func(logs []*logxhost.Log) error {
+		for _, l := range logs {
+			printTailLog(l)
+		}
+		return nil
+	}, func(err error) {
+		fmt.Println(utils.StyleDanger(err.Error()))
+	})
+}
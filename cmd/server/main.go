@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/smtp"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
 	"github.com/pkg/errors"
 	cmd "github.com/tmathews/commander"
 
@@ -23,8 +36,31 @@ func main() {
 		args = os.Args[1:]
 	}
 	err := cmd.Exec(args, cmd.Manual("Logx - hosted logs", ""), cmd.M{
-		"generate-cert": cmdGenerate,
-		"server":        cmdServer,
+		"generate-cert":      cmdGenerate,
+		"server":             cmdServer,
+		"migrate":            cmdMigrate,
+		"retention":          cmdRetention,
+		"retention-rules":    cmdRetentionRules,
+		"add-retention":      cmdAddRetentionRule,
+		"remove-retention":   cmdRemoveRetentionRule,
+		"revoke-service":     cmdSetServiceStatus(logxhost.ServiceStatusRevoked),
+		"disable-service":    cmdSetServiceStatus(logxhost.ServiceStatusDisabled),
+		"enable-service":     cmdSetServiceStatus(logxhost.ServiceStatusActive),
+		"revoke-hash":        cmdRevokeHash,
+		"api-users":          cmdAPIUsers,
+		"add-api-user":       cmdAddAPIUser,
+		"remove-api-user":    cmdRemoveAPIUser,
+		"roles":              cmdRoles,
+		"add-role":           cmdAddRole,
+		"remove-role":        cmdRemoveRole,
+		"grant-role":         cmdGrantRole(true),
+		"revoke-role":        cmdGrantRole(false),
+		"read-audit":         cmdReadAudit,
+		"alert-rules":        cmdAlertRules,
+		"add-alert-rule":     cmdAddAlertRule,
+		"remove-alert-rule":  cmdRemoveAlertRule,
+		"enable-alert-rule":  cmdSetAlertRuleEnabled(true),
+		"disable-alert-rule": cmdSetAlertRuleEnabled(false),
 	})
 	if err != nil {
 		switch v := err.(type) {
@@ -53,8 +89,12 @@ func getPostgresConnection(url string) (*sqlx.DB, error) {
 // Starts the host logging server.
 func cmdServer(name string, args []string) error {
 	s := &logxhost.Server{}
-	var port int
+	var port, apiPort, metricsPort int
 	var postgres string
+	var migrate, alerts bool
+	var smtpUser, smtpPassword, livenessSink string
+	var shutdownTimeout time.Duration
+	ingester := &logxhost.Ingester{}
 
 	set := flag.NewFlagSet(name, flag.ExitOnError)
 	set.StringVar(&s.CertFile, "cert", "", "Certificate")
@@ -62,9 +102,50 @@ func cmdServer(name string, args []string) error {
 	set.StringVar(&s.Password, "password", "", "Password for clients to use to connect")
 	set.StringVar(&postgres, "postgres", "", "Postgres database")
 	set.IntVar(&port, "port", 9090, "Port")
+	set.IntVar(&apiPort, "api-port", 0, "Port of the read API and web UI. They are disabled if not set")
+	set.IntVar(&metricsPort, "metrics-port", 0, "Port of the Prometheus metrics at /metrics. Disabled if not set")
+	set.BoolVar(&migrate, "migrate", false, "Apply pending database migrations on startup")
+	set.IntVar(&s.PartitionsAhead, "partitions-ahead", logxhost.DefaultPartitionsAhead, "Days of log partitions to create ahead of time")
+	set.BoolVar(&s.RetentionEnabled, "retention", false, "Apply the retention rules every hour")
+	set.BoolVar(&alerts, "alerts", false, "Evaluate the alert rules")
+	set.DurationVar(&s.AlertInterval, "alert-interval", logxhost.DefaultAlertInterval, "How often the alert rules are evaluated")
+	set.StringVar(&s.SMTPAddr, "smtp", "", "SMTP server (host:port) for email alerts")
+	set.StringVar(&s.SMTPFrom, "smtp-from", "", "Sender of email alerts")
+	set.StringVar(&smtpUser, "smtp-user", "", "SMTP user")
+	set.StringVar(&smtpPassword, "smtp-password", "", "SMTP password")
+	set.BoolVar(&s.AlertCommandsEnabled, "alert-commands", false, "Allow alert rules to run shell commands")
+	set.DurationVar(&s.LivenessInterval, "liveness-interval", logxhost.DefaultLivenessInterval, "How often instances are checked for missed heartbeats")
+	set.StringVar(&livenessSink, "liveness-sink", "", "Where to alert when an instance dies or comes back: webhook, smtp or command")
+	set.StringVar(&s.LivenessSinkTarget, "liveness-target", "", "URL, email addresses or shell command receiving the liveness alerts")
+	set.DurationVar(&s.PauseInsertLatency, "pause-latency", logxhost.DefaultPauseInsertLatency, "Tell clients to pause when storing a log takes this long. Negative to never pause them")
+	set.DurationVar(&s.PauseDuration, "pause-duration", logxhost.DefaultPauseDuration, "How long clients are paused for")
+	set.IntVar(&ingester.BatchSize, "batch-size", logxhost.DefaultIngestBatchSize, "Logs written per batch. 1 inserts each log as it is received")
+	set.DurationVar(&ingester.FlushInterval, "flush-interval", logxhost.DefaultIngestFlushInterval, "How long a batch waits for more logs before being written")
+	set.IntVar(&s.MaxConnections, "max-connections", 0, "Client connections accepted at once. Unlimited if 0")
+	set.IntVar(&s.MaxServiceConnections, "max-service-connections", 0, "Client connections accepted at once per service. Unlimited if 0")
+	set.DurationVar(&s.HandshakeTimeout, "handshake-timeout", logxhost.DefaultHandshakeTimeout, "Time allowed for the TLS handshake")
+	set.DurationVar(&s.IdleTimeout, "idle-timeout", logxhost.DefaultIdleTimeout, "Close client connections which send nothing for this long")
+	set.DurationVar(&s.WriteTimeout, "write-timeout", logxhost.DefaultWriteTimeout, "Time allowed to write a message to a client")
+	set.Int64Var(&s.MaxMessageSize, "max-message-size", logxhost.DefaultMaxMessageSize, "Largest message accepted from clients, in bytes. Unlimited if negative")
+	set.Float64Var(&s.ServiceRateLimit, "rate-limit", 0, "Logs per second accepted from each service. Unlimited if 0")
+	set.IntVar(&s.ServiceRateBurst, "rate-burst", 0, "Logs accepted at once from each service above the rate limit. Defaults to the rate limit")
+	set.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed on SIGINT or SIGTERM to store and acknowledge the logs received before exiting")
 	if err := set.Parse(args); err != nil {
 		return err
 	}
+	s.LivenessSinkType = logxhost.AlertSinkType(livenessSink)
+	if livenessSink != "" {
+		if err := s.LivenessSinkType.Validate(s.LivenessSinkTarget); err != nil {
+			return errors.Wrap(err, "invalid liveness sink")
+		}
+	}
+	if smtpUser != "" {
+		host, _, err := net.SplitHostPort(s.SMTPAddr)
+		if err != nil {
+			return errors.Wrap(err, "invalid smtp address")
+		}
+		s.SMTPAuth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
 
 	log.Print("=============================")
 	log.Print("Starting log server... ")
@@ -72,22 +153,109 @@ func cmdServer(name string, args []string) error {
 	log.Printf("Certificate:     %s", s.CertFile)
 	log.Printf("Private Key:     %s", s.KeyFile)
 	log.Printf("Postgres:        %s", postgres)
+	if apiPort != 0 {
+		log.Printf("API Port:        %d", apiPort)
+	}
+	if metricsPort != 0 {
+		log.Printf("Metrics Port:    %d", metricsPort)
+	}
 
 	db, err := getPostgresConnection(postgres)
 	if err != nil {
 		return err
 	}
 	s.DB = db
+	s.PostgresURL = postgres
+	if ingester.BatchSize > 1 {
+		ingester.DB = db
+		s.Ingester = ingester
+		// Stopped by Shutdown, once the logs it was given are written.
+		go ingester.Run(make(chan bool), func(err error) {
+			log.Printf("Ingestion: %s", err)
+		})
+	}
+
+	if migrate {
+		if err := migrateUp(db, 0); err != nil {
+			return err
+		}
+	}
 
 	l, err := s.Listen(port)
 	if err != nil {
 		panic(err)
 	}
 
-	s.Serve(l, func(err error) {
-		log.Print(err)
+	go func() {
+		err := s.ListenRevocations(postgres, make(chan bool), func(err error) {
+			log.Print(err)
+		})
+		if err != nil {
+			log.Printf("Could not listen for revocations: %s", err)
+		}
+	}()
+
+	if apiPort != 0 {
+		al, err := s.ListenAPI(apiPort)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := http.Serve(al, uiHandler(s)); err != nil {
+				log.Printf("API: %s", err)
+			}
+		}()
+	}
+
+	if metricsPort != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		go func() {
+			if err := http.ListenAndServe(":"+strconv.Itoa(metricsPort), mux); err != nil {
+				log.Printf("Metrics: %s", err)
+			}
+		}()
+	}
+
+	go s.MaintainLogs(make(chan bool), func(err error) {
+		log.Printf("Log maintenance: %s", err)
+	})
+
+	go s.MonitorLiveness(make(chan bool), func(err error) {
+		log.Printf("Liveness: %s", err)
 	})
 
+	if alerts {
+		go s.EvaluateAlerts(make(chan bool), func(err error) {
+			log.Printf("Alerts: %s", err)
+		})
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	served := make(chan bool)
+	go func() {
+		s.Serve(l, func(err error) {
+			log.Print(err)
+		})
+		close(served)
+	}()
+
+	select {
+	case <-served:
+		return nil
+	case <-sigCh:
+	}
+	signal.Stop(sigCh)
+
+	log.Print("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "could not shut down gracefully")
+	}
+	log.Print("Stopped")
 	return nil
 }
 
@@ -124,3 +292,668 @@ func cmdGenerate(name string, args []string) error {
 
 	return nil
 }
+
+// Returns the name of the user running the command for the audit log.
+func defaultActor() string {
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return "unknown"
+}
+
+// Changes the status of a service. Revoked and disabled services can no
+// longer register or log on any machine, and their open connections are closed.
+func cmdSetServiceStatus(status logxhost.ServiceStatus) func(string, []string) error {
+	return func(name string, args []string) error {
+		var postgres, id, service, actor, reason string
+
+		set := flag.NewFlagSet(name, flag.ExitOnError)
+		set.StringVar(&postgres, "postgres", "", "Postgres database")
+		set.StringVar(&id, "id", "", "Id of the service")
+		set.StringVar(&service, "service", "", "Name of the service, if id is not provided")
+		set.StringVar(&actor, "by", defaultActor(), "Who is making the change")
+		set.StringVar(&reason, "reason", "", "Reason for the change")
+		if err := set.Parse(args); err != nil {
+			return err
+		}
+
+		db, err := getPostgresConnection(postgres)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var s *logxhost.Service
+		if id != "" {
+			s, err = logxhost.GetService(db, squirrel.Eq{"id": id})
+		} else {
+			s, err = logxhost.GetService(db, squirrel.Eq{"name": service})
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not find service")
+		}
+
+		if err := logxhost.SetServiceStatus(db, s, status, actor, reason); err != nil {
+			return err
+		}
+		log.Printf("Service %s (%s) is now %s", s.Id, s.Name, status)
+		return nil
+	}
+}
+
+// Revokes a certificate hash. The hash can be provided directly or
+// computed from the certificate file.
+func cmdRevokeHash(name string, args []string) error {
+	var postgres, hash, certFile, actor, reason string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&hash, "hash", "", "Certificate hash to revoke")
+	set.StringVar(&certFile, "cert", "", "Certificate to revoke, if hash is not provided")
+	set.StringVar(&actor, "by", defaultActor(), "Who is making the change")
+	set.StringVar(&reason, "reason", "", "Reason for the revocation")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	if hash == "" {
+		if certFile == "" {
+			return errors.New("hash or cert is required")
+		}
+		byt, err := certificateHash(certFile)
+		if err != nil {
+			return err
+		}
+		hash = string(byt)
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := logxhost.RevokeHash(db, []byte(hash), actor, reason); err != nil {
+		return err
+	}
+	log.Printf("Revoked certificate hash %s", hash)
+	return nil
+}
+
+// Reads a PEM certificate and returns the hash identifying it.
+func certificateHash(certFile string) ([]byte, error) {
+	cert, err := readCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+	return logxhost.SignatureHash(cert.Signature), nil
+}
+
+func readCertificate(certFile string) (*x509.Certificate, error) {
+	byt, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(byt)
+	if block == nil {
+		return nil, errors.New("could not decode certificate " + certFile)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Applies, reverts or shows the status of the database migrations.
+//
+//	migrate -postgres ... up [-steps n]
+//	migrate -postgres ... down [-steps n]
+//	migrate -postgres ... status
+func cmdMigrate(name string, args []string) error {
+	var postgres string
+	var steps int
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.IntVar(&steps, "steps", 0, "Number of migrations to apply or revert. Up defaults to all, down to one")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch set.Arg(0) {
+	case "up":
+		return migrateUp(db, steps)
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		done, err := logxhost.MigrateDown(db, steps)
+		for _, m := range done {
+			log.Printf("Reverted %04d %s", m.Version, m.Name)
+		}
+		return err
+	case "status", "":
+		xs, err := logxhost.GetMigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, m := range xs {
+			applied := "pending"
+			if m.Applied != nil {
+				applied = m.Applied.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-20s %s\n", m.Version, m.Name, applied)
+		}
+		return nil
+	default:
+		return errors.New("unknown migrate command " + set.Arg(0) + ". Expected up, down or status")
+	}
+}
+
+func migrateUp(db *sqlx.DB, steps int) error {
+	done, err := logxhost.MigrateUp(db, steps)
+	for _, m := range done {
+		log.Printf("Applied %04d %s", m.Version, m.Name)
+	}
+	if err == nil && len(done) == 0 {
+		log.Print("Database is up to date")
+	}
+	return err
+}
+
+// Shows which logs would be removed by the retention rules, and removes them
+// if -apply is provided.
+func cmdRetention(name string, args []string) error {
+	var postgres string
+	var apply bool
+	var batch int
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.BoolVar(&apply, "apply", false, "Remove the expired logs instead of only showing them")
+	set.IntVar(&batch, "batch", logxhost.RetentionBatchSize, "Number of logs to delete at once")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	plan, err := logxhost.PlanRetention(db, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, p := range plan.Partitions {
+		log.Printf("Partition %s (until %s) has expired", p.Name, p.End.Format("2006-01-02"))
+	}
+	log.Printf("%d expired logs in other partitions", plan.Rows)
+
+	if !apply {
+		return nil
+	}
+	deleted, err := logxhost.ApplyRetention(db, plan, batch)
+	if err != nil {
+		return err
+	}
+	log.Printf("Dropped %d partitions and deleted %d logs", len(plan.Partitions), deleted)
+	return nil
+}
+
+func cmdRetentionRules(name string, args []string) error {
+	var postgres string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rules, err := logxhost.SelectRetentionRules(db)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		service, severity := "*", "*"
+		if r.Service != nil {
+			service = *r.Service
+		}
+		if r.Severity != nil {
+			severity = *r.Severity
+		}
+		fmt.Printf("%s  service=%s severity=%s keep=%d days\n", r.Id, service, severity, r.KeepDays)
+	}
+	return nil
+}
+
+// Adds a retention rule. Leaving out the service or severity makes the
+// rule apply to all of them.
+func cmdAddRetentionRule(name string, args []string) error {
+	var postgres, service, severity string
+	var days int
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&service, "service", "", "Name of the service the rule applies to. Empty for all")
+	set.StringVar(&severity, "severity", "", "Severity the rule applies to (e.g. DEBUG). Empty for all")
+	set.IntVar(&days, "days", 0, "Number of days to keep the logs")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if days <= 0 {
+		return errors.New("days is required")
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rule := &logxhost.RetentionRule{
+		KeepDays: days,
+	}
+	if service != "" {
+		s, err := logxhost.GetService(db, squirrel.Eq{"name": service})
+		if err != nil {
+			return errors.Wrap(err, "could not find service")
+		}
+		rule.ServiceId = &s.Id
+	}
+	if severity != "" {
+		rule.Severity = &severity
+	}
+	if err := dbutil.TxNow(db, rule.Insert); err != nil {
+		return err
+	}
+	log.Printf("Added retention rule %s", rule.Id)
+	return nil
+}
+
+func cmdRemoveRetentionRule(name string, args []string) error {
+	var postgres, id string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&id, "id", "", "Id of the rule")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return logxhost.DeleteRetentionRule(db, id)
+}
+
+func cmdAPIUsers(name string, args []string) error {
+	var postgres string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	users, err := logxhost.SelectAPIUsers(db)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		fmt.Printf("%s  %s token=%t cert=%t created=%s\n", u.Id, u.Name, u.TokenHash != nil, u.CertHash != nil, u.Created.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Adds a user of the read API. A token is generated and printed unless a
+// client certificate is provided.
+func cmdAddAPIUser(name string, args []string) error {
+	var postgres, userName, certFile string
+	var token bool
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&userName, "name", "", "Name of the user")
+	set.StringVar(&certFile, "cert", "", "Client certificate the user authenticates with")
+	set.BoolVar(&token, "token", false, "Generate a token even if a certificate is provided")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if userName == "" {
+		return errors.New("name is required")
+	}
+
+	u := &logxhost.APIUser{Name: userName}
+	if certFile != "" {
+		cert, err := readCertificate(certFile)
+		if err != nil {
+			return err
+		}
+		h := logxhost.CertificateKeyHash(cert)
+		u.CertHash = &h
+	}
+
+	var tok string
+	if certFile == "" || token {
+		var err error
+		if tok, err = logxhost.GenerateAPIToken(); err != nil {
+			return err
+		}
+		h := logxhost.APITokenHash(tok)
+		u.TokenHash = &h
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := dbutil.TxNow(db, u.Insert); err != nil {
+		return err
+	}
+	log.Printf("Added API user %s", u.Id)
+	if tok != "" {
+		fmt.Printf("Token: %s\n", tok)
+	}
+	return nil
+}
+
+func cmdRemoveAPIUser(name string, args []string) error {
+	var postgres, userName string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&userName, "name", "", "Name of the user")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return logxhost.DeleteAPIUser(db, userName)
+}
+
+func cmdRoles(name string, args []string) error {
+	var postgres string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	roles, err := logxhost.SelectRoles(db)
+	if err != nil {
+		return err
+	}
+	for _, r := range roles {
+		fmt.Printf("%s  %s services=%s\n", r.Id, r.Name, strings.Join(r.Services, ","))
+	}
+	return nil
+}
+
+// Adds a role granting read access to the matching services.
+func cmdAddRole(name string, args []string) error {
+	var postgres, roleName, services string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&roleName, "name", "", "Name of the role")
+	set.StringVar(&services, "services", "", "Services the role may read, separated by commas. Supports * wildcards")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if roleName == "" || services == "" {
+		return errors.New("name and services are required")
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r := &logxhost.Role{Name: roleName}
+	for _, s := range strings.Split(services, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			r.Services = append(r.Services, s)
+		}
+	}
+	if err := dbutil.TxNow(db, r.Insert); err != nil {
+		return err
+	}
+	log.Printf("Added role %s", r.Id)
+	return nil
+}
+
+func cmdRemoveRole(name string, args []string) error {
+	var postgres, roleName string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&roleName, "name", "", "Name of the role")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return logxhost.DeleteRole(db, roleName)
+}
+
+// Grants a role to an API user, or revokes it.
+func cmdGrantRole(grant bool) func(string, []string) error {
+	return func(name string, args []string) error {
+		var postgres, userName, roleName string
+
+		set := flag.NewFlagSet(name, flag.ExitOnError)
+		set.StringVar(&postgres, "postgres", "", "Postgres database")
+		set.StringVar(&userName, "user", "", "Name of the API user")
+		set.StringVar(&roleName, "role", "", "Name of the role")
+		if err := set.Parse(args); err != nil {
+			return err
+		}
+
+		db, err := getPostgresConnection(postgres)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		u, err := logxhost.GetAPIUser(db, squirrel.Eq{"name": userName})
+		if err != nil {
+			return errors.Wrap(err, "could not find user")
+		}
+		r, err := logxhost.GetRole(db, squirrel.Eq{"name": roleName})
+		if err != nil {
+			return errors.Wrap(err, "could not find role")
+		}
+		if grant {
+			return logxhost.GrantRole(db, u.Id, r.Id)
+		}
+		return logxhost.RevokeRole(db, u.Id, r.Id)
+	}
+}
+
+// Shows the latest reads made through the API.
+func cmdReadAudit(name string, args []string) error {
+	var postgres, userName string
+	var limit uint64
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&userName, "user", "", "Only show the reads of this API user")
+	set.Uint64Var(&limit, "limit", 50, "Number of reads to show")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	audits, err := logxhost.SelectReadAudits(db, userName, limit)
+	if err != nil {
+		return err
+	}
+	for _, a := range audits {
+		fmt.Printf("%s  %s %s %s\n", a.Created.Format(time.RFC3339), a.UserName, a.Action, a.Query)
+	}
+	return nil
+}
+
+func cmdAlertRules(name string, args []string) error {
+	var postgres string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rules, err := logxhost.SelectAlertRules(db, nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		state := "enabled"
+		if !r.Enabled {
+			state = "disabled"
+		}
+		fmt.Printf("%s  %s (%s) filter=%q threshold=%d window=%s group=%s cooldown=%s %s=%s\n",
+			r.Id, r.Name, state, r.Filter, r.Threshold, r.Window(), strings.Join(r.GroupBy, ","),
+			r.Cooldown(), r.SinkType, r.SinkTarget)
+	}
+	return nil
+}
+
+// Adds an alert rule firing when at least threshold logs match the filter
+// within the window.
+func cmdAddAlertRule(name string, args []string) error {
+	var postgres, groupBy, sink string
+	var window, cooldown time.Duration
+	r := &logxhost.AlertRule{Enabled: true}
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&r.Name, "name", "", "Name of the rule")
+	set.StringVar(&r.Filter, "filter", "", "Filter expression the logs must match, e.g. 'severity>=ERROR'")
+	set.IntVar(&r.Threshold, "threshold", 1, "Number of matching logs firing the rule")
+	set.DurationVar(&window, "window", 5*time.Minute, "Time window the logs are counted over")
+	set.StringVar(&groupBy, "group-by", "", "Count separately per service and/or machine, separated by commas")
+	set.DurationVar(&cooldown, "cooldown", 15*time.Minute, "Time before the rule fires again for the same group")
+	set.StringVar(&sink, "sink", string(logxhost.AlertSinkWebhook), "Where alerts are sent: webhook, smtp or command")
+	set.StringVar(&r.SinkTarget, "target", "", "URL, email addresses or shell command receiving the alerts")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if r.Name == "" || r.SinkTarget == "" {
+		return errors.New("name and target are required")
+	}
+	r.WindowSeconds = int(window / time.Second)
+	r.CooldownSeconds = int(cooldown / time.Second)
+	r.SinkType = logxhost.AlertSinkType(sink)
+	for _, c := range strings.Split(groupBy, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			r.GroupBy = append(r.GroupBy, c)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := dbutil.TxNow(db, r.Insert); err != nil {
+		return err
+	}
+	log.Printf("Added alert rule %s", r.Id)
+	return nil
+}
+
+func cmdRemoveAlertRule(name string, args []string) error {
+	var postgres, ruleName string
+
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	set.StringVar(&postgres, "postgres", "", "Postgres database")
+	set.StringVar(&ruleName, "name", "", "Name of the rule")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := getPostgresConnection(postgres)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return logxhost.DeleteAlertRule(db, ruleName)
+}
+
+func cmdSetAlertRuleEnabled(enabled bool) func(string, []string) error {
+	return func(name string, args []string) error {
+		var postgres, ruleName string
+
+		set := flag.NewFlagSet(name, flag.ExitOnError)
+		set.StringVar(&postgres, "postgres", "", "Postgres database")
+		set.StringVar(&ruleName, "name", "", "Name of the rule")
+		if err := set.Parse(args); err != nil {
+			return err
+		}
+
+		db, err := getPostgresConnection(postgres)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		return logxhost.SetAlertRuleEnabled(db, ruleName, enabled)
+	}
+}
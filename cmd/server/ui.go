@@ -0,0 +1,24 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/monstercat/gologx/logxhost"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// Serves the web UI along with the API it reads from.
+func uiHandler(s *logxhost.Server) http.Handler {
+	static, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", s.APIHandler())
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	return mux
+}
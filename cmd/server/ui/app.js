@@ -0,0 +1,354 @@
+// Web UI of the log server. Everything is read through the read API with
+// the token saved in the browser.
+(function () {
+  'use strict';
+
+  var MAX_TAIL_ROWS = 500;
+
+  var $ = function (id) {
+    return document.getElementById(id);
+  };
+
+  var state = {
+    view: 'search',
+    cursor: {},
+    tail: null
+  };
+
+  function token() {
+    return localStorage.getItem('logxToken') || '';
+  }
+
+  function showError(err) {
+    var el = $('error');
+    el.textContent = err ? String(err.message || err) : '';
+    el.hidden = !err;
+  }
+
+  function request(path, params) {
+    var url = path + (params && params.toString() ? '?' + params.toString() : '');
+    return fetch(url, {headers: {'Authorization': 'Bearer ' + token()}});
+  }
+
+  function getJSON(path, params) {
+    return request(path, params).then(function (res) {
+      return res.json().then(function (body) {
+        if (!res.ok) {
+          throw new Error(body.Error || res.statusText);
+        }
+        return body;
+      });
+    });
+  }
+
+  // Filters of the form as API parameters.
+  function filterParams() {
+    var params = new URLSearchParams();
+    var form = $('filters');
+    ['text', 'filter', 'service', 'machine', 'type', 'severity', 'limit'].forEach(function (name) {
+      var v = form.elements[name].value.trim();
+      if (v) {
+        params.set(name, v);
+      }
+    });
+    ['time_after', 'time_before'].forEach(function (name) {
+      var v = form.elements[name].value;
+      if (v) {
+        params.set(name, new Date(v).toISOString());
+      }
+    });
+    return params;
+  }
+
+  function formatTime(v) {
+    var d = new Date(v);
+    var pad = function (n) {
+      return n < 10 ? '0' + n : n;
+    };
+    return d.getFullYear() + '-' + pad(d.getMonth() + 1) + '-' + pad(d.getDate()) + ' ' +
+      pad(d.getHours()) + ':' + pad(d.getMinutes()) + ':' + pad(d.getSeconds());
+  }
+
+  function cell(text, className) {
+    var td = document.createElement('td');
+    td.textContent = text;
+    if (className) {
+      td.className = className;
+    }
+    return td;
+  }
+
+  function parseContext(log) {
+    try {
+      return JSON.parse(log.Context);
+    } catch (e) {
+      return log.Context;
+    }
+  }
+
+  // Creates the row of a log, which expands to show its context.
+  function logRow(log) {
+    var ctx = parseContext(log);
+    var severity = (ctx && ctx.Severity) || '';
+    var tr = document.createElement('tr');
+    tr.className = 'log';
+    tr.appendChild(cell(formatTime(log.LogTime), 'time'));
+    tr.appendChild(cell(log.Service + '@' + log.Machine));
+    tr.appendChild(cell(severity, 'severity-' + severity));
+    tr.appendChild(cell(log.LogType));
+    tr.appendChild(cell(log.Message, 'message'));
+
+    tr.addEventListener('click', function () {
+      var next = tr.nextSibling;
+      if (next && next.className === 'details') {
+        next.remove();
+        return;
+      }
+      var details = document.createElement('tr');
+      details.className = 'details';
+      var td = document.createElement('td');
+      td.colSpan = 5;
+      var pre = document.createElement('pre');
+      pre.textContent = 'Id: ' + log.Id + '\nCreated: ' + formatTime(log.Created) + '\n\n' +
+        JSON.stringify(ctx, null, 2);
+      td.appendChild(pre);
+      details.appendChild(td);
+      tr.parentNode.insertBefore(details, tr.nextSibling);
+    });
+    return tr;
+  }
+
+  function renderHistogram(buckets) {
+    var svg = $('histogram');
+    while (svg.firstChild) {
+      svg.removeChild(svg.firstChild);
+    }
+    if (!buckets || !buckets.length) {
+      return;
+    }
+    var max = Math.max.apply(null, buckets.map(function (b) {
+      return b.Count;
+    }));
+    var width = 1000 / buckets.length;
+    svg.setAttribute('viewBox', '0 0 1000 100');
+    buckets.forEach(function (b, i) {
+      var h = b.Count / max * 100;
+      var rect = document.createElementNS('http://www.w3.org/2000/svg', 'rect');
+      rect.setAttribute('x', i * width);
+      rect.setAttribute('y', 100 - h);
+      rect.setAttribute('width', Math.max(width - 1, 1));
+      rect.setAttribute('height', h);
+      var title = document.createElementNS('http://www.w3.org/2000/svg', 'title');
+      title.textContent = formatTime(b.Time) + ': ' + b.Count;
+      rect.appendChild(title);
+      svg.appendChild(rect);
+    });
+  }
+
+  function search(page) {
+    var params = filterParams();
+    if (page) {
+      params.set(page.key, page.cursor);
+    }
+    showError(null);
+    var logs = getJSON('/api/logs', params).then(function (res) {
+      var body = $('search-logs');
+      body.innerHTML = '';
+      (res.Logs || []).forEach(function (log) {
+        body.appendChild(logRow(log));
+      });
+      state.cursor = {next: res.Next, prev: res.Prev};
+      $('older').disabled = !res.Next;
+      $('newer').disabled = !res.Prev;
+    });
+    var histogram = page ? Promise.resolve() : getJSON('/api/histogram', filterParams()).then(renderHistogram);
+    return Promise.all([logs, histogram]).catch(showError);
+  }
+
+  function instanceStatus(i) {
+    if (i.Status !== 'Active') {
+      return {text: i.Status, className: 'status-bad'};
+    }
+    // Liveness is kept up to date by the server from the heartbeats.
+    if (i.Liveness === 'alive') {
+      return {text: 'Active', className: 'status-good'};
+    }
+    if (i.Liveness === 'stale') {
+      return {text: 'Stale', className: 'status-warn'};
+    }
+    return {text: 'Inactive', className: 'status-bad'};
+  }
+
+  function loadServices() {
+    var params = new URLSearchParams();
+    var service = $('filters').elements.service.value.trim();
+    if (service) {
+      params.set('service', service);
+    }
+    return getJSON('/api/instances', params).then(function (instances) {
+      var body = $('instances');
+      body.innerHTML = '';
+      instances.forEach(function (i) {
+        var status = instanceStatus(i);
+        var tr = document.createElement('tr');
+        tr.appendChild(cell(i.Service));
+        tr.appendChild(cell(i.Machine));
+        tr.appendChild(cell(status.text, status.className));
+        tr.appendChild(cell(formatTime(i.LastSeen), 'time'));
+        tr.appendChild(cell(i.Id));
+        body.appendChild(tr);
+      });
+    }).catch(showError);
+  }
+
+  // Streams new logs until stopped, reconnecting after the last log
+  // received if the stream ends.
+  function startTail() {
+    var tail = {stopped: false, since: '', controller: null};
+    state.tail = tail;
+    $('tail-toggle').textContent = 'Stop';
+
+    function connect() {
+      if (tail.stopped) {
+        return;
+      }
+      var params = filterParams();
+      params.delete('limit');
+      if (tail.since) {
+        params.set('since', tail.since);
+      }
+      tail.controller = new AbortController();
+      $('tail-status').textContent = 'Connecting...';
+      fetch('/api/tail?' + params.toString(), {
+        headers: {'Authorization': 'Bearer ' + token()},
+        signal: tail.controller.signal
+      }).then(function (res) {
+        if (!res.ok) {
+          return res.json().then(function (body) {
+            throw new Error(body.Error || res.statusText);
+          });
+        }
+        $('tail-status').textContent = 'Waiting for logs...';
+        return read(res.body.getReader());
+      }).catch(function (err) {
+        if (!tail.stopped) {
+          showError(err);
+        }
+      }).then(function () {
+        setTimeout(connect, 1000);
+      });
+    }
+
+    function read(reader) {
+      var decoder = new TextDecoder();
+      var buffer = '';
+      return reader.read().then(function next(chunk) {
+        if (chunk.done) {
+          return;
+        }
+        buffer += decoder.decode(chunk.value, {stream: true});
+        var lines = buffer.split('\n');
+        buffer = lines.pop();
+        lines.forEach(function (line) {
+          if (!line) {
+            return;
+          }
+          var log = JSON.parse(line);
+          addTailLog(log);
+          tail.since = tailCursor(log);
+        });
+        return reader.read().then(next);
+      });
+    }
+
+    connect();
+  }
+
+  // Same encoding as Cursor.String: the creation time in nanoseconds since
+  // the epoch and the id.
+  function tailCursor(log) {
+    var seconds = Math.floor(Date.parse(log.Created) / 1000);
+    var frac = (log.Created.match(/\.(\d+)/) || ['', ''])[1];
+    var value = String(seconds) + (frac + '000000000').slice(0, 9) + ':' + log.Id;
+    return btoa(value).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  }
+
+  function addTailLog(log) {
+    var body = $('tail-logs');
+    body.insertBefore(logRow(log), body.firstChild);
+    while (body.querySelectorAll('tr.log').length > MAX_TAIL_ROWS) {
+      body.removeChild(body.lastChild);
+    }
+  }
+
+  function stopTail() {
+    if (state.tail) {
+      state.tail.stopped = true;
+      if (state.tail.controller) {
+        state.tail.controller.abort();
+      }
+    }
+    state.tail = null;
+    $('tail-toggle').textContent = 'Start';
+    $('tail-status').textContent = '';
+  }
+
+  function show(view) {
+    state.view = view;
+    ['search', 'services', 'tail'].forEach(function (v) {
+      $('view-' + v).hidden = v !== view;
+    });
+    document.querySelectorAll('nav a').forEach(function (a) {
+      a.className = a.getAttribute('data-view') === view ? 'active' : '';
+    });
+    if (view === 'search') {
+      search();
+    } else if (view === 'services') {
+      loadServices();
+    }
+  }
+
+  $('token').value = token();
+  $('token-form').addEventListener('submit', function (e) {
+    e.preventDefault();
+    localStorage.setItem('logxToken', $('token').value);
+    show(state.view);
+  });
+
+  $('filters').addEventListener('submit', function (e) {
+    e.preventDefault();
+    if (state.view === 'tail') {
+      if (state.tail) {
+        stopTail();
+        startTail();
+      }
+      return;
+    }
+    show(state.view);
+  });
+
+  $('older').addEventListener('click', function () {
+    search({key: 'page_after', cursor: state.cursor.next});
+  });
+  $('newer').addEventListener('click', function () {
+    search({key: 'page_before', cursor: state.cursor.prev});
+  });
+
+  $('tail-toggle').addEventListener('click', function () {
+    state.tail ? stopTail() : startTail();
+  });
+  $('tail-clear').addEventListener('click', function () {
+    $('tail-logs').innerHTML = '';
+  });
+
+  window.addEventListener('hashchange', function () {
+    show(location.hash.slice(1) || 'search');
+  });
+  setInterval(function () {
+    if (state.view === 'services') {
+      loadServices();
+    }
+  }, 10000);
+
+  show(location.hash.slice(1) || 'search');
+})();
@@ -0,0 +1,196 @@
+package logx
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Host accepting the connections of a HostHandler, which registers any
+// client and acknowledges every log, as a duplicate if it was already
+// received.
+type fakeHost struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	received []HostMessage
+	stored   map[string]bool
+
+	// Connections to drop, after receiving dropAfter logs without
+	// acknowledging them.
+	drops     int
+	dropAfter int
+
+	// Logs to receive without acknowledging them.
+	ignore int
+
+	// Tells new connections to pause for this long, if set.
+	pause time.Duration
+
+	// Never answers if set, and receives the connections.
+	silent chan bool
+}
+
+func newFakeHost(t *testing.T, dir string) *fakeHost {
+	cert, key, err := GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "host.pem"), filepath.Join(dir, "host.key")
+	if err := WriteCertificate(cert, certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePrivateKey(key, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &fakeHost{listener: l, stored: make(map[string]bool)}
+	go h.serve()
+	return h
+}
+
+func (h *fakeHost) Addr() string {
+	return h.listener.Addr().String()
+}
+
+func (h *fakeHost) Close() {
+	h.listener.Close()
+}
+
+// DropConnections makes the next n connections close after receiving the
+// given number of logs, without acknowledging them.
+func (h *fakeHost) DropConnections(n, after int) {
+	h.mu.Lock()
+	h.drops, h.dropAfter = n, after
+	h.mu.Unlock()
+}
+
+// IgnoreLogs makes the host not acknowledge the next n logs.
+func (h *fakeHost) IgnoreLogs(n int) {
+	h.mu.Lock()
+	h.ignore = n
+	h.mu.Unlock()
+}
+
+// PauseConnections makes the host tell new connections to pause for d.
+func (h *fakeHost) PauseConnections(d time.Duration) {
+	h.mu.Lock()
+	h.pause = d
+	h.mu.Unlock()
+}
+
+// Silence makes the host read new connections without ever answering. The
+// returned channel receives them.
+func (h *fakeHost) Silence() <-chan bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.silent = make(chan bool, 1)
+	return h.silent
+}
+
+func (h *fakeHost) Received() []HostMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]HostMessage(nil), h.received...)
+}
+
+func (h *fakeHost) serve() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+		go h.handle(conn)
+	}
+}
+
+func (h *fakeHost) handle(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	drop := -1
+
+	h.mu.Lock()
+	pause, silent := h.pause, h.silent
+	h.mu.Unlock()
+	if silent != nil {
+		select {
+		case silent <- true:
+		default:
+		}
+		io.Copy(ioutil.Discard, conn)
+		return
+	}
+	if pause > 0 {
+		enc.Encode(ClientMessage{Type: MsgTypePause, Status: ClientMessageStatusSuccessful, Message: pause.String()})
+	}
+	for {
+		var m HostMessage
+		if err := dec.Decode(&m); err != nil {
+			return
+		}
+		if m.Type == MsgTypeHeartbeat {
+			continue
+		}
+		h.mu.Lock()
+		h.received = append(h.received, m)
+		status := ClientMessageStatusSuccessful
+		if h.stored[m.Id] {
+			status = ClientMessageStatusDuplicate
+		}
+		if m.Id != "" {
+			h.stored[m.Id] = true
+		}
+		ignore := h.ignore > 0 && m.Type != MsgTypeRegister
+		if ignore {
+			h.ignore--
+		}
+		if drop < 0 && m.Type != MsgTypeRegister {
+			drop = 0
+			if h.drops > 0 {
+				h.drops--
+				drop = h.dropAfter
+			}
+		}
+		h.mu.Unlock()
+		if ignore {
+			continue
+		}
+		if drop > 0 {
+			if drop--; drop == 0 {
+				return
+			}
+			continue
+		}
+		enc.Encode(ClientMessage{Type: m.Type, Status: status, Id: m.Id})
+	}
+}
+
+func newTestHostHandler(t *testing.T, dir, endpoint string) *HostHandler {
+	return &HostHandler{
+		Endpoint:          endpoint,
+		Machine:           "machine",
+		Service:           "service",
+		CertFile:          filepath.Join(dir, "client.pem"),
+		KeyFile:           filepath.Join(dir, "client.key"),
+		CacheFileLocation: filepath.Join(dir, "cache.db"),
+		HeartBeatDuration: time.Hour,
+		WaitDuration:      time.Hour, // Only send when flushing.
+	}
+}
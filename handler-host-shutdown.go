@@ -0,0 +1,105 @@
+package logx
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/etcd-io/bbolt"
+)
+
+// How often Flush checks whether the pending logs were acknowledged.
+var FlushPollInterval = 10 * time.Millisecond
+
+// Flush sends the pending logs right away and blocks until the host has
+// acknowledged all of them, or the context is done. The handler must be
+// running for the logs to be sent.
+func (h *HostHandler) Flush(ctx context.Context) error {
+	h.initStopChannels()
+	for {
+		n, err := h.pendingCount()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		select {
+		case h.flush <- true:
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(FlushPollInterval):
+		}
+	}
+}
+
+// Number of logs in the cache, which haven't been acknowledged by the host.
+func (h *HostHandler) pendingCount() (int, error) {
+	if h.db == nil {
+		return 0, nil
+	}
+	var n int
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket(BucketName); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Shutdown flushes the pending logs, then stops the handler and closes the
+// cache. It returns the error of Flush, e.g. if the context expired before
+// all logs were sent; those logs stay in the cache and are sent the next
+// time the handler runs.
+func (h *HostHandler) Shutdown(ctx context.Context) error {
+	err := h.Flush(ctx)
+	h.Close()
+	return err
+}
+
+// ShutdownOnSignal shuts the handler down, giving it the timeout to flush,
+// when the process receives one of the signals, or SIGINT or SIGTERM if
+// none are provided. done is then called with the result of Shutdown. If
+// done is nil, the process exits.
+//
+// The returned function stops listening for the signals.
+func (h *HostHandler) ShutdownOnSignal(timeout time.Duration, done func(error), signals ...os.Signal) func() {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, signals...)
+	stop := make(chan bool)
+
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-sigCh:
+		}
+		signal.Stop(sigCh)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := h.Shutdown(ctx)
+		cancel()
+		if done != nil {
+			done(err)
+			return
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(stop)
+	}
+}
@@ -0,0 +1,111 @@
+package logx
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHostHandlerShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := newFakeHost(t, dir)
+	defer host.Close()
+
+	h := newTestHostHandler(t, dir, host.Addr())
+	for i := 0; i < 3; i++ {
+		if err := h.Store(&BaseHostLog{Type: "Test", Time: time.Now(), Message: []byte("message")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errCh := make(chan error)
+	stopped := make(chan bool)
+	go func() {
+		for err := range errCh {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		close(stopped)
+	}()
+	go h.Run(errCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatalf("Could not shut down: %s", err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Run to return after shutting down")
+	}
+
+	// The register message and the logs.
+	if n := len(host.Received()); n != 4 {
+		t.Errorf("Expected the host to receive 4 messages, got %d", n)
+	}
+}
+
+func TestHostHandlerShutdownSilentHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-silent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := newFakeHost(t, dir)
+	defer host.Close()
+	connected := host.Silence()
+
+	// Waits for the answer to its registration.
+	h := newTestHostHandler(t, dir, host.Addr())
+	if err := h.StartDb(); err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 10)
+	go h.RunForever(errCh)
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the handler to connect")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	h.Shutdown(ctx)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the shutdown not to wait for the host, took %s", d)
+	}
+	for err := range errCh {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestHostHandlerFlushTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-flush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Not running, so nothing can be sent.
+	h := newTestHostHandler(t, dir, "127.0.0.1:1")
+	if err := h.Store(&BaseHostLog{Type: "Test", Time: time.Now(), Message: []byte("message")}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the flush to time out, got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package logx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/etcd-io/bbolt"
+)
+
+// ConnectionState is the state of the connection of a HostHandler to the
+// host.
+type ConnectionState string
+
+const (
+	ConnectionStateDisconnected ConnectionState = "disconnected"
+	ConnectionStateConnecting   ConnectionState = "connecting"
+	ConnectionStateConnected    ConnectionState = "connected"
+
+	// Waiting to reconnect after the connection was lost or could not be
+	// established.
+	ConnectionStateWaiting ConnectionState = "waiting"
+)
+
+// HostHandlerStats tells whether a HostHandler is delivering its logs.
+type HostHandlerStats struct {
+	State ConnectionState
+
+	// Whether the host asked to stop sending logs for now.
+	Paused bool
+
+	// Logs in the cache which haven't been acknowledged by the host, and
+	// how long ago the oldest of them was logged.
+	Pending          int
+	OldestPendingAge time.Duration
+
+	// Logs sent, and acknowledged by the host as stored or failed.
+	// Duplicates are the acknowledged logs which the host had already
+	// stored.
+	Sent       uint64
+	Acked      uint64
+	Failed     uint64
+	Duplicates uint64
+
+	// Logs sent per second over the last minute.
+	SendRate float64
+
+	// Time between sending a log and its acknowledgement, for the last
+	// acknowledgement and on average.
+	LastAckLatency    time.Duration
+	AverageAckLatency time.Duration
+
+	// Times the connection to the host was re-established.
+	Reconnects uint64
+
+	// Logs sent again as they weren't acknowledged within the AckTimeout.
+	AckTimeouts uint64
+
+	LastError     string    `json:",omitempty"`
+	LastErrorTime time.Time `json:",omitempty"`
+}
+
+// Delivery statistics of a HostHandler. The zero value is ready to use.
+type hostStats struct {
+	mu sync.Mutex
+
+	state      ConnectionState
+	sent       uint64
+	acked      uint64
+	failed     uint64
+	duplicates uint64
+	reconnects uint64
+	timeouts   uint64
+	sendRate   rateCounter
+
+	// When each unacknowledged log was sent, by id.
+	sentAt          map[string]time.Time
+	lastAckLatency  time.Duration
+	totalAckLatency time.Duration
+
+	lastError     error
+	lastErrorTime time.Time
+}
+
+func (s *hostStats) setState(state ConnectionState) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+func (s *hostStats) reconnected() {
+	s.mu.Lock()
+	s.reconnects++
+	s.mu.Unlock()
+}
+
+func (s *hostStats) ackTimedOut(n int) {
+	s.mu.Lock()
+	s.timeouts += uint64(n)
+	s.mu.Unlock()
+}
+
+// Logs sent on a lost connection are sent again, so their latency is
+// measured from then.
+func (s *hostStats) connectionLost() {
+	s.mu.Lock()
+	s.sentAt = nil
+	s.mu.Unlock()
+}
+
+func (s *hostStats) logSent(id string, now time.Time) {
+	s.mu.Lock()
+	if s.sentAt == nil {
+		s.sentAt = make(map[string]time.Time)
+	}
+	s.sentAt[id] = now
+	s.sent++
+	s.sendRate.add(now)
+	s.mu.Unlock()
+}
+
+func (s *hostStats) logAcked(id string, status ClientMessageStatus, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent, ok := s.sentAt[id]
+	if !ok {
+		return
+	}
+	delete(s.sentAt, id)
+	if status == ClientMessageStatusFailed {
+		s.failed++
+	} else {
+		s.acked++
+	}
+	if status == ClientMessageStatusDuplicate {
+		s.duplicates++
+	}
+	s.lastAckLatency = now.Sub(sent)
+	s.totalAckLatency += s.lastAckLatency
+}
+
+func (s *hostStats) setError(err error, now time.Time) {
+	s.mu.Lock()
+	s.lastError = err
+	s.lastErrorTime = now
+	s.mu.Unlock()
+}
+
+// Counts events over the last minute, in one second buckets.
+type rateCounter struct {
+	counts [60]uint64
+	secs   [60]int64
+}
+
+func (r *rateCounter) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % int64(len(r.counts))
+	if r.secs[i] != sec {
+		r.secs[i] = sec
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+}
+
+// Events per second over the last minute.
+func (r *rateCounter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	var total uint64
+	for i, s := range r.secs {
+		if sec-s < int64(len(r.counts)) {
+			total += r.counts[i]
+		}
+	}
+	return float64(total) / float64(len(r.counts))
+}
+
+// Records the error, so that it is reported by Stats, and sends it to the
+// error channel.
+func (h *HostHandler) reportError(errCh chan error, err error) {
+	h.stats.setError(err, time.Now())
+	errCh <- err
+}
+
+// Stats returns the delivery statistics of the handler.
+func (h *HostHandler) Stats() (HostHandlerStats, error) {
+	now := time.Now()
+	s := &h.stats
+	s.mu.Lock()
+	stats := HostHandlerStats{
+		State:          s.state,
+		Sent:           s.sent,
+		Acked:          s.acked,
+		Failed:         s.failed,
+		Duplicates:     s.duplicates,
+		SendRate:       s.sendRate.rate(now),
+		LastAckLatency: s.lastAckLatency,
+		Reconnects:     s.reconnects,
+		AckTimeouts:    s.timeouts,
+		LastErrorTime:  s.lastErrorTime,
+	}
+	if n := s.acked + s.failed; n > 0 {
+		stats.AverageAckLatency = s.totalAckLatency / time.Duration(n)
+	}
+	if s.lastError != nil {
+		stats.LastError = s.lastError.Error()
+	}
+	s.mu.Unlock()
+	if stats.State == "" {
+		stats.State = ConnectionStateDisconnected
+	}
+	stats.Paused = h.pauseRemaining(now) > 0
+
+	if h.db == nil {
+		return stats, nil
+	}
+	var oldest time.Time
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(BucketName)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var l BaseHostLog
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			stats.Pending++
+			if oldest.IsZero() || l.Time.Before(oldest) {
+				oldest = l.Time
+			}
+			return nil
+		})
+	})
+	if !oldest.IsZero() {
+		stats.OldestPendingAge = now.Sub(oldest)
+	}
+	return stats, err
+}
+
+// StatsHandler serves the statistics of the handler, for a local debug
+// endpoint. They are served as JSON, or in the Prometheus text format if
+// the format parameter is prometheus.
+func (h *HostHandler) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := h.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "prometheus" {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			stats.WriteMetrics(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+}
+
+// WriteMetrics writes the statistics in the Prometheus text format.
+func (s HostHandlerStats) WriteMetrics(w io.Writer) {
+	for _, state := range []ConnectionState{ConnectionStateDisconnected, ConnectionStateConnecting, ConnectionStateConnected, ConnectionStateWaiting} {
+		v := 0
+		if s.State == state {
+			v = 1
+		}
+		fmt.Fprintf(w, "logx_client_connection_state{state=%q} %d\n", state, v)
+	}
+	paused := 0
+	if s.Paused {
+		paused = 1
+	}
+	fmt.Fprintf(w, "logx_client_paused %d\n", paused)
+	fmt.Fprintf(w, "logx_client_pending_logs %d\n", s.Pending)
+	fmt.Fprintf(w, "logx_client_oldest_pending_age_seconds %g\n", s.OldestPendingAge.Seconds())
+	fmt.Fprintf(w, "logx_client_sent_total %d\n", s.Sent)
+	fmt.Fprintf(w, "logx_client_acked_total %d\n", s.Acked)
+	fmt.Fprintf(w, "logx_client_failed_total %d\n", s.Failed)
+	fmt.Fprintf(w, "logx_client_duplicates_total %d\n", s.Duplicates)
+	fmt.Fprintf(w, "logx_client_send_rate %g\n", s.SendRate)
+	fmt.Fprintf(w, "logx_client_ack_latency_seconds %g\n", s.LastAckLatency.Seconds())
+	fmt.Fprintf(w, "logx_client_average_ack_latency_seconds %g\n", s.AverageAckLatency.Seconds())
+	fmt.Fprintf(w, "logx_client_reconnects_total %d\n", s.Reconnects)
+	fmt.Fprintf(w, "logx_client_ack_timeouts_total %d\n", s.AckTimeouts)
+	if !s.LastErrorTime.IsZero() {
+		fmt.Fprintf(w, "logx_client_last_error_timestamp_seconds %d\n", s.LastErrorTime.Unix())
+	}
+}
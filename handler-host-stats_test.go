@@ -0,0 +1,122 @@
+package logx
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateCounter(t *testing.T) {
+	var r rateCounter
+	now := time.Unix(1000, 0)
+	for i := 0; i < 120; i++ {
+		r.add(now.Add(time.Duration(i) * time.Second))
+	}
+	r.add(now.Add(119 * time.Second))
+
+	// Only the last minute counts.
+	if rate := r.rate(now.Add(119 * time.Second)); rate != 61.0/60 {
+		t.Errorf("Expected rate of 61/60, got %f", rate)
+	}
+	if rate := r.rate(now.Add(300 * time.Second)); rate != 0 {
+		t.Errorf("Expected rate of 0, got %f", rate)
+	}
+}
+
+func TestHostHandlerStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &HostHandler{CacheFileLocation: filepath.Join(dir, "cache.db")}
+	defer func() {
+		h.db.Close()
+	}()
+
+	stats, err := h.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.State != ConnectionStateDisconnected || stats.Pending != 0 {
+		t.Errorf("Expected no stats before starting, got %+v", stats)
+	}
+
+	logTime := time.Now().Add(-time.Minute)
+	for i := 0; i < 3; i++ {
+		l := &BaseHostLog{Type: "Test", Time: logTime.Add(time.Duration(i) * time.Second), Message: []byte("message")}
+		if err := h.Store(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	h.stats.setState(ConnectionStateConnected)
+	h.stats.logSent("a", now)
+	h.stats.logSent("b", now)
+	h.stats.logSent("c", now)
+	h.stats.logAcked("a", ClientMessageStatusSuccessful, now.Add(10*time.Millisecond))
+	h.stats.logAcked("c", ClientMessageStatusDuplicate, now.Add(20*time.Millisecond))
+	h.stats.logAcked("b", ClientMessageStatusFailed, now.Add(30*time.Millisecond))
+	h.stats.logAcked("unknown", ClientMessageStatusSuccessful, now.Add(time.Second))
+	h.stats.reconnected()
+	h.stats.setError(errors.New("connection reset"), now)
+
+	stats, err = h.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.State != ConnectionStateConnected {
+		t.Errorf("Expected state to be connected, got %s", stats.State)
+	}
+	if stats.Pending != 3 {
+		t.Errorf("Expected 3 pending logs, got %d", stats.Pending)
+	}
+	if stats.OldestPendingAge < time.Minute {
+		t.Errorf("Expected oldest pending log to be a minute old, got %s", stats.OldestPendingAge)
+	}
+	if stats.Sent != 3 || stats.Acked != 2 || stats.Failed != 1 || stats.Duplicates != 1 {
+		t.Errorf("Expected 3 sent, 2 acked, 1 failed and 1 duplicate, got %d, %d, %d and %d", stats.Sent, stats.Acked, stats.Failed, stats.Duplicates)
+	}
+	if stats.LastAckLatency != 30*time.Millisecond || stats.AverageAckLatency != 20*time.Millisecond {
+		t.Errorf("Expected ack latency of 30ms and 20ms on average, got %s and %s", stats.LastAckLatency, stats.AverageAckLatency)
+	}
+	if stats.SendRate != 3.0/60 {
+		t.Errorf("Expected send rate of 3/60, got %f", stats.SendRate)
+	}
+	if stats.Reconnects != 1 || stats.LastError != "connection reset" {
+		t.Errorf("Expected a reconnect and the last error, got %d and %s", stats.Reconnects, stats.LastError)
+	}
+
+	rec := httptest.NewRecorder()
+	h.StatsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var served HostHandlerStats
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if served.Pending != 3 || served.State != ConnectionStateConnected {
+		t.Errorf("Expected stats as JSON, got %+v", served)
+	}
+
+	rec = httptest.NewRecorder()
+	h.StatsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/?format=prometheus", nil))
+	out := rec.Body.String()
+	for _, e := range []string{
+		`logx_client_connection_state{state="connected"} 1`,
+		`logx_client_connection_state{state="disconnected"} 0`,
+		"logx_client_pending_logs 3\n",
+		"logx_client_sent_total 3\n",
+		"logx_client_reconnects_total 1\n",
+	} {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected metrics to contain %q. Got:\n%s", e, out)
+		}
+	}
+}
@@ -6,13 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/etcd-io/bbolt"
 	uuid "github.com/nu7hatch/gouuid"
-
-	stringutil "github.com/monstercat/golib/string"
 )
 
 const (
@@ -20,8 +20,29 @@ const (
 	MsgTypeHeartbeat     = "Heartbeat"
 	MsgTypeRegister      = "Register"
 	MsgTypeAuthorization = "Authorization"
+
+	// Sent by the host to tell the client to stop sending logs for the
+	// duration in the message, e.g. "10s", or until it is told to resume.
+	MsgTypePause  = "Pause"
+	MsgTypeResume = "Resume"
+
+	// Sent by the host before closing a connection over its limits.
+	MsgTypeLimit = "Limit"
 )
 
+const (
+	DefaultReconnectMinDelay = 100 * time.Millisecond
+	DefaultReconnectMaxDelay = 30 * time.Second
+	DefaultAckTimeout        = 30 * time.Second
+	DefaultDialTimeout       = 10 * time.Second
+
+	// Pause used when the host doesn't say for how long.
+	DefaultPauseDuration = 10 * time.Second
+)
+
+// Connections lasting at least this long reset the reconnection delay.
+var ReconnectResetAfter = 10 * time.Second
+
 var (
 	BucketName = []byte("logx")
 
@@ -35,7 +56,8 @@ var (
 // a certain frequency, but at certain times
 // (e.g., host panic or signal interruption)
 // a Flush() command is provided to flush that data
-// directly to the server.
+// directly to the server. Shutdown() flushes and then
+// stops the handler.
 type HostHandler struct {
 	// This is the endpoint of the host to which
 	// this logger is connected.
@@ -64,6 +86,24 @@ type HostHandler struct {
 	// Period at which to send the heartbeat.
 	HeartBeatDuration time.Duration
 
+	// Delays between the connection attempts of RunForever. The delay
+	// doubles after every failed attempt, from ReconnectMinDelay up to
+	// ReconnectMaxDelay, and is randomized by up to half so that clients
+	// don't all reconnect at once when the host comes back. Default to
+	// DefaultReconnectMinDelay and DefaultReconnectMaxDelay.
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+
+	// Time to wait for the host to acknowledge a log before sending it
+	// again. Defaults to DefaultAckTimeout. Logs are only removed from the
+	// cache once acknowledged, so every log is delivered at least once; the
+	// host ignores the copies of logs it has already stored.
+	AckTimeout time.Duration
+
+	// Time to wait for the connection to the host, and for the host to
+	// answer the registration. Defaults to DefaultDialTimeout.
+	DialTimeout time.Duration
+
 	// Cache to store unsent messages.
 	// This should be a directory. The system on startup
 	// will attempt to read this directory for any existing files and
@@ -71,13 +111,28 @@ type HostHandler struct {
 	CacheFileLocation string
 	db                *bbolt.DB
 
-	// List of filenames/Ids that are currently being sent to the server,
-	// so they do not get sent again.
-	currentlySending   []string
+	// Ids of the logs that are currently being sent to the server, with
+	// when they were sent, so they do not get sent again until their
+	// acknowledgement is overdue.
+	currentlySending   map[string]time.Time
 	currentlySendingMu sync.RWMutex
 
 	// Channel to stop processing
-	die chan bool
+	die      chan bool
+	stopOnce sync.Once
+	stopMu   sync.Mutex
+
+	// Sends the pending logs right away. See Flush.
+	flush chan bool
+
+	// Run and RunForever, so that stopping can wait for them.
+	running sync.WaitGroup
+
+	// Logs are kept in the cache until then, when the host asked to pause.
+	pausedUntil time.Time
+	pauseMu     sync.Mutex
+
+	stats hostStats
 }
 
 // Host Message is messages that are sent to the host.
@@ -91,6 +146,10 @@ type HostMessage struct {
 	// Only used by the register message
 	Machine string
 	Service string
+
+	// Period at which the client sends heartbeats, sent when registering so
+	// the host knows when the service has gone silent.
+	HeartbeatInterval time.Duration `json:",omitempty"`
 }
 
 // Client messages are messsages sent to the client.
@@ -106,6 +165,10 @@ type ClientMessageStatus string
 const (
 	ClientMessageStatusFailed     ClientMessageStatus = "Failed"
 	ClientMessageStatusSuccessful ClientMessageStatus = "Successful"
+
+	// The log was already stored, e.g. when it is sent again after its
+	// acknowledgement was lost. It can be removed from the cache.
+	ClientMessageStatusDuplicate ClientMessageStatus = "Duplicate"
 )
 
 // Handle handles incoming logs by storing them
@@ -188,49 +251,126 @@ func (h *HostHandler) Store(l HostLog) error {
 }
 
 func (h *HostHandler) initStopChannels() {
-	h.die = make(chan bool)
+	h.stopMu.Lock()
+	if h.die == nil {
+		h.die = make(chan bool)
+		h.flush = make(chan bool, 1)
+	}
+	h.stopMu.Unlock()
 }
 
+// Stops all goroutines of the handler. It can be called more than once.
+func (h *HostHandler) stop() {
+	h.initStopChannels()
+	h.stopOnce.Do(func() {
+		close(h.die)
+	})
+}
+
+func (h *HostHandler) stopping() bool {
+	select {
+	case <-h.die:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the handler without waiting for the pending logs to be sent,
+// waits for its goroutines to return and closes the cache. The pending logs
+// are sent the next time the handler runs. See Shutdown.
 func (h *HostHandler) Close() {
+	h.stop()
+	h.running.Wait()
 	if h.db != nil {
 		h.db.Close()
 	}
-	close(h.die)
 }
 
+// RunForever runs the host handler until it is closed, reconnecting
+// whenever the connection is lost. Registering is retried as well, so the
+// host doesn't need to be reachable when starting. Errors are sent to errCh,
+// which is closed when the handler stops.
+//
+// Logs which were sent but not acknowledged when the connection was lost
+// are sent again on the next connection.
 func (h *HostHandler) RunForever(errCh chan error) {
+	h.running.Add(1)
+	defer h.running.Done()
 	defer close(errCh)
+	defer h.stats.setState(ConnectionStateDisconnected)
 
-	if err := h.Startup(); err != nil {
-		errCh <- err
+	if err := h.prepare(); err != nil {
+		h.reportError(errCh, err)
 		return
 	}
 	defer h.db.Close()
 
-	currDelay := 5 * time.Millisecond
-	now := time.Now()
+	var registered, connected bool
+	var attempt int
 	for {
 		select {
 		case <-h.die:
 			return
-		case <-time.After(100 * time.Millisecond):
+		default:
 		}
 
-		// Continually restart!
-		h.run(errCh)
-
-		if time.Now().Sub(now).Seconds() < 10 {
-			time.Sleep(currDelay)
-			currDelay *= 2
-			if currDelay > time.Second {
-				currDelay = time.Second
+		start := time.Now()
+		if !registered {
+			h.stats.setState(ConnectionStateConnecting)
+			if err := h.Register(); err != nil {
+				// Failed because the connection was closed to stop.
+				if h.stopping() {
+					return
+				}
+				h.reportError(errCh, err)
+			} else {
+				registered = true
+			}
+		}
+		if registered {
+			if connected {
+				h.stats.reconnected()
 			}
-		} else {
-			currDelay = 5 * time.Millisecond
+			connected = true
+			h.run(errCh)
+		}
+
+		// A connection which lasted is not a failed attempt.
+		if time.Since(start) >= ReconnectResetAfter {
+			attempt = 0
+		}
+		h.stats.setState(ConnectionStateWaiting)
+		delay := h.reconnectDelay(attempt)
+		attempt++
+		select {
+		case <-h.die:
+			return
+		case <-time.After(delay):
 		}
 	}
 }
 
+// Returns the delay before the next connection attempt. See
+// ReconnectMinDelay.
+func (h *HostHandler) reconnectDelay(attempt int) time.Duration {
+	min, max := h.ReconnectMinDelay, h.ReconnectMaxDelay
+	if min <= 0 {
+		min = DefaultReconnectMinDelay
+	}
+	if max <= 0 {
+		max = DefaultReconnectMaxDelay
+	}
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
 // Run will run the host handler. As it contains
 // an infinite loop, it should be called in a
 // go routine.
@@ -238,10 +378,12 @@ func (h *HostHandler) RunForever(errCh chan error) {
 // This function will call startup as well, so
 // calling startup is not necessary.
 func (h *HostHandler) Run(errCh chan error) {
+	h.running.Add(1)
+	defer h.running.Done()
 	defer close(errCh)
 
 	if err := h.Startup(); err != nil {
-		errCh <- err
+		h.reportError(errCh, err)
 		return
 	}
 	defer h.db.Close()
@@ -250,28 +392,73 @@ func (h *HostHandler) Run(errCh chan error) {
 }
 
 func (h *HostHandler) run(errCh chan error) {
+	h.stats.setState(ConnectionStateConnecting)
+	defer h.stats.setState(ConnectionStateDisconnected)
 
 	conn, err := h.connect()
 	if err != nil {
-		errCh <- err
+		h.reportError(errCh, err)
 		return
 	}
-	defer conn.Close()
+	h.stats.setState(ConnectionStateConnected)
 
+	// The goroutines of this connection are stopped and waited for before
+	// returning, so that none of them use the connection, or the error
+	// channel which is closed by Run.
 	wrCh := make(chan HostMessage)
-
-	go h.RunHeartbeat(wrCh)
-	go h.ReadResponses(conn, errCh)
-	go h.SendLogs(wrCh, errCh)
+	stop := make(chan bool)
+	readDone := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.closeOnStop(conn, stop)
+	}()
+	defer func() {
+		close(stop)
+		conn.Close()
+		wg.Wait()
+
+		// Logs which weren't acknowledged are sent again on the next
+		// connection.
+		h.currentlySendingMu.Lock()
+		h.currentlySending = nil
+		h.currentlySendingMu.Unlock()
+		h.stats.connectionLost()
+	}()
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		h.runHeartbeat(wrCh, stop)
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(readDone)
+		h.readResponses(conn, errCh, stop)
+	}()
+	go func() {
+		defer wg.Done()
+		h.sendLogs(wrCh, errCh, stop)
+	}()
 
 	// This for loop actually writes all the responses.
 	for {
 		select {
 		case <-h.die:
 			return
+		case <-readDone:
+			// The connection was closed by the host.
+			return
 		case msg := <-wrCh:
+			// Recorded before sending, as the response may arrive first.
+			if msg.Id != "" {
+				h.stats.logSent(msg.Id, time.Now())
+			}
 			if err := h.sendToHost(conn, msg); err != nil {
-				errCh <- err
+				if !h.stopping() {
+					h.reportError(errCh, err)
+				}
 
 				// If the function returns, the wrapping function should
 				// know to restart!
@@ -287,46 +474,134 @@ func (h *HostHandler) run(errCh chan error) {
 // It does this by reading the CacheFileLocation for any files containing
 // a log or logs.
 func (h *HostHandler) SendLogs(wrCh chan HostMessage, errCh chan error) {
+	h.sendLogs(wrCh, errCh, h.die)
+}
+
+// Stopped sending as the stop channel was closed, or the host asked to
+// pause.
+var (
+	errStopped = errors.New("stopped")
+	errPaused  = errors.New("paused")
+)
+
+// Pauses or resumes sending the logs if the message asks to, and returns
+// whether it did.
+func (h *HostHandler) handleFlowControl(m ClientMessage) bool {
+	switch m.Type {
+	case MsgTypePause:
+		d, err := time.ParseDuration(m.Message)
+		if err != nil || d <= 0 {
+			d = DefaultPauseDuration
+		}
+		h.pauseMu.Lock()
+		h.pausedUntil = time.Now().Add(d)
+		h.pauseMu.Unlock()
+		return true
+	case MsgTypeResume:
+		h.pauseMu.Lock()
+		h.pausedUntil = time.Time{}
+		h.pauseMu.Unlock()
+		select {
+		case h.flush <- true:
+		default:
+		}
+		return true
+	}
+	return false
+}
+
+// Returns how long the handler is still paused for.
+func (h *HostHandler) pauseRemaining(now time.Time) time.Duration {
+	h.pauseMu.Lock()
+	defer h.pauseMu.Unlock()
+	if !now.Before(h.pausedUntil) {
+		return 0
+	}
+	return h.pausedUntil.Sub(now)
+}
+
+func (h *HostHandler) sendLogs(wrCh chan HostMessage, errCh chan error, stop chan bool) {
 	for {
+		// While paused, logs are only sent once the pause is over.
+		wait := h.WaitDuration
+		if d := h.pauseRemaining(time.Now()); d > 0 {
+			wait = d
+		}
 		select {
 		case <-h.die:
 			return
-		case <-time.After(h.WaitDuration):
-			h.currentlySendingMu.RLock()
-			sending := h.currentlySending
-			h.currentlySendingMu.RUnlock()
-
-			err := h.db.View(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(BucketName)
-				if bucket == nil {
+		case <-stop:
+			return
+		case <-h.flush:
+		case <-time.After(wait):
+		}
+		if h.pauseRemaining(time.Now()) > 0 {
+			continue
+		}
+
+		// Logs sent before the deadline which weren't acknowledged are
+		// sent again.
+		now := time.Now()
+		deadline := now.Add(-h.ackTimeout())
+		sending := make(map[string]bool)
+		var overdue []string
+		h.currentlySendingMu.RLock()
+		for id, sent := range h.currentlySending {
+			if sent.After(deadline) {
+				sending[id] = true
+			} else {
+				overdue = append(overdue, id)
+			}
+		}
+		h.currentlySendingMu.RUnlock()
+		if len(overdue) > 0 {
+			h.stats.ackTimedOut(len(overdue))
+			h.reportError(errCh, fmt.Errorf("%d logs were not acknowledged within %s and will be sent again", len(overdue), h.ackTimeout()))
+		}
+
+		err := h.db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(BucketName)
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(k, v []byte) error {
+				var l storedHostLog
+				if err := json.Unmarshal(v, &l); err != nil {
+					return err
+				}
+				if sending[l.Id] {
 					return nil
 				}
-				return bucket.ForEach(func(k, v []byte) error {
-					var l storedHostLog
-					if err := json.Unmarshal(v, &l); err != nil {
-						return err
-					}
-					if stringutil.StringInList(sending, l.Id) {
-						return nil
-					}
-
-					h.currentlySendingMu.Lock()
-					h.currentlySending = append(h.currentlySending, l.Id)
-					h.currentlySendingMu.Unlock()
-
-					wrCh <- HostMessage{
-						Id:      l.Id,
-						Type:    l.Type,
-						Time:    l.Time,
-						Message: l.Message,
-						Context: l.Context,
-					}
+				if h.pauseRemaining(time.Now()) > 0 {
+					return errPaused
+				}
+				h.markSending(l.Id, now)
+
+				msg := HostMessage{
+					Id:      l.Id,
+					Type:    l.Type,
+					Time:    l.Time,
+					Message: l.Message,
+					Context: l.Context,
+				}
+				select {
+				case wrCh <- msg:
 					return nil
-				})
+				case <-h.die:
+				case <-stop:
+				}
+				h.doneSending(l.Id)
+				return errStopped
 			})
-			if err != nil {
-				errCh <- err
-			}
+		})
+		if err == errStopped {
+			return
+		}
+		if err == errPaused {
+			continue
+		}
+		if err != nil {
+			h.reportError(errCh, err)
 		}
 	}
 }
@@ -337,6 +612,14 @@ func (h *HostHandler) SendLogs(wrCh chan HostMessage, errCh chan error) {
 //
 // Then, it will register itself with the host.
 func (h *HostHandler) Startup() error {
+	if err := h.prepare(); err != nil {
+		return err
+	}
+	return h.Register()
+}
+
+// Loads or creates the certificate and opens the cache.
+func (h *HostHandler) prepare() error {
 	h.initStopChannels()
 
 	if h.CertFile == "" || h.KeyFile == "" {
@@ -370,54 +653,85 @@ func (h *HostHandler) Startup() error {
 	}
 
 	// Before registration, start the sql lite database.
-	if err := h.StartDb(); err != nil {
-		return err
-	}
-
-	return h.Register()
+	return h.StartDb()
 }
 
 // This function continually reads responses from the server and decodes it. If there
 // are any errors, it will send the errors back through the error channel.
 // Otherwise, it will process the messages appropriately.
 func (h *HostHandler) ReadResponses(conn *tls.Conn, errCh chan error) {
+	h.readResponses(conn, errCh, h.die)
+}
+
+// Returns when the connection is closed. Errors caused by closing it
+// after stop is closed are not reported.
+func (h *HostHandler) readResponses(conn *tls.Conn, errCh chan error, stop chan bool) {
 	dec := json.NewDecoder(conn)
 	for {
 		select {
 		case <-h.die:
 			return
+		case <-stop:
+			return
 		case <-time.After(time.Millisecond):
 		}
 
 		var m ClientMessage
 		if err := dec.Decode(&m); err != nil {
 			if err == io.EOF {
-				// TODO: restart connection!
 				return
 			}
-			errCh <- err
+			select {
+			case <-stop:
+			case <-h.die:
+			default:
+				h.reportError(errCh, err)
+			}
+			return
 		}
 
-		// Remove from "sending"
-		h.currentlySendingMu.Lock()
-		for idx, id := range h.currentlySending {
-			if id == m.Id {
-				h.currentlySending[idx] = h.currentlySending[0]
-				h.currentlySending = h.currentlySending[1:]
-				break
-			}
+		if h.handleFlowControl(m) {
+			continue
 		}
-		h.currentlySendingMu.Unlock()
+
+		h.stats.logAcked(m.Id, m.Status, time.Now())
 
 		if m.Status == ClientMessageStatusFailed {
-			errCh <- errors.New(m.Message)
+			h.doneSending(m.Id)
+			h.reportError(errCh, errors.New(m.Message))
 			continue
 		}
 
+		// Removed before it is no longer being sent, so that it isn't sent
+		// again in between.
 		if err := h.Remove(m.Id); err != nil {
-			errCh <- err
+			h.reportError(errCh, err)
 		}
+		h.doneSending(m.Id)
+	}
+}
+
+func (h *HostHandler) markSending(id string, now time.Time) {
+	h.currentlySendingMu.Lock()
+	if h.currentlySending == nil {
+		h.currentlySending = make(map[string]time.Time)
+	}
+	h.currentlySending[id] = now
+	h.currentlySendingMu.Unlock()
+}
+
+// Removes the log from the ones being sent.
+func (h *HostHandler) doneSending(id string) {
+	h.currentlySendingMu.Lock()
+	delete(h.currentlySending, id)
+	h.currentlySendingMu.Unlock()
+}
+
+func (h *HostHandler) ackTimeout() time.Duration {
+	if h.AckTimeout <= 0 {
+		return DefaultAckTimeout
 	}
+	return h.AckTimeout
 }
 
 func (h *HostHandler) Remove(id string) error {
@@ -467,12 +781,20 @@ func (h *HostHandler) Register() error {
 		return err
 	}
 	defer conn.Close()
+	done := make(chan bool)
+	defer close(done)
+	go h.closeOnStop(conn, done)
+	if err := conn.SetDeadline(time.Now().Add(h.dialTimeout())); err != nil {
+		return err
+	}
 
 	msg := HostMessage{
 		Machine: h.Machine,
 		Service: h.Service,
 		Type:    MsgTypeRegister,
 		Message: []byte(h.Password),
+
+		HeartbeatInterval: h.HeartBeatDuration,
 	}
 
 	if err := h.sendToHost(conn, msg); err != nil {
@@ -482,10 +804,18 @@ func (h *HostHandler) Register() error {
 	// Read response and handle any errors.
 	dec := json.NewDecoder(conn)
 	var m ClientMessage
-	if err := dec.Decode(&m); err != nil {
-		return err
+	for {
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+		if !h.handleFlowControl(m) {
+			break
+		}
 	}
 
+	if m.Type == MsgTypeLimit {
+		return errors.New("Connection rejected: " + m.Message)
+	}
 	if m.Type != MsgTypeRegister {
 		return errors.New(fmt.Sprintf("Registration error: Invalid response type from server. Expect %s got %s", MsgTypeRegister, m.Type))
 	}
@@ -498,22 +828,35 @@ func (h *HostHandler) Register() error {
 // A heartbeat is a signal that is sent to the host to tell the host
 // that the client process is still alive.
 func (h *HostHandler) RunHeartbeat(wrCh chan HostMessage) {
+	h.runHeartbeat(wrCh, h.die)
+}
+
+func (h *HostHandler) runHeartbeat(wrCh chan HostMessage, stop chan bool) {
 	for {
 		select {
 		case <-h.die:
 			return
+		case <-stop:
+			return
 		case <-time.After(h.HeartBeatDuration):
-			wrCh <- HostMessage{
-				Machine: h.Machine,
-				Service: h.Service,
-				Type:    MsgTypeHeartbeat,
-			}
+		}
+		select {
+		case <-h.die:
+			return
+		case <-stop:
+			return
+		case wrCh <- HostMessage{
+			Machine: h.Machine,
+			Service: h.Service,
+			Type:    MsgTypeHeartbeat,
+		}:
 		}
 	}
 }
 
 func (h *HostHandler) connect() (*tls.Conn, error) {
-	conn, err := tls.Dial("tcp", h.Endpoint, &tls.Config{
+	dialer := &net.Dialer{Timeout: h.dialTimeout()}
+	conn, err := tls.DialWithDialer(dialer, "tcp", h.Endpoint, &tls.Config{
 		Certificates:       []tls.Certificate{h.pair},
 		InsecureSkipVerify: true,
 	})
@@ -523,6 +866,23 @@ func (h *HostHandler) connect() (*tls.Conn, error) {
 	return conn, nil
 }
 
+func (h *HostHandler) dialTimeout() time.Duration {
+	if h.DialTimeout <= 0 {
+		return DefaultDialTimeout
+	}
+	return h.DialTimeout
+}
+
+// Closes the connection when the handler stops, so that reading or writing
+// it doesn't keep stopping waiting. Returns once stop is closed.
+func (h *HostHandler) closeOnStop(conn net.Conn, stop chan bool) {
+	select {
+	case <-h.die:
+		conn.Close()
+	case <-stop:
+	}
+}
+
 func (h *HostHandler) sendToHost(conn *tls.Conn, msg HostMessage) error {
 	byt, err := json.Marshal(msg)
 	if err != nil {
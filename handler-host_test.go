@@ -0,0 +1,207 @@
+package logx
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHostHandlerReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-reconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := newFakeHost(t, dir)
+	defer host.Close()
+	host.DropConnections(2, 2)
+
+	h := newTestHostHandler(t, dir, host.Addr())
+	h.ReconnectMinDelay = 10 * time.Millisecond
+	h.ReconnectMaxDelay = 50 * time.Millisecond
+	messages := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		if err := h.Store(&BaseHostLog{Type: "Test", Time: time.Now(), Message: []byte(msg)}); err != nil {
+			t.Fatal(err)
+		}
+		messages[msg] = true
+	}
+
+	errCh := make(chan error)
+	go func() {
+		// Errors are expected when the connections are dropped.
+		for range errCh {
+		}
+	}()
+	go h.RunForever(errCh)
+	defer h.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Flush(ctx); err != nil {
+		t.Fatalf("Could not flush: %s", err)
+	}
+
+	for _, m := range host.Received() {
+		delete(messages, string(m.Message))
+	}
+	if len(messages) > 0 {
+		t.Errorf("Expected every log to be received, %d were not", len(messages))
+	}
+	stats, err := h.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reconnects < 2 {
+		t.Errorf("Expected at least 2 reconnects, got %d", stats.Reconnects)
+	}
+}
+
+func TestHostHandlerAckTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-ack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := newFakeHost(t, dir)
+	defer host.Close()
+	host.IgnoreLogs(2)
+
+	h := newTestHostHandler(t, dir, host.Addr())
+	h.AckTimeout = 100 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		if err := h.Store(&BaseHostLog{Type: "Test", Time: time.Now(), Message: []byte(fmt.Sprintf("message %d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errCh := make(chan error)
+	go func() {
+		// The timeouts are reported.
+		for range errCh {
+		}
+	}()
+	go h.Run(errCh)
+	defer h.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Flush(ctx); err != nil {
+		t.Fatalf("Could not flush: %s", err)
+	}
+
+	// The register message, the logs and the two which were sent again.
+	received := host.Received()
+	if len(received) != 6 {
+		t.Errorf("Expected the host to receive 6 messages, got %d", len(received))
+	}
+	counts := make(map[string]int)
+	for _, m := range received[1:] {
+		counts[m.Id]++
+	}
+	if len(counts) != 3 {
+		t.Errorf("Expected 3 distinct logs, got %d", len(counts))
+	}
+	stats, err := h.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.AckTimeouts != 2 || stats.Duplicates != 2 {
+		t.Errorf("Expected 2 ack timeouts and duplicates, got %d and %d", stats.AckTimeouts, stats.Duplicates)
+	}
+}
+
+func TestHostHandlerPause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := newFakeHost(t, dir)
+	defer host.Close()
+	host.PauseConnections(300 * time.Millisecond)
+
+	h := newTestHostHandler(t, dir, host.Addr())
+	for i := 0; i < 3; i++ {
+		if err := h.Store(&BaseHostLog{Type: "Test", Time: time.Now(), Message: []byte("message")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errCh := make(chan error)
+	go func() {
+		for err := range errCh {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	}()
+	go h.Run(errCh)
+	defer h.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := h.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Paused {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the handler to be paused")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	paused := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Flush(ctx); err != nil {
+		t.Fatalf("Could not flush: %s", err)
+	}
+	if d := time.Since(paused); d < 200*time.Millisecond {
+		t.Errorf("Expected the logs to be sent after the pause, took %s", d)
+	}
+	if n := len(host.Received()); n != 4 {
+		t.Errorf("Expected the host to receive 4 messages, got %d", n)
+	}
+	stats, err := h.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Paused {
+		t.Error("Expected the handler to have resumed")
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	h := &HostHandler{
+		ReconnectMinDelay: 100 * time.Millisecond,
+		ReconnectMaxDelay: time.Second,
+	}
+	tests := []struct {
+		Attempt int
+		Max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{100, time.Second},
+	}
+	for i, test := range tests {
+		for j := 0; j < 20; j++ {
+			d := h.reconnectDelay(test.Attempt)
+			if d < test.Max/2 || d > test.Max {
+				t.Errorf("[%d] Expected a delay between %s and %s, got %s", i, test.Max/2, test.Max, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package logxhost
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// AlertSinkType is where the alerts of a rule are sent. The target of the
+// rule depends on the type:
+//
+//	webhook  URL which receives the alert as JSON in a POST request
+//	smtp     email addresses, separated by commas
+//	command  command run by the shell with the alert as JSON on its
+//	         standard input. Only run if enabled on the server
+type AlertSinkType string
+
+const (
+	AlertSinkWebhook AlertSinkType = "webhook"
+	AlertSinkSMTP    AlertSinkType = "smtp"
+	AlertSinkCommand AlertSinkType = "command"
+)
+
+// Time allowed for a sink to deliver an alert.
+var AlertSinkTimeout = 10 * time.Second
+
+var (
+	ErrInvalidAlertSink     = errors.New("alert sink must be webhook, smtp or command")
+	ErrAlertSMTPDisabled    = errors.New("smtp alerts require the smtp server to be configured")
+	ErrAlertCommandDisabled = errors.New("command alerts are not enabled on this server")
+)
+
+// Validate checks the target of the sink.
+func (t AlertSinkType) Validate(target string) error {
+	switch t {
+	case AlertSinkWebhook:
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.New("webhook target must be an http or https URL")
+		}
+	case AlertSinkSMTP:
+		if _, err := mail.ParseAddressList(target); err != nil {
+			return err
+		}
+	case AlertSinkCommand:
+		if strings.TrimSpace(target) == "" {
+			return errors.New("command target must not be empty")
+		}
+	default:
+		return ErrInvalidAlertSink
+	}
+	return nil
+}
+
+// AlertSink delivers alerts.
+type AlertSink interface {
+	Notify(a *Alert) error
+}
+
+// AlertSink returns the sink of the rule, configured for the server.
+func (s *Server) AlertSink(r *AlertRule) (AlertSink, error) {
+	return s.newAlertSink(r.SinkType, r.SinkTarget)
+}
+
+func (s *Server) newAlertSink(t AlertSinkType, target string) (AlertSink, error) {
+	if err := t.Validate(target); err != nil {
+		return nil, err
+	}
+	switch t {
+	case AlertSinkWebhook:
+		return &WebhookSink{URL: target}, nil
+	case AlertSinkSMTP:
+		if s.SMTPAddr == "" || s.SMTPFrom == "" {
+			return nil, ErrAlertSMTPDisabled
+		}
+		addrs, _ := mail.ParseAddressList(target)
+		sink := &SMTPSink{Addr: s.SMTPAddr, Auth: s.SMTPAuth, From: s.SMTPFrom}
+		for _, a := range addrs {
+			sink.To = append(sink.To, a.Address)
+		}
+		return sink, nil
+	case AlertSinkCommand:
+		if !s.AlertCommandsEnabled {
+			return nil, ErrAlertCommandDisabled
+		}
+		return &CommandSink{Command: target}, nil
+	}
+	return nil, ErrInvalidAlertSink
+}
+
+// WebhookSink posts alerts as JSON.
+type WebhookSink struct {
+	URL string
+
+	// Defaults to a client with AlertSinkTimeout.
+	Client *http.Client
+}
+
+func (w *WebhookSink) Notify(a *Alert) error {
+	byt, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: AlertSinkTimeout}
+	}
+	res, err := client.Post(w.URL, "application/json", bytes.NewReader(byt))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
+
+// SMTPSink emails alerts.
+type SMTPSink struct {
+	// Address of the SMTP server, e.g. smtp.example.com:587
+	Addr string
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+func (m *SMTPSink) Notify(a *Alert) error {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", m.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&body, "Subject: [logx] %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(a.Summary()))
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	if a.Liveness != nil {
+		fmt.Fprintf(&body, "%s\r\n\r\nTime: %s\r\n", a.Summary(), a.Time.Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(&body, "%s\r\n\r\nFilter: %s\r\nThreshold: %d\r\nTime: %s\r\n", a.Summary(), a.Filter, a.Threshold, a.Time.Format(time.RFC3339))
+	}
+	if len(a.Samples) > 0 {
+		fmt.Fprintf(&body, "\r\nLatest logs:\r\n")
+		for _, l := range a.Samples {
+			fmt.Fprintf(&body, "%s %s@%s %s\r\n", l.LogTime.Format(time.RFC3339), l.Service, l.Machine, l.Message)
+		}
+	}
+	return smtp.SendMail(m.Addr, m.Auth, m.From, m.To, body.Bytes())
+}
+
+// CommandSink runs a shell command for each alert. The alert is written as
+// JSON to its standard input, and the LOGX_ALERT_RULE, LOGX_ALERT_SERVICE,
+// LOGX_ALERT_MACHINE, LOGX_ALERT_COUNT, LOGX_ALERT_SUMMARY and, for liveness
+// alerts, LOGX_ALERT_STATE environment variables are set.
+type CommandSink struct {
+	Command string
+}
+
+func (c *CommandSink) Notify(a *Alert) error {
+	byt, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", c.Command)
+	cmd.Stdin = bytes.NewReader(byt)
+	cmd.Env = append(os.Environ(),
+		"LOGX_ALERT_RULE="+a.Rule,
+		"LOGX_ALERT_SERVICE="+a.Service,
+		"LOGX_ALERT_MACHINE="+a.Machine,
+		fmt.Sprintf("LOGX_ALERT_COUNT=%d", a.Count),
+		"LOGX_ALERT_SUMMARY="+a.Summary(),
+	)
+	if a.Liveness != nil {
+		cmd.Env = append(cmd.Env, "LOGX_ALERT_STATE="+string(a.Liveness.State))
+	}
+
+	done := make(chan error, 1)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(out.String()))
+		}
+		return nil
+	case <-time.After(AlertSinkTimeout):
+		cmd.Process.Kill()
+		<-done
+		return errors.New("command timed out")
+	}
+}
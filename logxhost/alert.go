@@ -0,0 +1,364 @@
+package logxhost
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+const (
+	DefaultAlertInterval = time.Minute
+
+	// Number of matching logs sent along with an alert.
+	AlertSampleSize = 5
+)
+
+// Columns alert rules may group by.
+var AlertGroupColumns = []string{"service", "machine"}
+
+var (
+	ErrInvalidAlertGroup = errors.New("alert rules can only be grouped by service and machine")
+	ErrInvalidAlertRule  = errors.New("alert rules require a positive threshold and window")
+)
+
+// AlertRule fires when at least Threshold logs matching Filter (see
+// ParseFilter) were logged within the window. With GroupBy, the logs are
+// counted, and the rule fires, for each service and/or machine separately.
+//
+// Once fired, a rule doesn't fire again for the same group until the
+// cooldown has passed.
+type AlertRule struct {
+	Id              string    `setmap:"ignore"`
+	Created         time.Time `setmap:"ignore"`
+	Name            string
+	Filter          string
+	Threshold       int
+	WindowSeconds   int            `db:"window_seconds"`
+	GroupBy         pq.StringArray `db:"group_by"`
+	CooldownSeconds int            `db:"cooldown_seconds"`
+	SinkType        AlertSinkType  `db:"sink_type"`
+	SinkTarget      string         `db:"sink_target"`
+	Enabled         bool
+}
+
+var (
+	ColsAlertRule = dbutil.GetColumnsList(&AlertRule{}, "")
+)
+
+func (r *AlertRule) Window() time.Duration {
+	return time.Duration(r.WindowSeconds) * time.Second
+}
+
+func (r *AlertRule) Cooldown() time.Duration {
+	return time.Duration(r.CooldownSeconds) * time.Second
+}
+
+// Validate checks the filter, grouping and sink of the rule.
+func (r *AlertRule) Validate() error {
+	if r.Threshold <= 0 || r.WindowSeconds <= 0 || r.CooldownSeconds < 0 {
+		return ErrInvalidAlertRule
+	}
+	if _, err := ParseFilter(r.Filter); err != nil {
+		return err
+	}
+	for _, g := range r.GroupBy {
+		if !isAlertGroupColumn(g) {
+			return ErrInvalidAlertGroup
+		}
+	}
+	return r.SinkType.Validate(r.SinkTarget)
+}
+
+func isAlertGroupColumn(c string) bool {
+	for _, g := range AlertGroupColumns {
+		if g == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *AlertRule) Insert(tx *sqlx.Tx) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	return psql.Insert(TableAlertRule).
+		SetMap(dbutil.SetMap(r, true)).
+		Suffix("RETURNING id, created").
+		RunWith(tx).
+		QueryRow().
+		Scan(&r.Id, &r.Created)
+}
+
+func SelectAlertRules(db sqlx.Queryer, where interface{}) ([]*AlertRule, error) {
+	var xs []*AlertRule
+	q := psql.Select(ColsAlertRule...).From(TableAlertRule).OrderBy("name")
+	if where != nil {
+		q = q.Where(where)
+	}
+	if err := dbutil.Select(db, &xs, q); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+func SetAlertRuleEnabled(db sqlx.Execer, name string, enabled bool) error {
+	_, err := db.Exec(`UPDATE `+TableAlertRule+` SET enabled=$2 WHERE name=$1`, name, enabled)
+	return err
+}
+
+func DeleteAlertRule(db sqlx.Execer, name string) error {
+	_, err := db.Exec(`DELETE FROM `+TableAlertRule+` WHERE name=$1`, name)
+	return err
+}
+
+// Alert is sent to the sink of a rule when it fires.
+type Alert struct {
+	RuleId        string
+	Rule          string
+	Filter        string
+	Threshold     int
+	WindowSeconds int
+
+	// Group of the alert, if the rule is grouped.
+	Service string `json:",omitempty"`
+	Machine string `json:",omitempty"`
+
+	Count int64
+	Time  time.Time
+
+	// The latest matching logs.
+	Samples []*Log
+
+	// Set instead of the rule when an instance died or came back.
+	Liveness *LivenessChange `json:",omitempty"`
+}
+
+// Summary describes the alert in a single line.
+func (a *Alert) Summary() string {
+	if l := a.Liveness; l != nil {
+		return fmt.Sprintf("%s: %s on %s is %s, last seen %s", a.Rule, a.Service, a.Machine, l.State, l.LastSeen.Format(time.RFC3339))
+	}
+	s := fmt.Sprintf("%s: %d logs in %s", a.Rule, a.Count, time.Duration(a.WindowSeconds)*time.Second)
+	if g := a.groupKey(); g != "" {
+		s += " (" + g + ")"
+	}
+	return s
+}
+
+// Identifies the group for the cooldown.
+func (a *Alert) groupKey() string {
+	var parts []string
+	if a.Service != "" {
+		parts = append(parts, "service="+a.Service)
+	}
+	if a.Machine != "" {
+		parts = append(parts, "machine="+a.Machine)
+	}
+	return strings.Join(parts, ",")
+}
+
+type alertGroup struct {
+	Service *string
+	Machine *string
+	Count   int64
+}
+
+// The groups of logs which reach the threshold at the provided time.
+func (r *AlertRule) countQuery(now time.Time) (squirrel.SelectBuilder, error) {
+	var sel squirrel.SelectBuilder
+	filter, err := ParseFilter(r.Filter)
+	if err != nil {
+		return sel, err
+	}
+
+	grouped := make(map[string]bool)
+	for _, g := range r.GroupBy {
+		if !isAlertGroupColumn(g) {
+			return sel, ErrInvalidAlertGroup
+		}
+		grouped[g] = true
+	}
+
+	// Every group column is selected so the groups can be scanned the same
+	// way; the ones not grouped by are null.
+	var groups []string
+	sel = psql.Select()
+	for _, c := range AlertGroupColumns {
+		if grouped[c] {
+			groups = append(groups, c)
+			sel = sel.Column(c)
+		} else {
+			sel = sel.Column("NULL::TEXT AS " + c)
+		}
+	}
+
+	sel = sel.Column("COUNT(*) AS count").
+		From(ViewLog).
+		Where(squirrel.GtOrEq{"log_time": now.Add(-r.Window())}).
+		Where(squirrel.LtOrEq{"log_time": now})
+	if filter != nil {
+		sel = sel.Where(filter)
+	}
+	if len(groups) > 0 {
+		sel = sel.GroupBy(groups...)
+	}
+	return sel.Having("COUNT(*) >= ?", r.Threshold), nil
+}
+
+// EvaluateAlertRule returns the alerts of the rule at the provided time,
+// including those of groups which are in their cooldown.
+func EvaluateAlertRule(db sqlx.Queryer, r *AlertRule, now time.Time) ([]*Alert, error) {
+	sel, err := r.countQuery(now)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*alertGroup
+	if err := dbutil.Select(db, &groups, sel); err != nil {
+		return nil, err
+	}
+
+	var alerts []*Alert
+	for _, g := range groups {
+		a := &Alert{
+			RuleId:        r.Id,
+			Rule:          r.Name,
+			Filter:        r.Filter,
+			Threshold:     r.Threshold,
+			WindowSeconds: r.WindowSeconds,
+			Count:         g.Count,
+			Time:          now,
+		}
+		if g.Service != nil {
+			a.Service = *g.Service
+		}
+		if g.Machine != nil {
+			a.Machine = *g.Machine
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, nil
+}
+
+// Escapes the wildcards of LIKE patterns.
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(v)
+}
+
+// Samples are the latest logs of the group matching the rule.
+func alertSamples(db sqlx.Queryer, r *AlertRule, a *Alert) ([]*Log, error) {
+	filter, err := ParseFilter(r.Filter)
+	if err != nil {
+		return nil, err
+	}
+	q := &LogQuery{
+		After:  a.Time.Add(-r.Window()),
+		Filter: filter,
+		Limit:  AlertSampleSize,
+	}
+	if a.Service != "" {
+		q.Services = []string{escapeLike(a.Service)}
+	}
+	if a.Machine != "" {
+		q.Machines = []string{escapeLike(a.Machine)}
+	}
+	return SearchLogs(db, q)
+}
+
+// Records the alert unless the rule already fired for the group within
+// its cooldown. It returns false if the alert is in its cooldown. Alerts
+// which could not be sent don't count towards the cooldown.
+func recordAlert(db sqlx.Queryer, r *AlertRule, a *Alert) (string, bool, error) {
+	var id string
+	err := sqlx.Get(db, &id, `
+INSERT INTO `+TableAlertEvent+`(rule_id, group_key, count)
+SELECT $1, $2, $3
+WHERE NOT EXISTS(
+    SELECT 1
+    FROM `+TableAlertEvent+`
+    WHERE rule_id = $1
+      AND group_key = $2
+      AND created > NOW() - make_interval(secs => $4)
+      AND error = ''
+    )
+RETURNING id`, r.Id, a.groupKey(), a.Count, r.CooldownSeconds)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return id, true, nil
+}
+
+// EvaluateAlerts evaluates every enabled rule right away and then every
+// AlertInterval until the die channel is closed. Alerts which are not in
+// their cooldown are sent to the sink of their rule.
+func (s *Server) EvaluateAlerts(die chan bool, eh func(error)) {
+	interval := s.AlertInterval
+	if interval == 0 {
+		interval = DefaultAlertInterval
+	}
+	for {
+		s.evaluateAlerts(time.Now(), eh)
+		select {
+		case <-die:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (s *Server) evaluateAlerts(now time.Time, eh func(error)) {
+	rules, err := SelectAlertRules(s.DB, squirrel.Eq{"enabled": true})
+	if err != nil {
+		eh(err)
+		return
+	}
+	for _, r := range rules {
+		for _, err := range s.evaluateAlertRule(r, now) {
+			eh(fmt.Errorf("alert rule %s: %s", r.Name, err))
+		}
+	}
+}
+
+// Sends the alerts of the rule. An alert which fails doesn't stop the
+// others from being sent, and the errors are returned together.
+func (s *Server) evaluateAlertRule(r *AlertRule, now time.Time) []error {
+	sink, err := s.AlertSink(r)
+	if err != nil {
+		return []error{err}
+	}
+	alerts, err := EvaluateAlertRule(s.DB, r, now)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, a := range alerts {
+		id, ok, err := recordAlert(s.DB, r, a)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if a.Samples, err = alertSamples(s.DB, r, a); err == nil {
+			err = sink.Notify(a)
+		}
+		if err != nil {
+			// Marked as failed so that the alert is sent again on the next
+			// evaluation rather than after the cooldown.
+			s.DB.Exec(`UPDATE `+TableAlertEvent+` SET error=$2 WHERE id=$1`, id, err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,241 @@
+package logxhost
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestAlertRuleValidate(t *testing.T) {
+	valid := AlertRule{
+		Filter:        "severity>=ERROR",
+		Threshold:     1,
+		WindowSeconds: 60,
+		GroupBy:       []string{"service"},
+		SinkType:      AlertSinkWebhook,
+		SinkTarget:    "http://localhost/hook",
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Expected rule to be valid: %s", err)
+	}
+
+	tests := []func(r *AlertRule){
+		func(r *AlertRule) { r.Threshold = 0 },
+		func(r *AlertRule) { r.WindowSeconds = 0 },
+		func(r *AlertRule) { r.Filter = "severity>" },
+		func(r *AlertRule) { r.GroupBy = []string{"message"} },
+		func(r *AlertRule) { r.SinkType = "pager" },
+		func(r *AlertRule) { r.SinkTarget = "ftp://localhost" },
+		func(r *AlertRule) { r.SinkType, r.SinkTarget = AlertSinkSMTP, "not an email" },
+		func(r *AlertRule) { r.SinkType, r.SinkTarget = AlertSinkCommand, " " },
+	}
+	for idx, modify := range tests {
+		r := valid
+		modify(&r)
+		if err := r.Validate(); err == nil {
+			t.Errorf("[%d] Expected rule to be invalid", idx)
+		}
+	}
+}
+
+func TestAlertCountQuery(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := &AlertRule{
+		Filter:        "severity:FATAL",
+		Threshold:     3,
+		WindowSeconds: 300,
+		GroupBy:       []string{"machine"},
+	}
+	sel, err := r.countQuery(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args, err := sel.ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SELECT NULL::TEXT AS service, machine, COUNT(*) AS count FROM log_view WHERE log_time >= $1 AND log_time <= $2 AND context_data ->> 'Severity' = $3 GROUP BY machine HAVING COUNT(*) >= $4"
+	if query != expected {
+		t.Errorf("Expected %s, got %s", expected, query)
+	}
+	if !args[0].(time.Time).Equal(now.Add(-5*time.Minute)) || args[3] != 3 {
+		t.Errorf("Expected window and threshold in args. Got %v", args)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := make(chan Alert, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Error(err)
+		}
+		received <- a
+	}))
+	defer ts.Close()
+
+	sink := &WebhookSink{URL: ts.URL}
+	if err := sink.Notify(&Alert{Rule: "fatal", Service: "api", Count: 4}); err != nil {
+		t.Fatalf("Could not notify: %s", err)
+	}
+	a := <-received
+	if a.Rule != "fatal" || a.Service != "api" || a.Count != 4 {
+		t.Errorf("Expected alert to be received, got %v", a)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if err := (&WebhookSink{URL: failing.URL}).Notify(&Alert{}); err == nil {
+		t.Error("Expected an error when the webhook fails")
+	}
+}
+
+func TestCommandSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logx-alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "alert")
+
+	sink := &CommandSink{Command: `cat > "$OUT"; echo "$LOGX_ALERT_RULE" >> "$OUT"`}
+	os.Setenv("OUT", out)
+	defer os.Unsetenv("OUT")
+	if err := sink.Notify(&Alert{Rule: "fatal", Count: 2}); err != nil {
+		t.Fatalf("Could not run command: %s", err)
+	}
+	byt, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(byt), `"Rule":"fatal"`) || !strings.HasSuffix(string(byt), "fatal\n") {
+		t.Errorf("Expected alert on stdin and in the environment, got %s", byt)
+	}
+
+	if err := (&CommandSink{Command: "exit 3"}).Notify(&Alert{}); err == nil {
+		t.Error("Expected an error when the command fails")
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	received := make(chan Alert, 10)
+	var failing int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var a Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		received <- a
+	}))
+	defer ts.Close()
+
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableAlertRule+` WHERE name=$1`, "Test Alert")
+		s.DB.Exec(`DELETE FROM `+TableLog+` WHERE instance_id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "Alert Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, "Alert Machine")
+	}()
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	instance, err := s.RegisterService(logx.HostMessage{
+		Type:    logx.MsgTypeRegister,
+		Machine: "Alert Machine",
+		Service: "Alert Service",
+	}, ConnDetails{Hash: s.marshalHash(cert.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, instance.Id)
+
+	rule := &AlertRule{
+		Name:            "Test Alert",
+		Filter:          `service:"Alert Service" severity:FATAL`,
+		Threshold:       2,
+		WindowSeconds:   300,
+		GroupBy:         []string{"service", "machine"},
+		CooldownSeconds: 3600,
+		SinkType:        AlertSinkWebhook,
+		SinkTarget:      ts.URL,
+		Enabled:         true,
+	}
+	if err := dbutil.TxNow(s.DB, rule.Insert); err != nil {
+		t.Fatalf("Could not insert rule: %s", err)
+	}
+
+	insert := func(severity string) {
+		msg := logx.HostMessage{
+			Type:    "TestLog",
+			Time:    time.Now(),
+			Message: []byte("alert message"),
+			Context: []byte(`{"Severity":"` + severity + `"}`),
+		}
+		if err := InsertHostMessage(s.DB, msg, instance.Id); err != nil {
+			t.Fatalf("Could not insert message: %s", err)
+		}
+	}
+
+	eh := func(err error) {
+		t.Error(err)
+	}
+
+	// Below the threshold.
+	insert("FATAL")
+	insert("INFO")
+	s.evaluateAlerts(time.Now(), eh)
+	if len(received) != 0 {
+		t.Fatalf("Expected no alert below the threshold, got %d", len(received))
+	}
+
+	insert("FATAL")
+	atomic.StoreInt32(&failing, 1)
+	var failed int
+	s.evaluateAlerts(time.Now(), func(err error) { failed++ })
+	if failed != 1 {
+		t.Fatalf("Expected the failed alert to be reported, got %d errors", failed)
+	}
+
+	// Sent again, as the failed alert doesn't start the cooldown.
+	atomic.StoreInt32(&failing, 0)
+	s.evaluateAlerts(time.Now(), eh)
+	if len(received) != 1 {
+		t.Fatalf("Expected an alert, got %d", len(received))
+	}
+	a := <-received
+	if a.Count != 2 || a.Service != "Alert Service" || a.Machine != "Alert Machine" || len(a.Samples) != 2 {
+		t.Errorf("Expected alert for the group with samples, got %v", a)
+	}
+
+	// The rule is in its cooldown.
+	insert("FATAL")
+	s.evaluateAlerts(time.Now(), eh)
+	if len(received) != 0 {
+		t.Errorf("Expected no alert during the cooldown, got %d", len(received))
+	}
+}
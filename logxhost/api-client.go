@@ -0,0 +1,230 @@
+package logxhost
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// APIClient reads logs through the API of a log server, so that the
+// database does not need to be reachable.
+type APIClient struct {
+	// Base URL of the server, e.g. https://logs.example.com:9091
+	URL string
+
+	// Token of the API user. Not needed if the client certificate of
+	// the HTTP client belongs to an API user.
+	Token string
+
+	HTTP *http.Client
+}
+
+// APIClientConfig configures the TLS connection of NewAPIClient.
+type APIClientConfig struct {
+	URL   string
+	Token string
+
+	// Client certificate and key, to authenticate without a token.
+	CertFile string
+	KeyFile  string
+
+	// Certificate of the server, or of the authority which signed it.
+	// Defaults to the system authorities.
+	CAFile string
+
+	// Skips verifying the certificate of the server.
+	Insecure bool
+}
+
+func NewAPIClient(conf APIClientConfig) (*APIClient, error) {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: conf.Insecure,
+	}
+	if conf.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{pair}
+	}
+	if conf.CAFile != "" {
+		byt, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(byt) {
+			return nil, errors.New("no certificates found in " + conf.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	return &APIClient{
+		URL:   strings.TrimRight(conf.URL, "/"),
+		Token: conf.Token,
+		HTTP: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConf},
+		},
+	}, nil
+}
+
+func (c *APIClient) get(path string, values url.Values) (*http.Response, error) {
+	u := c.URL + path
+	if len(values) > 0 {
+		u += "?" + values.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		var apiErr APIError
+		if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return nil, errors.New(apiErr.Error)
+	}
+	return res, nil
+}
+
+func (c *APIClient) getJSON(path string, values url.Values, v interface{}) error {
+	res, err := c.get(path, values)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (c *APIClient) SearchLogPage(q *LogQuery) (*LogPage, error) {
+	var page LogPage
+	if err := c.getJSON(APIPathLogs, q.Values(), &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// StreamLogs is like the StreamLogs function, fetching each page from
+// the server.
+func (c *APIClient) StreamLogs(q *LogQuery, fn func([]*Log) error) error {
+	return streamLogPages(q, c.SearchLogPage, fn)
+}
+
+func (c *APIClient) GetLog(id string) (*Log, error) {
+	var l Log
+	if err := c.getJSON(APIPathLogs+"/"+url.PathEscape(id), nil, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (c *APIClient) SelectInstances(services []string) ([]*Instance, error) {
+	values := url.Values{}
+	if len(services) > 0 {
+		values.Set("service", strings.Join(services, ","))
+	}
+	var xs []*Instance
+	if err := c.getJSON(APIPathInstances, values, &xs); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+func (c *APIClient) SelectSessions(services []string, from, to time.Time) ([]*Session, error) {
+	values := url.Values{}
+	if len(services) > 0 {
+		values.Set("service", strings.Join(services, ","))
+	}
+	values.Set("time_after", from.Format(time.RFC3339Nano))
+	values.Set("time_before", to.Format(time.RFC3339Nano))
+	var xs []*Session
+	if err := c.getJSON(APIPathSessions, values, &xs); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+// TailLogs is like the TailLogs function. If the stream is interrupted, the
+// client reconnects and resumes after the last log received, skipping the
+// logs the server sends again.
+func (c *APIClient) TailLogs(q *LogQuery, die chan bool, fn func([]*Log) error, eh func(error)) error {
+	values := q.Values()
+	var delay time.Duration
+	var seen seenLogs
+	for {
+		stop, err := c.tail(values, die, fn, &seen, &delay)
+		if stop {
+			return err
+		}
+		eh(err)
+		delay = getNextDelay(delay)
+		select {
+		case <-die:
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Reads one stream of logs, setting the since parameter to resume after
+// the last log. It returns true if tailing should stop, either because die
+// was closed or fn failed. The delay is reset once logs are received.
+func (c *APIClient) tail(values url.Values, die chan bool, fn func([]*Log) error, seen *seenLogs, delay *time.Duration) (bool, error) {
+	res, err := c.get(APIPathTail, values)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-die:
+			res.Body.Close()
+		case <-done:
+		}
+	}()
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var l Log
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return false, err
+		}
+		if !seen.add(&l) {
+			continue
+		}
+		if err := fn([]*Log{&l}); err != nil {
+			return true, err
+		}
+		values.Set("since", TailCursor(&l).String())
+		*delay = 0
+	}
+
+	select {
+	case <-die:
+		return true, nil
+	default:
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("tail stream ended")
+}
@@ -0,0 +1,92 @@
+package logxhost
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// APIUser can read logs through the API. Tokens and certificates are
+// identified by their hash; see APITokenHash and CertificateKeyHash.
+type APIUser struct {
+	Id        string    `setmap:"ignore"`
+	Created   time.Time `setmap:"ignore"`
+	Name      string
+	TokenHash *string `db:"token_hash"`
+	CertHash  *string `db:"cert_hash"`
+}
+
+var (
+	ColsAPIUser = dbutil.GetColumnsList(&APIUser{}, "")
+)
+
+// GenerateAPIToken returns a new random token. It is only shown once; the
+// hash is stored.
+func GenerateAPIToken() (string, error) {
+	byt := make([]byte, 32)
+	if _, err := rand.Read(byt); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(byt), nil
+}
+
+func APITokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (u *APIUser) Insert(tx *sqlx.Tx) error {
+	return psql.Insert(TableAPIUser).
+		SetMap(dbutil.SetMap(u, true)).
+		Suffix("RETURNING id, created").
+		RunWith(tx).
+		QueryRow().
+		Scan(&u.Id, &u.Created)
+}
+
+// CertificateKeyHash returns the hash under which the certificate of an API
+// user is stored. It is the hash of the public key, which the client proves
+// it owns during the TLS handshake, rather than of the signature, which
+// anyone who has seen the certificate could copy into their own.
+func CertificateKeyHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+func GetAPIUser(db sqlx.Queryer, where interface{}) (*APIUser, error) {
+	var u APIUser
+	q := psql.Select(ColsAPIUser...).From(TableAPIUser).Where(where)
+	if err := dbutil.Get(db, &u, q); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func GetAPIUserByToken(db sqlx.Queryer, token string) (*APIUser, error) {
+	return GetAPIUser(db, squirrel.Eq{"token_hash": APITokenHash(token)})
+}
+
+func GetAPIUserByCert(db sqlx.Queryer, cert *x509.Certificate) (*APIUser, error) {
+	return GetAPIUser(db, squirrel.Eq{"cert_hash": CertificateKeyHash(cert)})
+}
+
+func SelectAPIUsers(db sqlx.Queryer) ([]*APIUser, error) {
+	var xs []*APIUser
+	q := psql.Select(ColsAPIUser...).From(TableAPIUser).OrderBy("name")
+	if err := dbutil.Select(db, &xs, q); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+func DeleteAPIUser(db sqlx.Execer, name string) error {
+	_, err := db.Exec(`DELETE FROM `+TableAPIUser+` WHERE name=$1`, name)
+	return err
+}
@@ -0,0 +1,366 @@
+package logxhost
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// Paths of the read API. Every request must be authenticated by an API user,
+// either with an "Authorization: Bearer <token>" header or a client
+// certificate. Users only see the services granted by their roles, and
+// every read is recorded in the read audit.
+//
+//	GET /api/logs              search, see LogQuery.Values for parameters
+//	GET /api/logs/<id>         a single log
+//	GET /api/instances         instances, filtered by the service parameter
+//	GET /api/tail              new logs as JSON lines, with the search
+//	                           filters. since resumes after a TailCursor
+//	GET /api/histogram         number of logs over time, with the search
+//	                           filters and an interval (e.g. 5m). Defaults
+//	                           to the last 24 hours
+//	GET /api/sessions          sessions of the instances between time_after
+//	                           and time_before, filtered by the service
+//	                           parameter. Defaults to the last 24 hours
+const (
+	APIPathLogs      = "/api/logs"
+	APIPathInstances = "/api/instances"
+	APIPathTail      = "/api/tail"
+	APIPathHistogram = "/api/histogram"
+	APIPathSessions  = "/api/sessions"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// APIError is the body of unsuccessful API responses.
+type APIError struct {
+	Error string
+}
+
+type apiAccessKey struct{}
+
+type apiAccess struct {
+	User  *APIUser
+	Scope *AccessScope
+}
+
+func requestAccess(r *http.Request) *apiAccess {
+	a, _ := r.Context().Value(apiAccessKey{}).(*apiAccess)
+	if a == nil {
+		return &apiAccess{User: &APIUser{}, Scope: &AccessScope{}}
+	}
+	return a
+}
+
+// RequestAPIUser returns the user who made the API request.
+func RequestAPIUser(r *http.Request) *APIUser {
+	return requestAccess(r).User
+}
+
+// RequestAccessScope returns the services the user of the API request
+// may read.
+func RequestAccessScope(r *http.Request) *AccessScope {
+	return requestAccess(r).Scope
+}
+
+// ListenAPI listens for API requests over TLS using the certificate of the
+// server. Client certificates are requested but optional.
+func (s *Server) ListenAPI(port int) (net.Listener, error) {
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		ClientAuth:   tls.RequestClientCert,
+		Certificates: []tls.Certificate{cert},
+		Rand:         rand.Reader,
+	}
+	return tls.Listen("tcp", ":"+strconv.Itoa(port), tlsConf)
+}
+
+// ServeAPI serves the API until the listener is closed.
+func (s *Server) ServeAPI(l net.Listener) error {
+	return http.Serve(l, s.APIHandler())
+}
+
+// APIHandler returns the handler of the read API.
+func (s *Server) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPathLogs, s.handleAPISearch)
+	mux.HandleFunc(APIPathLogs+"/", s.handleAPILog)
+	mux.HandleFunc(APIPathInstances, s.handleAPIInstances)
+	mux.HandleFunc(APIPathTail, s.handleAPITail)
+	mux.HandleFunc(APIPathHistogram, s.handleAPIHistogram)
+	mux.HandleFunc(APIPathSessions, s.handleAPISessions)
+	return s.authenticateAPI(mux)
+}
+
+// Finds the API user of the request by token or client certificate.
+func (s *Server) apiUser(r *http.Request) (*APIUser, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth || token == "" {
+			return nil, ErrUnauthorized
+		}
+		return GetAPIUserByToken(s.DB, token)
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return GetAPIUserByCert(s.DB, r.TLS.PeerCertificates[0])
+	}
+	return nil, ErrUnauthorized
+}
+
+func (s *Server) authenticateAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		u, err := s.apiUser(r)
+		if err == sql.ErrNoRows || err == ErrUnauthorized {
+			writeAPIError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		if err != nil {
+			s.writeAPIServerError(w, err)
+			return
+		}
+		scope, err := GetAccessScope(s.DB, u.Id)
+		if err != nil {
+			s.writeAPIServerError(w, err)
+			return
+		}
+		access := &apiAccess{User: u, Scope: scope}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiAccessKey{}, access)))
+	})
+}
+
+// Records the read before it is made. Reads which can't be audited are
+// refused.
+func (s *Server) auditAPIRead(w http.ResponseWriter, r *http.Request, action, query string) bool {
+	u := RequestAPIUser(r)
+	a := &ReadAudit{
+		UserName: u.Name,
+		Action:   action,
+		Query:    query,
+	}
+	if u.Id != "" {
+		a.UserId = &u.Id
+	}
+	if err := a.Insert(s.DB); err != nil {
+		s.writeAPIServerError(w, err)
+		return false
+	}
+	return true
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, APIError{Error: err.Error()})
+}
+
+// Reports the error of a request which failed on the server, and tells the
+// client without the details, which could expose the database.
+func (s *Server) writeAPIServerError(w http.ResponseWriter, err error) {
+	s.handleAPIError(err)
+	writeAPIError(w, http.StatusInternalServerError, errors.New("internal server error"))
+}
+
+// Reports an error of the API, to the standard logger if the server has no
+// APIErrorHandler.
+func (s *Server) handleAPIError(err error) {
+	if s.APIErrorHandler != nil {
+		s.APIErrorHandler(err)
+	} else {
+		log.Printf("API: %s", err)
+	}
+}
+
+func (s *Server) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseLogQueryValues(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	q.Scope = RequestAccessScope(r)
+	if !s.auditAPIRead(w, r, ReadActionSearch, r.URL.RawQuery) {
+		return
+	}
+	page, err := SearchLogPage(s.DB, q)
+	if err == ErrCursorWithOrder {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		s.writeAPIServerError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) handleAPILog(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, APIPathLogs+"/")
+	if !s.auditAPIRead(w, r, ReadActionDetails, id) {
+		return
+	}
+	// Ids which aren't UUIDs would make the query fail.
+	if _, err := uuid.ParseHex(id); err != nil {
+		writeAPIError(w, http.StatusNotFound, errors.New("log not found"))
+		return
+	}
+	// Logs outside of the scope are not found, so their ids are not leaked.
+	l, err := GetLogInScope(s.DB, id, RequestAccessScope(r))
+	if err == sql.ErrNoRows {
+		writeAPIError(w, http.StatusNotFound, errors.New("log not found"))
+		return
+	}
+	if err != nil {
+		s.writeAPIServerError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, l)
+}
+
+func (s *Server) handleAPIInstances(w http.ResponseWriter, r *http.Request) {
+	if !s.auditAPIRead(w, r, ReadActionInstances, r.URL.RawQuery) {
+		return
+	}
+	instances, err := SelectInstancesInScope(s.DB, splitList(r.URL.Query().Get("service")), RequestAccessScope(r))
+	if err != nil {
+		s.writeAPIServerError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, instances)
+}
+
+// Streams new logs as JSON lines until the client goes away.
+func (s *Server) handleAPITail(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseLogQueryValues(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	q.Scope = RequestAccessScope(r)
+	var since *Cursor
+	if c := r.URL.Query().Get("since"); c != "" {
+		if since, err = ParseCursor(c); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if !s.auditAPIRead(w, r, ReadActionTail, r.URL.RawQuery) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeAPIServerError(w, errors.New("streaming is not supported"))
+		return
+	}
+
+	die := make(chan bool)
+	go func() {
+		<-r.Context().Done()
+		close(die)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	err = TailLogsSince(s.DB, s.PostgresURL, q, since, die, func(logs []*Log) error {
+		for _, l := range logs {
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		flusher.Flush()
+		return nil
+	}, s.handleAPIError)
+	// Writing fails once the client is gone, which isn't worth reporting.
+	if err != nil && r.Context().Err() == nil {
+		s.handleAPIError(err)
+	}
+}
+
+func (s *Server) handleAPIHistogram(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseLogQueryValues(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	q.Scope = RequestAccessScope(r)
+	if q.Before.IsZero() {
+		q.Before = time.Now()
+	}
+	if q.After.IsZero() {
+		q.After = q.Before.Add(-24 * time.Hour)
+	}
+	var interval time.Duration
+	if v := r.URL.Query().Get("interval"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if !s.auditAPIRead(w, r, ReadActionHistogram, r.URL.RawQuery) {
+		return
+	}
+	buckets, err := LogHistogram(s.DB, q, interval)
+	if err == ErrHistogramRange {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		s.writeAPIServerError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, buckets)
+}
+
+func (s *Server) handleAPISessions(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if v := values.Get("time_before"); v != "" {
+		if to, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		from = to.Add(-24 * time.Hour)
+	}
+	if v := values.Get("time_after"); v != "" {
+		if from, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if !s.auditAPIRead(w, r, ReadActionSessions, r.URL.RawQuery) {
+		return
+	}
+	sessions, err := SelectSessions(s.DB, splitList(values.Get("service")), from, to, RequestAccessScope(r))
+	if err != nil {
+		s.writeAPIServerError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, sessions)
+}
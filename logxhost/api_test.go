@@ -0,0 +1,196 @@
+package logxhost
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestAPI(t *testing.T) {
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableLog+` WHERE instance_id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "API Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, "API Machine")
+		s.DB.Exec(`DELETE FROM `+TableReadAudit+` WHERE user_name=ANY($1)`, pq.StringArray{"API Test User", "API Other User"})
+		s.DB.Exec(`DELETE FROM `+TableAPIUser+` WHERE name=ANY($1)`, pq.StringArray{"API Test User", "API Other User"})
+		s.DB.Exec(`DELETE FROM `+TableRole+` WHERE name=$1`, "API Test Role")
+	}()
+
+	token, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := APITokenHash(token)
+	user := &APIUser{Name: "API Test User", TokenHash: &hash}
+	if err := dbutil.TxNow(s.DB, user.Insert); err != nil {
+		t.Fatalf("Could not insert API user: %s", err)
+	}
+	role := &Role{Name: "API Test Role", Services: []string{"API *"}}
+	if err := dbutil.TxNow(s.DB, role.Insert); err != nil {
+		t.Fatalf("Could not insert role: %s", err)
+	}
+	if err := GrantRole(s.DB, user.Id, role.Id); err != nil {
+		t.Fatalf("Could not grant role: %s", err)
+	}
+
+	// A user without roles may not read anything.
+	otherToken, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHash := APITokenHash(otherToken)
+	other := &APIUser{Name: "API Other User", TokenHash: &otherHash}
+	if err := dbutil.TxNow(s.DB, other.Insert); err != nil {
+		t.Fatalf("Could not insert API user: %s", err)
+	}
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	instance, err := s.RegisterService(logx.HostMessage{
+		Type:    logx.MsgTypeRegister,
+		Machine: "API Machine",
+		Service: "API Service",
+	}, ConnDetails{Hash: s.marshalHash(cert.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, instance.Id)
+
+	for i := 0; i < 3; i++ {
+		msg := logx.HostMessage{Type: "TestLog", Time: time.Now(), Message: []byte("api message"), Context: []byte("{}")}
+		if err := InsertHostMessage(s.DB, msg, instance.Id); err != nil {
+			t.Fatalf("Could not insert message: %s", err)
+		}
+	}
+
+	ts := httptest.NewServer(s.APIHandler())
+	defer ts.Close()
+
+	// Requests without a valid token are refused.
+	for idx, tok := range []string{"", "invalid"} {
+		c := &APIClient{URL: ts.URL, Token: tok, HTTP: http.DefaultClient}
+		if _, err := c.SelectInstances(nil); err == nil || err.Error() != ErrUnauthorized.Error() {
+			t.Errorf("[%d] Expected %s, got %v", idx, ErrUnauthorized, err)
+		}
+	}
+
+	c := &APIClient{URL: ts.URL, Token: token, HTTP: http.DefaultClient}
+	instances, err := c.SelectInstances([]string{"API Service"})
+	if err != nil {
+		t.Fatalf("Could not select instances: %s", err)
+	}
+	if len(instances) != 1 || instances[0].Machine != "API Machine" {
+		t.Errorf("Expected the registered instance, got %v", instances)
+	}
+
+	q := &LogQuery{Services: []string{"API Service"}, Limit: 2}
+	var pages, count int
+	err = c.StreamLogs(q, func(logs []*Log) error {
+		pages++
+		count += len(logs)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Could not stream logs: %s", err)
+	}
+	if pages != 2 || count != 3 {
+		t.Errorf("Expected 3 logs in 2 pages, got %d in %d", count, pages)
+	}
+
+	page, err := c.SearchLogPage(q)
+	if err != nil {
+		t.Fatalf("Could not search logs: %s", err)
+	}
+	l, err := c.GetLog(page.Logs[0].Id)
+	if err != nil {
+		t.Fatalf("Could not get log: %s", err)
+	}
+	if l.Message != "api message" || l.Service != "API Service" {
+		t.Errorf("Expected the inserted log, got %v", l)
+	}
+
+	oc := &APIClient{URL: ts.URL, Token: otherToken, HTTP: http.DefaultClient}
+	if page, err := oc.SearchLogPage(q); err != nil || len(page.Logs) != 0 {
+		t.Errorf("Expected no logs outside of scope, got %v, %v", page, err)
+	}
+	if instances, err := oc.SelectInstances(nil); err != nil || len(instances) != 0 {
+		t.Errorf("Expected no instances outside of scope, got %v, %v", instances, err)
+	}
+	if _, err := oc.GetLog(l.Id); err == nil {
+		t.Error("Expected log outside of scope to not be found")
+	}
+
+	audits, err := SelectReadAudits(s.DB, "API Test User", 100)
+	if err != nil {
+		t.Fatalf("Could not select read audits: %s", err)
+	}
+	// Instances, two pages, search and details.
+	if len(audits) != 5 {
+		t.Errorf("Expected 5 reads to be audited, got %d", len(audits))
+	}
+
+	// Ids which aren't UUIDs are not found, rather than failing the query.
+	if _, err := c.GetLog("abc"); err == nil || err.Error() != "log not found" {
+		t.Errorf("Expected the log not to be found, got %v", err)
+	}
+}
+
+func TestAPIUserCertificate(t *testing.T) {
+	s := &Server{DB: DefaultTestPostgres()}
+	defer s.DB.Exec(`DELETE FROM `+TableAPIUser+` WHERE name=$1`, "API Cert User")
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := CertificateKeyHash(cert)
+	user := &APIUser{Name: "API Cert User", CertHash: &hash}
+	if err := dbutil.TxNow(s.DB, user.Insert); err != nil {
+		t.Fatalf("Could not insert API user: %s", err)
+	}
+
+	// Another key with the signature of the certificate of the user.
+	forged, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Signature = cert.Signature
+
+	tests := []struct {
+		Cert     *x509.Certificate
+		Expected bool
+	}{
+		{cert, true},
+		{forged, false},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, APIPathLogs, nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.Cert}}
+		u, err := s.apiUser(r)
+		if test.Expected && (err != nil || u.Id != user.Id) {
+			t.Errorf("[%d] Expected the user, got %v, %v", i, u, err)
+		}
+		if !test.Expected && err != sql.ErrNoRows {
+			t.Errorf("[%d] Expected no user, got %v, %v", i, u, err)
+		}
+	}
+}
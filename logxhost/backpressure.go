@@ -0,0 +1,101 @@
+package logxhost
+
+import (
+	"time"
+
+	"github.com/monstercat/gologx"
+)
+
+const (
+	DefaultPauseInsertLatency = time.Second
+	DefaultPauseDuration      = 10 * time.Second
+)
+
+// Records how long storing a log took, and tells the clients to pause when
+// the database is slow. Clients resume once the pause is over, or when
+// logs are stored quickly again.
+func (s *Server) checkBackpressure(d time.Duration) {
+	if msg := s.backpressure(d, time.Now()); msg != nil {
+		s.broadcast(*msg)
+	}
+}
+
+// Returns the message to send to the clients, if any, after a log took d to
+// be stored.
+func (s *Server) backpressure(d time.Duration, now time.Time) *logx.ClientMessage {
+	threshold := s.PauseInsertLatency
+	if threshold == 0 {
+		threshold = DefaultPauseInsertLatency
+	}
+	if threshold < 0 {
+		return nil
+	}
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	paused := now.Before(s.pausedUntil)
+	if d >= threshold {
+		if paused {
+			return nil
+		}
+		s.pausedUntil = now.Add(s.pauseDuration())
+		s.Metrics.Pause()
+		return &logx.ClientMessage{
+			Type:    logx.MsgTypePause,
+			Status:  logx.ClientMessageStatusSuccessful,
+			Message: s.pauseDuration().String(),
+		}
+	}
+	if !paused {
+		return nil
+	}
+	s.pausedUntil = time.Time{}
+	return &logx.ClientMessage{
+		Type:   logx.MsgTypeResume,
+		Status: logx.ClientMessageStatusSuccessful,
+	}
+}
+
+func (s *Server) pauseDuration() time.Duration {
+	if s.PauseDuration <= 0 {
+		return DefaultPauseDuration
+	}
+	return s.PauseDuration
+}
+
+// Returns the pause message for a client connecting while the clients are
+// paused, with the remaining time, or nil.
+func (s *Server) currentPause(now time.Time) *logx.ClientMessage {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if !now.Before(s.pausedUntil) {
+		return nil
+	}
+	return &logx.ClientMessage{
+		Type:    logx.MsgTypePause,
+		Status:  logx.ClientMessageStatusSuccessful,
+		Message: s.pausedUntil.Sub(now).String(),
+	}
+}
+
+// Sends the message to every registered client through its writer. Clients
+// whose writer is busy, e.g. as they are slow to read, are skipped rather
+// than holding up the caller, which may be writing a batch of logs. They
+// resume once their pause is over.
+func (s *Server) broadcast(msg logx.ClientMessage) {
+	var chs []chan logx.ClientMessage
+	s.connsMu.Lock()
+	for _, details := range s.conns {
+		if IsAuthorized(*details) {
+			chs = append(chs, details.WrCh)
+		}
+	}
+	s.connsMu.Unlock()
+
+	for _, ch := range chs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package logxhost
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestBackpressure(t *testing.T) {
+	s := &Server{
+		PauseInsertLatency: 100 * time.Millisecond,
+		PauseDuration:      time.Second,
+	}
+	now := time.Now()
+	tests := []struct {
+		Latency  time.Duration
+		After    time.Duration
+		Expected string
+	}{
+		{10 * time.Millisecond, 0, ""},
+		{200 * time.Millisecond, 0, logx.MsgTypePause},
+		// Already paused.
+		{200 * time.Millisecond, 100 * time.Millisecond, ""},
+		{10 * time.Millisecond, 200 * time.Millisecond, logx.MsgTypeResume},
+		{200 * time.Millisecond, 300 * time.Millisecond, logx.MsgTypePause},
+		// The pause is over.
+		{10 * time.Millisecond, 2 * time.Second, ""},
+	}
+	for i, test := range tests {
+		msg := s.backpressure(test.Latency, now.Add(test.After))
+		var typ string
+		if msg != nil {
+			typ = msg.Type
+		}
+		if typ != test.Expected {
+			t.Errorf("[%d] Expected %q, got %q", i, test.Expected, typ)
+		}
+	}
+
+	if msg := s.currentPause(now.Add(500 * time.Millisecond)); msg == nil || msg.Message != "800ms" {
+		t.Errorf("Expected a pause of 800ms for new connections, got %+v", msg)
+	}
+	if msg := s.currentPause(now.Add(2 * time.Second)); msg != nil {
+		t.Errorf("Expected no pause, got %+v", msg)
+	}
+
+	s.PauseInsertLatency = -1
+	if msg := s.backpressure(time.Hour, now); msg != nil {
+		t.Errorf("Expected pausing to be disabled, got %+v", msg)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	ready := &ConnDetails{WrCh: make(chan logx.ClientMessage), Instance: &Instance{Id: "ready"}}
+	busy := &ConnDetails{WrCh: make(chan logx.ClientMessage), Instance: &Instance{Id: "busy"}}
+	unregistered := &ConnDetails{WrCh: make(chan logx.ClientMessage)}
+	s := &Server{conns: map[net.Conn]*ConnDetails{}}
+	for _, details := range []*ConnDetails{ready, busy, unregistered} {
+		c, _ := net.Pipe()
+		defer c.Close()
+		s.conns[c] = details
+	}
+
+	received := make(chan logx.ClientMessage, 1)
+	go func() {
+		received <- <-ready.WrCh
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// Returns although nothing reads the other writers.
+	done := make(chan bool)
+	go func() {
+		s.broadcast(logx.ClientMessage{Type: logx.MsgTypePause})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the broadcast not to wait for busy writers")
+	}
+	select {
+	case msg := <-received:
+		if msg.Type != logx.MsgTypePause {
+			t.Errorf("Expected a pause, got %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the waiting writer to receive the message")
+	}
+}
@@ -12,9 +12,25 @@ import (
 var psql = squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
 
 const (
-	TableService = "service"
-	TableLog     = "log"
-	ViewLog      = "log_view"
+	TableService         = "service"
+	TableMachine         = "machine"
+	TableInstance        = "instance"
+	TableLog             = "log"
+	TableRevokedHash     = "revoked_hash"
+	TableRevocationAudit = "revocation_audit"
+	TableRetentionRule   = "retention_rule"
+	TableSchemaMigration = "schema_migration"
+	TableAPIUser         = "api_user"
+	TableRole            = "role"
+	TableAPIUserRole     = "api_user_role"
+	TableReadAudit       = "read_audit"
+	TableAlertRule       = "alert_rule"
+	TableAlertEvent      = "alert_event"
+	TableLiveness        = "instance_liveness"
+	TableSession         = "instance_session"
+	ViewLog              = "log_view"
+	ViewInstance         = "instance_view"
+	ViewSession          = "instance_session_view"
 )
 
 var (
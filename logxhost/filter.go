@@ -0,0 +1,451 @@
+package logxhost
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+)
+
+// Severities in increasing order, used for comparisons such as severity>=WARN.
+var SeverityLevels = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// FilterError is returned for malformed filter expressions. Column is the
+// 1-based position in the expression where the problem was found.
+type FilterError struct {
+	Column  int
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter at column %d: %s", e.Column, e.Message)
+}
+
+// Filter is a parsed filter expression. It can be used as a squirrel
+// condition on the log view, or set on a LogQuery.
+type Filter struct {
+	// The expression the filter was parsed from.
+	Expr string
+
+	squirrel.Sqlizer
+}
+
+// ParseFilter parses a filter expression such as
+//
+//	service:api severity>=WARN ctx.Path~"/users/*" ctx.Method=POST
+//
+// Terms are of the form field, operator and value. Terms separated by spaces
+// must all match; OR, parentheses and NOT (or a leading -) are supported.
+//
+// Fields:
+//
+//	service, machine   names. : = ~ match with * wildcards, != !~ negate
+//	type               log type. Same operators as service
+//	severity           : = != and > >= < <= using SeverityLevels
+//	message            : full text search, ~ !~ wildcard match, = != exact
+//	time               > >= < <= with a date or date time
+//	ctx.Path.To.Field  context field. : = != compare values (numbers and
+//	                   booleans unless quoted), ~ !~ wildcard match, and
+//	                   > >= < <= compare numbers or text. ctx.Field:* checks
+//	                   that the field exists
+//
+// Values containing spaces or parentheses must be quoted.
+func ParseFilter(expr string) (*Filter, error) {
+	p := &filterParser{src: []rune(expr)}
+	p.skipSpace()
+	if p.eof() {
+		return nil, nil
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", string(p.src[p.pos]))
+	}
+	return &Filter{Expr: expr, Sqlizer: node}, nil
+}
+
+type filterParser struct {
+	src []rune
+	pos int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &FilterError{Column: p.pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *filterParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// Consumes the keyword if it is next, followed by a space or parenthesis.
+func (p *filterParser) keyword(kw string) bool {
+	end := p.pos + len(kw)
+	if end > len(p.src) || string(p.src[p.pos:end]) != kw {
+		return false
+	}
+	if end < len(p.src) && !unicode.IsSpace(p.src[end]) && p.src[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *filterParser) parseOr() (squirrel.Sqlizer, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := squirrel.Or{left}
+	for {
+		p.skipSpace()
+		if !p.keyword("OR") {
+			break
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, right)
+	}
+	if len(or) == 1 {
+		return left, nil
+	}
+	return or, nil
+}
+
+func (p *filterParser) parseAnd() (squirrel.Sqlizer, error) {
+	var and squirrel.And
+	for {
+		p.skipSpace()
+		if p.eof() || p.src[p.pos] == ')' {
+			break
+		}
+		start := p.pos
+		if p.keyword("OR") {
+			p.pos = start
+			break
+		}
+		if p.keyword("AND") {
+			continue
+		}
+		term, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, term)
+	}
+	if len(and) == 0 {
+		if p.eof() {
+			return nil, p.errorf("expected a term")
+		}
+		return nil, p.errorf("expected a term before %q", string(p.src[p.pos]))
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *filterParser) parseUnary() (squirrel.Sqlizer, error) {
+	p.skipSpace()
+	negated := p.keyword("NOT")
+	if !negated && !p.eof() && p.src[p.pos] == '-' {
+		p.pos++
+		negated = true
+	}
+	if negated {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner}, nil
+	}
+	if !p.eof() && p.src[p.pos] == '(' {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.eof() || p.src[p.pos] != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseTerm()
+}
+
+var filterOperators = []string{"!=", "!~", ">=", "<=", ":", "=", "~", ">", "<"}
+
+func isFieldRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+func (p *filterParser) parseTerm() (squirrel.Sqlizer, error) {
+	start := p.pos
+	for !p.eof() && isFieldRune(p.src[p.pos]) {
+		p.pos++
+	}
+	field := string(p.src[start:p.pos])
+	if field == "" {
+		if p.eof() {
+			return nil, p.errorf("expected a field")
+		}
+		return nil, p.errorf("expected a field, got %q", string(p.src[p.pos]))
+	}
+
+	var op string
+	for _, o := range filterOperators {
+		end := p.pos + len(o)
+		if end <= len(p.src) && string(p.src[p.pos:end]) == o {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("expected an operator after %s", field)
+	}
+	p.pos += len(op)
+
+	valuePos := p.pos
+	value, quoted, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if value == "" && !quoted {
+		return nil, p.errorf("expected a value after %s%s", field, op)
+	}
+
+	t := filterTerm{Field: field, Op: op, Value: value, Quoted: quoted}
+	cond, msg := t.compile()
+	if msg != "" {
+		return nil, &FilterError{Column: start + 1, Message: msg}
+	}
+	if cond == nil {
+		return nil, &FilterError{Column: valuePos + 1, Message: "invalid value " + value}
+	}
+	return cond, nil
+}
+
+func (p *filterParser) parseValue() (string, bool, error) {
+	if p.eof() {
+		return "", false, nil
+	}
+	if p.src[p.pos] != '"' {
+		start := p.pos
+		for !p.eof() && !unicode.IsSpace(p.src[p.pos]) && p.src[p.pos] != ')' {
+			p.pos++
+		}
+		return string(p.src[start:p.pos]), false, nil
+	}
+
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		r := p.src[p.pos]
+		p.pos++
+		switch r {
+		case '\\':
+			if p.eof() {
+				return "", false, &FilterError{Column: start + 1, Message: "unterminated string"}
+			}
+			b.WriteRune(p.src[p.pos])
+			p.pos++
+		case '"':
+			return b.String(), true, nil
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return "", false, &FilterError{Column: start + 1, Message: "unterminated string"}
+}
+
+type notExpr struct {
+	squirrel.Sqlizer
+}
+
+func (n notExpr) ToSql() (string, []interface{}, error) {
+	sql, args, err := n.Sqlizer.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return "NOT (" + sql + ")", args, nil
+}
+
+type filterTerm struct {
+	Field  string
+	Op     string
+	Value  string
+	Quoted bool
+}
+
+// Converts a value with * and ? wildcards into a LIKE pattern.
+func globToLike(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`, `?`, `_`)
+	return r.Replace(v)
+}
+
+// Compiles the term into a condition. If the term is invalid, a message
+// is returned instead. A nil condition without message means the value is
+// invalid.
+func (t filterTerm) compile() (squirrel.Sqlizer, string) {
+	if strings.HasPrefix(t.Field, "ctx.") {
+		return t.compileContext(strings.Split(strings.TrimPrefix(t.Field, "ctx."), "."))
+	}
+
+	switch t.Field {
+	case "service", "machine", "type":
+		column := t.Field
+		if column == "type" {
+			column = "log_type"
+		}
+		switch t.Op {
+		case ":", "=", "~":
+			return squirrel.Expr(column+" ILIKE ?", globToLike(t.Value)), ""
+		case "!=", "!~":
+			return squirrel.Expr(column+" NOT ILIKE ?", globToLike(t.Value)), ""
+		}
+	case "severity":
+		return t.compileSeverity()
+	case "message":
+		switch t.Op {
+		case ":":
+			text, err := ParseTextQuery(t.Value)
+			if err != nil || text.IsEmpty() {
+				return nil, ""
+			}
+			return text, ""
+		case "=":
+			return squirrel.Eq{"message": t.Value}, ""
+		case "!=":
+			return squirrel.NotEq{"message": t.Value}, ""
+		case "~":
+			return squirrel.Expr("message ILIKE ?", globToLike(t.Value)), ""
+		case "!~":
+			return squirrel.Expr("message NOT ILIKE ?", globToLike(t.Value)), ""
+		}
+	case "time":
+		var tm time.Time
+		var ok bool
+		for _, f := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if v, err := time.ParseInLocation(f, t.Value, time.Local); err == nil {
+				tm, ok = v, true
+				break
+			}
+		}
+		if !ok {
+			return nil, ""
+		}
+		switch t.Op {
+		case ">":
+			return squirrel.Gt{"log_time": tm}, ""
+		case ">=":
+			return squirrel.GtOrEq{"log_time": tm}, ""
+		case "<":
+			return squirrel.Lt{"log_time": tm}, ""
+		case "<=":
+			return squirrel.LtOrEq{"log_time": tm}, ""
+		}
+	default:
+		return nil, fmt.Sprintf("unknown field %s. Expected service, machine, type, severity, message, time or ctx.Field", t.Field)
+	}
+	return nil, fmt.Sprintf("operator %s is not supported for %s", t.Op, t.Field)
+}
+
+func (t filterTerm) compileSeverity() (squirrel.Sqlizer, string) {
+	const column = "context_data ->> 'Severity'"
+	value := strings.ToUpper(t.Value)
+	switch t.Op {
+	case ":", "=":
+		return squirrel.Expr(column+" = ?", value), ""
+	case "!=":
+		return squirrel.Expr(column+" IS DISTINCT FROM ?", value), ""
+	case ">", ">=", "<", "<=":
+	default:
+		return nil, fmt.Sprintf("operator %s is not supported for severity", t.Op)
+	}
+
+	idx := -1
+	for i, s := range SeverityLevels {
+		if s == value {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Sprintf("unknown severity %s. Expected one of %s", t.Value, strings.Join(SeverityLevels, ", "))
+	}
+
+	var levels []string
+	switch t.Op {
+	case ">":
+		levels = SeverityLevels[idx+1:]
+	case ">=":
+		levels = SeverityLevels[idx:]
+	case "<":
+		levels = SeverityLevels[:idx]
+	case "<=":
+		levels = SeverityLevels[:idx+1]
+	}
+	return squirrel.Expr(column+" = ANY(?)", pq.StringArray(levels)), ""
+}
+
+func (t filterTerm) compileContext(path []string) (squirrel.Sqlizer, string) {
+	for _, p := range path {
+		if p == "" {
+			return nil, "invalid context path " + t.Field
+		}
+	}
+	arr := pq.StringArray(path)
+
+	// Unquoted values are read as JSON so numbers and booleans match.
+	var value interface{} = t.Value
+	if !t.Quoted {
+		var v interface{}
+		if err := json.Unmarshal([]byte(t.Value), &v); err == nil {
+			switch v.(type) {
+			case float64, bool:
+				value = v
+			}
+		}
+	}
+
+	switch t.Op {
+	case ":", "=":
+		if t.Op == ":" && t.Value == "*" && !t.Quoted {
+			return squirrel.Expr("context_data #> ? IS NOT NULL", arr), ""
+		}
+		return ContextPredicate{Path: path, Value: value}, ""
+	case "!=":
+		return notExpr{ContextPredicate{Path: path, Value: value}}, ""
+	case "~":
+		return squirrel.Expr("context_data #>> ? LIKE ?", arr, globToLike(t.Value)), ""
+	case "!~":
+		return squirrel.Expr("context_data #>> ? NOT LIKE ?", arr, globToLike(t.Value)), ""
+	}
+
+	// Comparisons are numeric if the value is a number, and only match
+	// fields which are numbers.
+	if _, err := strconv.ParseFloat(t.Value, 64); err == nil && !t.Quoted {
+		return squirrel.Expr(
+			"(CASE WHEN jsonb_typeof(context_data #> ?) = 'number' THEN (context_data #>> ?)::NUMERIC END) "+t.Op+" ?::NUMERIC",
+			arr, arr, t.Value,
+		), ""
+	}
+	return squirrel.Expr("context_data #>> ? "+t.Op+" ?", arr, t.Value), ""
+}
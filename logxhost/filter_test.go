@@ -0,0 +1,106 @@
+package logxhost
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		Expr     string
+		Sql      string
+		Args     []interface{}
+		ErrorCol int
+	}{
+		{Expr: "", Sql: ""},
+		{
+			Expr: "service:api",
+			Sql:  "service ILIKE ?",
+			Args: []interface{}{"api"},
+		},
+		{
+			Expr: `service:api severity>=WARN ctx.Path~"/users/*" ctx.Method=POST`,
+			Sql:  "(service ILIKE ? AND context_data ->> 'Severity' = ANY(?) AND context_data #>> ? LIKE ? AND context_data @> ?::JSONB)",
+			Args: []interface{}{
+				"api",
+				pq.StringArray{"WARN", "ERROR", "FATAL"},
+				pq.StringArray{"Path"}, "/users/%",
+				`{"Method":"POST"}`,
+			},
+		},
+		{
+			Expr: `ctx.Status=500 ctx.Cached=true ctx.Code="500"`,
+			Sql:  "(context_data @> ?::JSONB AND context_data @> ?::JSONB AND context_data @> ?::JSONB)",
+			Args: []interface{}{`{"Status":500}`, `{"Cached":true}`, `{"Code":"500"}`},
+		},
+		{
+			Expr: "machine:web-* OR NOT (type=RouteHostLog -ctx.Headers.User-Agent:*)",
+			Sql:  "(machine ILIKE ? OR NOT ((log_type ILIKE ? AND NOT (context_data #> ? IS NOT NULL))))",
+			Args: []interface{}{"web-%", "RouteHostLog", pq.StringArray{"Headers", "User-Agent"}},
+		},
+		{
+			Expr: "ctx.Duration>1.5",
+			Sql:  "(CASE WHEN jsonb_typeof(context_data #> ?) = 'number' THEN (context_data #>> ?)::NUMERIC END) > ?::NUMERIC",
+			Args: []interface{}{pq.StringArray{"Duration"}, pq.StringArray{"Duration"}, "1.5"},
+		},
+		{
+			Expr: `message~"100%_done*"`,
+			Sql:  "message ILIKE ?",
+			Args: []interface{}{`100\%\_done%`},
+		},
+		{
+			Expr: `message:"connection reset"`,
+			Sql:  "message_tsv @@ to_tsquery('simple', ?)",
+			Args: []interface{}{"'connection' & 'reset'"},
+		},
+		{Expr: "servce:api", ErrorCol: 1},
+		{Expr: "service:api severity", ErrorCol: 21},
+		{Expr: "service:", ErrorCol: 9},
+		{Expr: `ctx.Path="/users`, ErrorCol: 10},
+		{Expr: "severity>LOUD", ErrorCol: 1},
+		{Expr: "service>api", ErrorCol: 1},
+		{Expr: "time<yesterday", ErrorCol: 6},
+		{Expr: "(service:api", ErrorCol: 13},
+		{Expr: "service:api)", ErrorCol: 12},
+		{Expr: "service:api OR", ErrorCol: 15},
+		{Expr: "NOT", ErrorCol: 4},
+		{Expr: "service:api NOT", ErrorCol: 16},
+	}
+
+	for idx, test := range tests {
+		f, err := ParseFilter(test.Expr)
+		if test.ErrorCol > 0 {
+			ferr, ok := err.(*FilterError)
+			if !ok {
+				t.Errorf("[%d] Expected a filter error, got %v", idx, err)
+			} else if ferr.Column != test.ErrorCol {
+				t.Errorf("[%d] Expected error at column %d, got %s", idx, test.ErrorCol, ferr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d] Unexpected error %s", idx, err)
+			continue
+		}
+		if f == nil {
+			if test.Sql != "" {
+				t.Errorf("[%d] Expected a filter", idx)
+			}
+			continue
+		}
+
+		sql, args, err := f.ToSql()
+		if err != nil {
+			t.Errorf("[%d] Unexpected error %s", idx, err)
+			continue
+		}
+		if sql != test.Sql {
+			t.Errorf("[%d] Expected %s, got %s", idx, test.Sql, sql)
+		}
+		if !reflect.DeepEqual(args, test.Args) {
+			t.Errorf("[%d] Expected args %v, got %v", idx, test.Args, args)
+		}
+	}
+}
@@ -61,9 +61,9 @@ func TestHostHandler(t *testing.T) {
 		},
 	}
 
-	var serviceIds []string
+	var instanceIds []string
 	defer func() {
-		server.DB.Exec(`DELETE FROM service WHERE id = ANY(?)`, pq.StringArray(serviceIds))
+		server.DB.Exec(`DELETE FROM instance WHERE id = ANY($1)`, pq.StringArray(instanceIds))
 	}()
 
 	var filesToCleanup []string
@@ -135,24 +135,24 @@ func TestHostHandler(t *testing.T) {
 		time.Sleep(time.Second)
 
 		// We need to get the last seen from the server.
-		service, err := GetServiceByName(server.DB, client.Machine, client.Service)
+		instance, err := GetInstanceByName(server.DB, client.Machine, client.Service)
 		if err != nil {
-			t.Errorf("Could not get service for %d! %s", idx, err)
+			t.Errorf("Could not get instance for %d! %s", idx, err)
 			continue
 		}
-		serviceIds = append(serviceIds, service.Id)
-		lastSeen := service.LastSeen
+		instanceIds = append(instanceIds, instance.Id)
+		lastSeen := instance.LastSeen
 
 		// Sleep for 2 seconds. Now we check heartbeat and every other log.
 		time.Sleep(5 * time.Second)
 
 		// After two seconds, the lastSeen should have been changed!
-		service, err = GetServiceByName(server.DB, client.Machine, client.Service)
+		instance, err = GetInstanceByName(server.DB, client.Machine, client.Service)
 		if err != nil {
-			t.Errorf("Could not get service after two seconds for %d! %s", idx, err)
+			t.Errorf("Could not get instance after two seconds for %d! %s", idx, err)
 			continue
 		}
-		if lastSeen.Equal(service.LastSeen) {
+		if lastSeen.Equal(instance.LastSeen) {
 			t.Errorf("Last seen should have changed in two seconds!")
 		}
 
@@ -170,7 +170,7 @@ func TestHostHandler(t *testing.T) {
 
 		// Check for existence of logs
 		var logIds []string
-		if err := server.DB.Select(&logIds, `SELECT id FROM log WHERE service_id=$1`, service.Id); err != nil {
+		if err := server.DB.Select(&logIds, `SELECT id FROM log WHERE instance_id=$1`, instance.Id); err != nil {
 			t.Error(err.Error())
 		}
 		if len(logIds) != len(test.TestLogs) {
@@ -0,0 +1,67 @@
+package logxhost
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// The interval of a histogram is increased if it would have more buckets.
+const MaxHistogramBuckets = 500
+
+var (
+	ErrHistogramRange = errors.New("histogram requires a time range")
+)
+
+// LogHistogramBucket is the number of logs from Time until the next bucket.
+type LogHistogramBucket struct {
+	Time  time.Time
+	Count int64
+}
+
+// Buckets are aligned on the interval since the unix epoch, so the same
+// interval always gives the same buckets.
+func logHistogramQuery(q *LogQuery, interval time.Duration) (squirrel.SelectBuilder, error) {
+	var sel squirrel.SelectBuilder
+	if q.After.IsZero() || q.Before.IsZero() || !q.Before.After(q.After) {
+		return sel, ErrHistogramRange
+	}
+	if min := q.Before.Sub(q.After) / MaxHistogramBuckets; interval < min {
+		interval = min
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	secs := int64(interval / time.Second)
+
+	where, err := q.FilterWhere()
+	if err != nil {
+		return sel, err
+	}
+	sel = psql.Select().
+		Column(squirrel.Expr("to_timestamp(floor(extract(EPOCH FROM log_time) / ?) * ?) AS time", secs, secs)).
+		Column("COUNT(*) AS count").
+		From(ViewLog).
+		Where(where).
+		GroupBy("1").
+		OrderBy("1")
+	return sel, nil
+}
+
+// LogHistogram counts the logs matching the query per interval, between
+// the After and Before times of the query which are required. Buckets
+// without logs are left out. Cursors, order and limit are ignored.
+func LogHistogram(db sqlx.Queryer, q *LogQuery, interval time.Duration) ([]*LogHistogramBucket, error) {
+	sel, err := logHistogramQuery(q, interval)
+	if err != nil {
+		return nil, err
+	}
+	var xs []*LogHistogramBucket
+	if err := dbutil.Select(db, &xs, sel); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
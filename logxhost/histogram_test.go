@@ -0,0 +1,52 @@
+package logxhost
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogHistogramQuery(t *testing.T) {
+	after := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := logHistogramQuery(&LogQuery{After: after}, time.Minute); err != ErrHistogramRange {
+		t.Errorf("Expected %s, got %v", ErrHistogramRange, err)
+	}
+
+	tests := []struct {
+		Range    time.Duration
+		Interval time.Duration
+		Expected int64
+	}{
+		{Range: time.Hour, Interval: time.Minute, Expected: 60},
+		{Range: time.Hour, Interval: 0, Expected: 7},
+		{Range: 30 * 24 * time.Hour, Interval: time.Minute, Expected: 5184},
+	}
+	for idx, test := range tests {
+		q := &LogQuery{
+			Services:  []string{"api"},
+			After:     after,
+			Before:    after.Add(test.Range),
+			PageAfter: &Cursor{Time: after, Id: "id"},
+		}
+		sel, err := logHistogramQuery(q, test.Interval)
+		if err != nil {
+			t.Errorf("[%d] Unexpected error %s", idx, err)
+			continue
+		}
+		query, args, err := sel.ToSql()
+		if err != nil {
+			t.Errorf("[%d] Unexpected error %s", idx, err)
+			continue
+		}
+		if args[0] != test.Expected {
+			t.Errorf("[%d] Expected interval of %d seconds, got %v", idx, test.Expected, args[0])
+		}
+		if strings.Contains(query, "(log_time, id)") {
+			t.Errorf("[%d] Expected cursor to be ignored. Got %s", idx, query)
+		}
+		if !strings.Contains(query, "GROUP BY 1 ORDER BY 1") {
+			t.Errorf("[%d] Expected buckets to be grouped and ordered. Got %s", idx, query)
+		}
+	}
+}
@@ -1,16 +1,44 @@
 package logxhost
 
 import (
+	"errors"
+
 	"github.com/jmoiron/sqlx"
 
 	"github.com/monstercat/gologx"
 )
 
-func InsertHostMessage(db sqlx.Ext, msg logx.HostMessage, service string ) error {
+// ErrDuplicateLog is returned when a log with the same client id and time
+// was already stored for the service.
+var ErrDuplicateLog = errors.New("log was already stored")
+
+// InsertHostMessage stores the message and notifies LogChannel with the id
+// of the new log once the insert is committed.
+//
+// The id of the message is the one generated by the client, and is unique
+// per service. A message which was already stored, e.g. because the client
+// didn't get the acknowledgement and sent it again, is not stored twice and
+// ErrDuplicateLog is returned.
+func InsertHostMessage(db sqlx.Ext, msg logx.HostMessage, instance string) error {
 	query := `
-INSERT INTO log(service_id, log_type, log_time, message, context)
-VALUES($1,$2,$3,$4,$5);
+WITH inserted AS (
+    INSERT INTO log(instance_id, service_id, log_type, log_time, message, context, client_id)
+    VALUES($1, (SELECT service_id FROM instance WHERE id=$1), $2, $3, $4, $5, NULLIF($6::TEXT, ''))
+    ON CONFLICT DO NOTHING
+    RETURNING id
+)
+SELECT pg_notify('` + LogChannel + `', id::TEXT) FROM inserted;
 `
-	_, err := db.Exec(query, service, msg.Type, msg.Time, msg.Message, msg.Context)
-	return err
+	res, err := db.Exec(query, instance, msg.Type, msg.Time, msg.Message, msg.Context, msg.Id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDuplicateLog
+	}
+	return nil
 }
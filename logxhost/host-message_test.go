@@ -0,0 +1,85 @@
+package logxhost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestInsertHostMessage(t *testing.T) {
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableLog+` WHERE instance_id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "Insert Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=ANY($1)`, pq.StringArray{"Insert Machine", "Insert Machine 2"})
+	}()
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	instance, err := s.RegisterService(logx.HostMessage{
+		Type:    logx.MsgTypeRegister,
+		Machine: "Insert Machine",
+		Service: "Insert Service",
+	}, ConnDetails{Hash: s.marshalHash(cert.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, instance.Id)
+
+	// The same service on another machine.
+	cert2, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	other, err := s.RegisterService(logx.HostMessage{
+		Type:    logx.MsgTypeRegister,
+		Machine: "Insert Machine 2",
+		Service: "Insert Service",
+	}, ConnDetails{Hash: s.marshalHash(cert2.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, other.Id)
+
+	now := time.Now()
+	tests := []struct {
+		Msg      logx.HostMessage
+		Instance string
+		Expected error
+	}{
+		{logx.HostMessage{Id: "client-1", Type: "TestLog", Time: now, Message: []byte("first"), Context: []byte("{}")}, instance.Id, nil},
+		// Sent again after the acknowledgement was lost.
+		{logx.HostMessage{Id: "client-1", Type: "TestLog", Time: now, Message: []byte("first"), Context: []byte("{}")}, instance.Id, ErrDuplicateLog},
+		// Sent again after the client moved to another machine.
+		{logx.HostMessage{Id: "client-1", Type: "TestLog", Time: now, Message: []byte("first"), Context: []byte("{}")}, other.Id, ErrDuplicateLog},
+		{logx.HostMessage{Id: "client-2", Type: "TestLog", Time: now, Message: []byte("second"), Context: []byte("{}")}, instance.Id, nil},
+		// Older clients don't send an id.
+		{logx.HostMessage{Type: "TestLog", Time: now, Message: []byte("no id"), Context: []byte("{}")}, instance.Id, nil},
+		{logx.HostMessage{Type: "TestLog", Time: now, Message: []byte("no id"), Context: []byte("{}")}, instance.Id, nil},
+	}
+	for i, test := range tests {
+		if err := InsertHostMessage(s.DB, test.Msg, test.Instance); err != test.Expected {
+			t.Errorf("[%d] Expected %v, got %v", i, test.Expected, err)
+		}
+	}
+
+	var n int
+	if err := s.DB.Get(&n, `SELECT COUNT(*) FROM `+TableLog+` WHERE instance_id=ANY($1)`, pq.StringArray(ids)); err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 logs, got %d", n)
+	}
+}
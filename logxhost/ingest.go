@@ -0,0 +1,254 @@
+package logxhost
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+
+	"github.com/monstercat/gologx"
+)
+
+const (
+	DefaultIngestBatchSize     = 500
+	DefaultIngestFlushInterval = 50 * time.Millisecond
+)
+
+// Ingester stores the logs received by all connections in batches, rather
+// than with an insert per log. A batch is written once it holds BatchSize
+// logs, or FlushInterval after its first log was received, and the logs
+// are only acknowledged once it is committed.
+//
+// Ingest blocks while a full batch is waiting to be written, so that slow
+// writes slow the connections down instead of buffering logs in memory.
+type Ingester struct {
+	DB *sqlx.DB
+
+	// Default to DefaultIngestBatchSize and DefaultIngestFlushInterval.
+	BatchSize     int
+	FlushInterval time.Duration
+
+	in   chan *ingestItem
+	once sync.Once
+
+	// Closed by Stop, and once Run returns.
+	stop     chan bool
+	stopOnce sync.Once
+	stopped  chan bool
+
+	// Writes a batch, setting the result of each item. Defaults to
+	// writeBatch.
+	write func([]*ingestItem) error
+}
+
+type ingestItem struct {
+	Msg      logx.HostMessage
+	Instance *Instance
+	Received time.Time
+
+	// Called with the result once the batch is written: nil if the log
+	// was stored, ErrDuplicateLog if it already was, or the error.
+	Done func(error)
+	err  error
+}
+
+func (g *Ingester) batchSize() int {
+	if g.BatchSize <= 0 {
+		return DefaultIngestBatchSize
+	}
+	return g.BatchSize
+}
+
+func (g *Ingester) flushInterval() time.Duration {
+	if g.FlushInterval <= 0 {
+		return DefaultIngestFlushInterval
+	}
+	return g.FlushInterval
+}
+
+func (g *Ingester) init() {
+	g.once.Do(func() {
+		g.in = make(chan *ingestItem, g.batchSize())
+		g.stop = make(chan bool)
+		g.stopped = make(chan bool)
+		if g.write == nil {
+			g.write = g.writeBatch
+		}
+	})
+}
+
+// Ingest queues the message of the instance to be stored. done is called
+// with the result once its batch is written. Run must be running.
+func (g *Ingester) Ingest(msg logx.HostMessage, instance *Instance, done func(error)) {
+	g.init()
+	g.in <- &ingestItem{Msg: msg, Instance: instance, Received: time.Now(), Done: done}
+}
+
+// Run writes the batches until die is closed or Stop is called, after
+// writing the logs already queued. Errors writing a batch are sent to eh,
+// and to the done function of each log.
+func (g *Ingester) Run(die chan bool, eh func(error)) {
+	g.init()
+	defer close(g.stopped)
+	var batch []*ingestItem
+	var flush <-chan time.Time
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := g.write(batch); err != nil {
+			eh(err)
+		}
+		for _, item := range batch {
+			item.Done(item.err)
+		}
+		batch, flush = nil, nil
+	}
+
+	// Writes the logs already queued.
+	drain := func() {
+		for {
+			select {
+			case item := <-g.in:
+				batch = append(batch, item)
+				if len(batch) >= g.batchSize() {
+					write()
+				}
+			default:
+				write()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-die:
+			drain()
+			return
+		case <-g.stop:
+			drain()
+			return
+		case item := <-g.in:
+			batch = append(batch, item)
+			if len(batch) == 1 {
+				flush = time.After(g.flushInterval())
+			}
+			if len(batch) >= g.batchSize() {
+				write()
+			}
+		case <-flush:
+			write()
+		}
+	}
+}
+
+// Stop stops Run once the logs already queued are written, and waits for it
+// to return. Run must have been started.
+func (g *Ingester) Stop() {
+	g.init()
+	g.stopOnce.Do(func() {
+		close(g.stop)
+	})
+	<-g.stopped
+}
+
+// Writes the batch in a single transaction. If it fails, e.g. because one
+// of the logs is invalid, each log is stored separately so that the others
+// can be stored.
+func (g *Ingester) writeBatch(batch []*ingestItem) error {
+	inserted, err := copyLogs(g.DB, batch)
+	if err != nil {
+		for _, item := range batch {
+			item.err = InsertHostMessage(g.DB, item.Msg, item.Instance.Id)
+		}
+		return err
+	}
+	for _, item := range batch {
+		if item.Msg.Id != "" && !inserted[item.Instance.ServiceId+"/"+item.Msg.Id] {
+			item.err = ErrDuplicateLog
+		}
+	}
+	return nil
+}
+
+// Copies the logs into a temporary table, from which they are inserted
+// skipping the ones already stored. Returns the service and client ids of
+// the inserted logs.
+func copyLogs(db *sqlx.DB, batch []*ingestItem) (map[string]bool, error) {
+	inserted := make(map[string]bool)
+	err := dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`CREATE TEMPORARY TABLE IF NOT EXISTS log_ingest
+(
+    instance_id UUID,
+    service_id  UUID,
+    log_type    TEXT,
+    log_time    TIMESTAMPTZ,
+    message     TEXT,
+    context     JSONB,
+    client_id   TEXT
+) ON COMMIT DELETE ROWS`)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.Prepare(pq.CopyIn("log_ingest", "instance_id", "service_id", "log_type", "log_time", "message", "context", "client_id"))
+		if err != nil {
+			return err
+		}
+		for _, item := range batch {
+			var clientId interface{}
+			if item.Msg.Id != "" {
+				clientId = item.Msg.Id
+			}
+			// Byte slices would be copied as bytea.
+			_, err := stmt.Exec(item.Instance.Id, item.Instance.ServiceId, item.Msg.Type, item.Msg.Time, string(item.Msg.Message), string(item.Msg.Context), clientId)
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			stmt.Close()
+			return err
+		}
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(`
+INSERT INTO log(instance_id, service_id, log_type, log_time, message, context, client_id)
+SELECT instance_id, service_id, log_type, log_time, message, context, client_id
+FROM log_ingest
+ON CONFLICT DO NOTHING
+RETURNING id, service_id, client_id`)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			var serviceId, clientId sql.NullString
+			if err := rows.Scan(&id, &serviceId, &clientId); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			if clientId.Valid {
+				inserted[serviceId.String+"/"+clientId.String] = true
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Notifications are sent once committed.
+		_, err = tx.Exec(`SELECT pg_notify('`+LogChannel+`', id) FROM unnest($1::TEXT[]) id`, pq.StringArray(ids))
+		return err
+	})
+	return inserted, err
+}
@@ -0,0 +1,263 @@
+package logxhost
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestIngesterBatches(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	g := &Ingester{
+		BatchSize:     3,
+		FlushInterval: 20 * time.Millisecond,
+		write: func(batch []*ingestItem) error {
+			mu.Lock()
+			sizes = append(sizes, len(batch))
+			mu.Unlock()
+			for _, item := range batch {
+				if item.Msg.Id == "bad" {
+					item.err = errors.New("invalid")
+				}
+			}
+			return nil
+		},
+	}
+	die := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		g.Run(die, func(err error) {
+			t.Error(err)
+		})
+		close(stopped)
+	}()
+
+	var wg sync.WaitGroup
+	results := make([]error, 7)
+	for i := range results {
+		id := fmt.Sprintf("log-%d", i)
+		if i == 4 {
+			id = "bad"
+		}
+		i := i
+		wg.Add(1)
+		g.Ingest(logx.HostMessage{Id: id}, &Instance{}, func(err error) {
+			results[i] = err
+			wg.Done()
+		})
+	}
+	wg.Wait()
+
+	// The last log is written after the flush interval.
+	mu.Lock()
+	if fmt.Sprint(sizes) != "[3 3 1]" {
+		t.Errorf("Expected batches of 3, 3 and 1, got %v", sizes)
+	}
+	mu.Unlock()
+	for i, err := range results {
+		if (i == 4) != (err != nil) {
+			t.Errorf("[%d] Unexpected result %v", i, err)
+		}
+	}
+
+	// Logs queued when stopping are written.
+	wg.Add(1)
+	g.Ingest(logx.HostMessage{Id: "last"}, &Instance{}, func(err error) {
+		wg.Done()
+	})
+	close(die)
+	wg.Wait()
+	<-stopped
+}
+
+func TestIngesterStop(t *testing.T) {
+	g := &Ingester{
+		FlushInterval: time.Hour,
+		write: func(batch []*ingestItem) error {
+			return nil
+		},
+	}
+	stopped := make(chan bool)
+	go func() {
+		g.Run(make(chan bool), func(err error) {
+			t.Error(err)
+		})
+		close(stopped)
+	}()
+
+	written := make(chan bool, 1)
+	g.Ingest(logx.HostMessage{Id: "queued"}, &Instance{}, func(err error) {
+		written <- true
+	})
+	g.Stop()
+
+	// Written before the flush interval, as Run returned.
+	select {
+	case <-written:
+	default:
+		t.Error("Expected the queued log to be written")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected Run to return")
+	}
+}
+
+func TestIngester(t *testing.T) {
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableLog+` WHERE instance_id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "Ingest Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, "Ingest Machine")
+	}()
+
+	instance := registerTestInstance(t, s, "Ingest Machine", "Ingest Service")
+	ids = append(ids, instance.Id)
+
+	g := &Ingester{DB: s.DB, BatchSize: 10, FlushInterval: 10 * time.Millisecond}
+	die := make(chan bool)
+	defer close(die)
+	go g.Run(die, func(err error) {
+		// The batch with the invalid log fails, which is checked below.
+	})
+
+	now := time.Now()
+	msgs := []logx.HostMessage{
+		{Id: "ingest-1", Type: "TestLog", Time: now, Message: []byte("first"), Context: []byte("{}")},
+		{Id: "ingest-2", Type: "TestLog", Time: now, Message: []byte("second, with \t and \\ escaped"), Context: []byte(`{"a": 1}`)},
+		{Type: "TestLog", Time: now, Message: []byte("no id"), Context: []byte("{}")},
+	}
+	ingest := func(msgs []logx.HostMessage) []error {
+		var wg sync.WaitGroup
+		results := make([]error, len(msgs))
+		for i, msg := range msgs {
+			i := i
+			wg.Add(1)
+			g.Ingest(msg, instance, func(err error) {
+				results[i] = err
+				wg.Done()
+			})
+		}
+		wg.Wait()
+		return results
+	}
+
+	for i, err := range ingest(msgs) {
+		if err != nil {
+			t.Errorf("[%d] Could not ingest: %s", i, err)
+		}
+	}
+
+	// Sent again, and an invalid log which makes the batch fail so that the
+	// logs are stored one by one.
+	again := []logx.HostMessage{
+		msgs[0],
+		{Id: "ingest-3", Type: "TestLog", Time: now, Message: []byte("third"), Context: []byte("{}")},
+		{Id: "ingest-4", Type: "TestLog", Time: now, Message: []byte("invalid"), Context: []byte("not json")},
+	}
+	results := ingest(again)
+	if results[0] != ErrDuplicateLog {
+		t.Errorf("Expected a duplicate, got %v", results[0])
+	}
+	if results[1] != nil {
+		t.Errorf("Expected the log to be stored, got %s", results[1])
+	}
+	if results[2] == nil {
+		t.Error("Expected the invalid log to fail")
+	}
+
+	logs, err := SelectLogs(s.DB, SelectLogQry.Where("instance_id=?", instance.Id).OrderBy("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 4 {
+		t.Fatalf("Expected 4 logs, got %d", len(logs))
+	}
+	if logs[2].Message != "second, with \t and \\ escaped" {
+		t.Errorf("Expected the message to be copied as is, got %q", logs[2].Message)
+	}
+}
+
+// Compares storing logs one at a time with the ingester.
+func BenchmarkInsertHostMessage(b *testing.B) {
+	s := &Server{DB: DefaultTestPostgres(), SigCache: make(map[string]*Instance)}
+	instance := registerTestInstance(b, s, "Benchmark Machine", "Benchmark Service")
+	defer cleanupTestInstance(s, instance)
+
+	msg := logx.HostMessage{Type: "TestLog", Message: []byte("benchmark"), Context: []byte("{}")}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg.Id = fmt.Sprintf("insert-%d", i)
+		msg.Time = time.Now()
+		if err := InsertHostMessage(s.DB, msg, instance.Id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIngester(b *testing.B) {
+	s := &Server{DB: DefaultTestPostgres(), SigCache: make(map[string]*Instance)}
+	instance := registerTestInstance(b, s, "Benchmark Machine", "Benchmark Service")
+	defer cleanupTestInstance(s, instance)
+
+	g := &Ingester{DB: s.DB}
+	die := make(chan bool)
+	defer close(die)
+	go g.Run(die, func(err error) {
+		b.Error(err)
+	})
+
+	var wg sync.WaitGroup
+	msg := logx.HostMessage{Type: "TestLog", Message: []byte("benchmark"), Context: []byte("{}")}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg.Id = fmt.Sprintf("ingest-%d", i)
+		msg.Time = time.Now()
+		wg.Add(1)
+		g.Ingest(msg, instance, func(err error) {
+			if err != nil {
+				b.Error(err)
+			}
+			wg.Done()
+		})
+	}
+	wg.Wait()
+}
+
+func registerTestInstance(t testing.TB, s *Server, machine, service string) *Instance {
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	instance, err := s.RegisterService(logx.HostMessage{
+		Type:    logx.MsgTypeRegister,
+		Machine: machine,
+		Service: service,
+	}, ConnDetails{Hash: s.marshalHash(cert.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	return instance
+}
+
+func cleanupTestInstance(s *Server, instance *Instance) {
+	s.DB.Exec(`DELETE FROM `+TableLog+` WHERE instance_id=$1`, instance.Id)
+	s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=$1`, instance.Id)
+	s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, instance.Service)
+	s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, instance.Machine)
+}
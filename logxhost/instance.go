@@ -0,0 +1,123 @@
+package logxhost
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// Instance is a service running on a specific machine. Each instance is
+// unique by service and machine. Certificates, and therefore connections,
+// are associated with instances.
+type Instance struct {
+	Id        string    `setmap:"ignore"`
+	ServiceId string    `db:"service_id"`
+	MachineId string    `db:"machine_id"`
+	LastSeen  time.Time `db:"last_seen"`
+	SigHash   []byte    `db:"sig_hash" json:"-"`
+
+	// Interval at which the instance sends heartbeats. Zero if it didn't
+	// send one when registering.
+	HeartbeatSeconds int `db:"heartbeat_seconds"`
+
+	// State of the instance according to its heartbeats, as last recorded
+	// by the liveness monitor.
+	Liveness LivenessState `setmap:"ignore"`
+
+	// These are read from the instance view.
+	Service string        `setmap:"ignore"`
+	Machine string        `setmap:"ignore"`
+	Status  ServiceStatus `setmap:"ignore"`
+}
+
+var (
+	ColsInstance = dbutil.GetColumnsList(&Instance{}, "")
+)
+
+// IsActive returns whether the service of this instance may still log
+// to the host.
+func (i *Instance) IsActive() bool {
+	return i.Status == ServiceStatusActive
+}
+
+func (i *Instance) Insert(tx *sqlx.Tx) error {
+	return psql.Insert(TableInstance).
+		SetMap(dbutil.SetMap(i, true)).
+		Suffix("RETURNING id").
+		RunWith(tx).
+		QueryRow().
+		Scan(&i.Id)
+}
+
+// UpdateHash associates the instance with its current hash. The hash is
+// removed from any other instance, as a certificate can only identify one.
+func (i *Instance) UpdateHash(db sqlx.Ext) error {
+	if i.Id == "" {
+		return ErrInvalidId
+	}
+	if _, err := db.Exec(`UPDATE instance SET sig_hash='' WHERE sig_hash=$2 AND id<>$1`, i.Id, i.SigHash); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE instance SET sig_hash=$2 WHERE id=$1`, i.Id, i.SigHash)
+	return err
+}
+
+func (i *Instance) UpdateLastSeen(db sqlx.Ext) error {
+	if i.Id == "" {
+		return ErrInvalidId
+	}
+	_, err := db.Exec(`UPDATE instance SET last_seen=NOW() WHERE id=$1`, i.Id)
+	return err
+}
+
+// UpdateHeartbeatInterval stores the interval at which the instance sends
+// heartbeats.
+func (i *Instance) UpdateHeartbeatInterval(db sqlx.Ext) error {
+	if i.Id == "" {
+		return ErrInvalidId
+	}
+	_, err := db.Exec(`UPDATE instance SET heartbeat_seconds=$2 WHERE id=$1`, i.Id, i.HeartbeatSeconds)
+	return err
+}
+
+func GetInstance(db sqlx.Queryer, where interface{}) (*Instance, error) {
+	var i Instance
+	if err := dbutil.Get(db, &i, psql.Select(ColsInstance...).From(ViewInstance).Where(where)); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func GetInstanceByHash(db sqlx.Queryer, hash []byte) (*Instance, error) {
+	return GetInstance(db, squirrel.Eq{"sig_hash": hash})
+}
+
+func GetInstanceByName(db sqlx.Queryer, machine, service string) (*Instance, error) {
+	return GetInstance(db, squirrel.Eq{"service": service, "machine": machine})
+}
+
+// SelectInstances returns the instances of the services matching the
+// provided names. No names will return all instances.
+func SelectInstances(db sqlx.Queryer, services []string) ([]*Instance, error) {
+	return SelectInstancesInScope(db, services, nil)
+}
+
+// SelectInstancesInScope is like SelectInstances, but only returns the
+// instances of services in the scope.
+func SelectInstancesInScope(db sqlx.Queryer, services []string, scope *AccessScope) ([]*Instance, error) {
+	var xs []*Instance
+	var qry = psql.Select(ColsInstance...).From(ViewInstance).OrderBy("service", "machine")
+	qry = scopeWhere(qry, scope)
+
+	if len(services) > 0 {
+		qry = qry.Where("service LIKE ANY(?)", pq.StringArray(services))
+	}
+
+	if err := dbutil.Select(db, &xs, qry); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
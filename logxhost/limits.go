@@ -0,0 +1,241 @@
+package logxhost
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/monstercat/gologx"
+)
+
+const (
+	DefaultHandshakeTimeout = 10 * time.Second
+	DefaultIdleTimeout      = 5 * time.Minute
+	DefaultWriteTimeout     = 10 * time.Second
+	DefaultMaxMessageSize   = 1 << 20
+)
+
+// Reasons connections and logs are rejected, in the metrics.
+const (
+	RejectedConnections        = "connections"
+	RejectedServiceConnections = "service_connections"
+	RejectedRateLimit          = "rate_limit"
+	RejectedMessageSize        = "message_size"
+)
+
+// Messages telling the clients why they were rejected.
+var rejectionMessages = map[string]string{
+	RejectedConnections:        "Too many connections",
+	RejectedServiceConnections: "Too many connections for the service",
+	RejectedRateLimit:          "Rate limit exceeded",
+	RejectedMessageSize:        "Message too large",
+}
+
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Reads the messages of a connection, failing with ErrMessageTooLarge when
+// more than max bytes are read past the end of the last message. Only the
+// message being decoded is therefore held in memory, along with what was
+// read ahead of it.
+type messageLimiter struct {
+	r     io.Reader
+	max   int64
+	read  int64
+	start int64
+}
+
+func (l *messageLimiter) Read(p []byte) (int, error) {
+	if l.max <= 0 {
+		n, err := l.r.Read(p)
+		l.read += int64(n)
+		return n, err
+	}
+	left := l.max - (l.read - l.start)
+	if left <= 0 {
+		return 0, ErrMessageTooLarge
+	}
+	if int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+// Marks the end of a message, at the offset of the decoder.
+func (l *messageLimiter) next(offset int64) {
+	l.start = offset
+}
+
+// Decodes the messages of a connection, allowing each up to max bytes. See
+// messageLimiter.
+type messageDecoder struct {
+	dec     *json.Decoder
+	limiter *messageLimiter
+}
+
+func newMessageDecoder(r io.Reader, max int64) *messageDecoder {
+	limiter := &messageLimiter{r: r, max: max}
+	return &messageDecoder{dec: json.NewDecoder(limiter), limiter: limiter}
+}
+
+func (d *messageDecoder) Decode(m *logx.HostMessage) error {
+	if err := d.dec.Decode(m); err != nil {
+		return err
+	}
+	d.limiter.next(d.dec.InputOffset())
+	return nil
+}
+
+// Limits the rate of logs of each service with a token bucket, refilled
+// with rate tokens per second up to burst.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token for the service, and returns whether there was one.
+func (r *rateLimiter) allow(service string, rate float64, burst int, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.buckets == nil {
+		r.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := r.buckets[service]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		r.buckets[service] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (s *Server) handshakeTimeout() time.Duration {
+	if s.HandshakeTimeout <= 0 {
+		return DefaultHandshakeTimeout
+	}
+	return s.HandshakeTimeout
+}
+
+// Time a connection may stay without sending anything. Instances sending
+// heartbeats less often are given until they would be considered dead.
+func (s *Server) idleTimeout(instance *Instance) time.Duration {
+	d := s.IdleTimeout
+	if d <= 0 {
+		d = DefaultIdleTimeout
+	}
+	if instance != nil {
+		if dead := time.Duration(DeadHeartbeats) * instance.HeartbeatInterval(); dead > d {
+			d = dead
+		}
+	}
+	return d
+}
+
+func (s *Server) writeTimeout() time.Duration {
+	if s.WriteTimeout <= 0 {
+		return DefaultWriteTimeout
+	}
+	return s.WriteTimeout
+}
+
+func (s *Server) maxMessageSize() int64 {
+	if s.MaxMessageSize == 0 {
+		return DefaultMaxMessageSize
+	}
+	return s.MaxMessageSize
+}
+
+// Returns the reason the connection of the instance is rejected as its
+// service has too many connections, if it is.
+func (s *Server) checkServiceConns(conn net.Conn, instance *Instance) string {
+	if s.MaxServiceConnections <= 0 || instance == nil {
+		return ""
+	}
+	var n int
+	s.connsMu.Lock()
+	for c, d := range s.conns {
+		if c != conn && d.Instance != nil && d.Instance.ServiceId == instance.ServiceId {
+			n++
+		}
+	}
+	s.connsMu.Unlock()
+	if n >= s.MaxServiceConnections {
+		return RejectedServiceConnections
+	}
+	return ""
+}
+
+// Number of rejected connections told why at once. The others are closed
+// right away, so that a flood of connections doesn't pile up goroutines.
+const maxRejecting = 16
+
+// Rejects the connection in the background, without blocking Serve.
+func (s *Server) reject(conn net.Conn, reason string) {
+	if atomic.AddInt64(&s.rejecting, 1) > maxRejecting {
+		atomic.AddInt64(&s.rejecting, -1)
+		s.Metrics.Rejected(reason)
+		conn.Close()
+		return
+	}
+	go func() {
+		defer atomic.AddInt64(&s.rejecting, -1)
+		s.rejectConn(conn, reason)
+	}()
+}
+
+// Tells the client why its connection is rejected, and closes it.
+func (s *Server) rejectConn(conn net.Conn, reason string) {
+	defer conn.Close()
+	s.Metrics.Rejected(reason)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(s.handshakeTimeout()))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+	}
+	s.send(conn, rejection(logx.MsgTypeLimit, "", reason))
+}
+
+func rejection(msgType, id, reason string) logx.ClientMessage {
+	return logx.ClientMessage{
+		Type:    msgType,
+		Status:  logx.ClientMessageStatusFailed,
+		Id:      id,
+		Message: rejectionMessages[reason],
+	}
+}
+
+// Sends the message to the client, giving up after the write timeout so
+// that slow clients don't hold up the server.
+func (s *Server) send(conn net.Conn, msg logx.ClientMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(s.writeTimeout()))
+	return sendToClient(conn, msg)
+}
@@ -0,0 +1,112 @@
+package logxhost
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var r rateLimiter
+	now := time.Now()
+	tests := []struct {
+		Service  string
+		After    time.Duration
+		Expected bool
+	}{
+		// A burst of 2.
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false},
+		// Services have their own bucket.
+		{"b", 0, true},
+		// Refilled at 10 per second.
+		{"a", 50 * time.Millisecond, false},
+		{"a", 100 * time.Millisecond, true},
+		{"a", 100 * time.Millisecond, false},
+		// Up to the burst.
+		{"a", time.Hour, true},
+		{"a", time.Hour, true},
+		{"a", time.Hour, false},
+	}
+	for i, test := range tests {
+		if allowed := r.allow(test.Service, 10, 2, now.Add(test.After)); allowed != test.Expected {
+			t.Errorf("[%d] Expected %v, got %v", i, test.Expected, allowed)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if !r.allow("a", 0, 0, now) {
+			t.Fatal("Expected no limit with a rate of 0")
+		}
+	}
+}
+
+func TestMessageLimiter(t *testing.T) {
+	small := `{"Type":"Log","Message":"c21hbGw="}`
+	large := `{"Type":"Log","Message":"` + strings.Repeat("a", 200) + `"}`
+	tests := []struct {
+		Input    string
+		Max      int64
+		Expected int
+	}{
+		{small + small + small, 64, 3},
+		// Only each message is limited, not the connection.
+		{strings.Repeat(small, 100), 64, 100},
+		{small + large + small, 64, 1},
+		{small + large + small, -1, 3},
+		{large, 64, 0},
+	}
+	for i, test := range tests {
+		dec := newMessageDecoder(strings.NewReader(test.Input), test.Max)
+		var decoded int
+		var err error
+		for {
+			var m logx.HostMessage
+			if err = dec.Decode(&m); err != nil {
+				break
+			}
+			decoded++
+		}
+		if decoded != test.Expected {
+			t.Errorf("[%d] Expected %d messages, got %d", i, test.Expected, decoded)
+		}
+		tooLarge := decoded < strings.Count(test.Input, "{")
+		if tooLarge != (err == ErrMessageTooLarge) {
+			t.Errorf("[%d] Unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestReject(t *testing.T) {
+	s := &Server{}
+	for i, rejecting := range []int64{0, maxRejecting} {
+		atomic.StoreInt64(&s.rejecting, rejecting)
+		server, client := net.Pipe()
+		s.reject(server, RejectedConnections)
+
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		var m logx.ClientMessage
+		err := json.NewDecoder(client).Decode(&m)
+		client.Close()
+		// Closed without a reply once too many are being rejected.
+		if rejecting == maxRejecting {
+			if err != io.EOF {
+				t.Errorf("[%d] Expected the connection to be closed, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if m.Type != logx.MsgTypeLimit || m.Message != rejectionMessages[RejectedConnections] {
+			t.Errorf("[%d] Expected the rejection, got %v", i, m)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package logxhost
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// LivenessState tells whether an instance is still sending heartbeats.
+type LivenessState string
+
+const (
+	LivenessAlive LivenessState = "alive"
+	LivenessStale LivenessState = "stale"
+	LivenessDead  LivenessState = "dead"
+)
+
+const (
+	// Heartbeat interval of instances which didn't send theirs when
+	// registering.
+	DefaultHeartbeatInterval = time.Minute
+
+	DefaultLivenessInterval = 15 * time.Second
+
+	// Rule name of liveness alerts.
+	LivenessAlertRule = "liveness"
+)
+
+// Number of heartbeat intervals an instance can miss before it is stale,
+// and before it is dead.
+var (
+	StaleHeartbeats = 2
+	DeadHeartbeats  = 5
+)
+
+// HeartbeatInterval returns the interval at which the instance sends
+// heartbeats.
+func (i *Instance) HeartbeatInterval() time.Duration {
+	if i.HeartbeatSeconds <= 0 {
+		return DefaultHeartbeatInterval
+	}
+	return time.Duration(i.HeartbeatSeconds) * time.Second
+}
+
+// LivenessAt returns the state of the instance at the provided time,
+// according to when it was last seen.
+func (i *Instance) LivenessAt(now time.Time) LivenessState {
+	silent := now.Sub(i.LastSeen)
+	interval := i.HeartbeatInterval()
+	switch {
+	case silent > time.Duration(DeadHeartbeats)*interval:
+		return LivenessDead
+	case silent > time.Duration(StaleHeartbeats)*interval:
+		return LivenessStale
+	}
+	return LivenessAlive
+}
+
+// LivenessChange records an instance changing state.
+type LivenessChange struct {
+	Id            string    `setmap:"ignore"`
+	Created       time.Time `setmap:"ignore"`
+	InstanceId    string    `db:"instance_id"`
+	State         LivenessState
+	PreviousState LivenessState `db:"previous_state"`
+	LastSeen      time.Time     `db:"last_seen"`
+}
+
+func (c *LivenessChange) Insert(tx *sqlx.Tx) error {
+	return psql.Insert(TableLiveness).
+		SetMap(dbutil.SetMap(c, true)).
+		Suffix("RETURNING id, created").
+		RunWith(tx).
+		QueryRow().
+		Scan(&c.Id, &c.Created)
+}
+
+// Notify is whether the change is worth an alert: the instance either
+// disappeared or came back.
+func (c *LivenessChange) Notify() bool {
+	return c.State == LivenessDead || (c.State == LivenessAlive && c.PreviousState == LivenessDead)
+}
+
+// SetInstanceLiveness changes the state of the instance and records the
+// change. It returns nil if the instance was no longer in its previous
+// state, e.g. because another server already changed it.
+func SetInstanceLiveness(db *sqlx.DB, i *Instance, state LivenessState) (*LivenessChange, error) {
+	change := &LivenessChange{
+		InstanceId:    i.Id,
+		State:         state,
+		PreviousState: i.Liveness,
+		LastSeen:      i.LastSeen,
+	}
+	err := dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(`UPDATE `+TableInstance+` SET liveness=$2 WHERE id=$1 AND liveness=$3`, i.Id, state, i.Liveness)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			change = nil
+			return err
+		}
+		return change.Insert(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if change != nil {
+		i.Liveness = state
+	}
+	return change, nil
+}
+
+// MonitorLiveness checks the liveness of every instance right away and then
+// every LivenessInterval until the die channel is closed. Instances of
+// revoked or disabled services are not checked.
+func (s *Server) MonitorLiveness(die chan bool, eh func(error)) {
+	interval := s.LivenessInterval
+	if interval == 0 {
+		interval = DefaultLivenessInterval
+	}
+	for {
+		s.checkLiveness(time.Now(), eh)
+		select {
+		case <-die:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (s *Server) checkLiveness(now time.Time, eh func(error)) {
+	var sink AlertSink
+	if s.LivenessSinkType != "" {
+		var err error
+		if sink, err = s.newAlertSink(s.LivenessSinkType, s.LivenessSinkTarget); err != nil {
+			eh(fmt.Errorf("liveness alerts: %s", err))
+		}
+	}
+
+	instances, err := SelectInstances(s.DB, nil)
+	if err != nil {
+		eh(err)
+		return
+	}
+	for _, i := range instances {
+		if !i.IsActive() {
+			continue
+		}
+		state := i.LivenessAt(now)
+		if state == i.Liveness {
+			continue
+		}
+		change, err := SetInstanceLiveness(s.DB, i, state)
+		if err != nil {
+			eh(err)
+			continue
+		}
+		if change != nil && state == LivenessDead {
+			if err := EndSession(s.DB, i.Id, i.LastSeen, SessionEndGap); err != nil {
+				eh(err)
+			}
+		}
+		if change == nil || sink == nil || !change.Notify() {
+			continue
+		}
+		a := &Alert{
+			Rule:     LivenessAlertRule,
+			Service:  i.Service,
+			Machine:  i.Machine,
+			Time:     now,
+			Liveness: change,
+		}
+		if err := sink.Notify(a); err != nil {
+			eh(fmt.Errorf("liveness alert for %s on %s: %s", i.Service, i.Machine, err))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package logxhost
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestInstanceLiveness(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		HeartbeatSeconds int
+		Silent           time.Duration
+		Expected         LivenessState
+	}{
+		{10, 5 * time.Second, LivenessAlive},
+		{10, 20 * time.Second, LivenessAlive},
+		{10, 21 * time.Second, LivenessStale},
+		{10, 50 * time.Second, LivenessStale},
+		{10, 51 * time.Second, LivenessDead},
+		{0, 90 * time.Second, LivenessAlive},
+		{0, 3 * time.Minute, LivenessStale},
+		{0, 6 * time.Minute, LivenessDead},
+	}
+	for idx, test := range tests {
+		i := &Instance{HeartbeatSeconds: test.HeartbeatSeconds, LastSeen: now.Add(-test.Silent)}
+		if state := i.LivenessAt(now); state != test.Expected {
+			t.Errorf("[%d] Expected %s, got %s", idx, test.Expected, state)
+		}
+	}
+}
+
+func TestCheckLiveness(t *testing.T) {
+	received := make(chan Alert, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		received <- a
+	}))
+	defer ts.Close()
+
+	s := &Server{
+		DB:                 DefaultTestPostgres(),
+		Password:           "testpassword",
+		SigCache:           make(map[string]*Instance),
+		LivenessSinkType:   AlertSinkWebhook,
+		LivenessSinkTarget: ts.URL,
+	}
+
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "Liveness Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, "Liveness Machine")
+	}()
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	instance, err := s.RegisterService(logx.HostMessage{
+		Type:              logx.MsgTypeRegister,
+		Machine:           "Liveness Machine",
+		Service:           "Liveness Service",
+		HeartbeatInterval: 10 * time.Second,
+	}, ConnDetails{Hash: s.marshalHash(cert.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, instance.Id)
+	if err := instance.UpdateLastSeen(s.DB); err != nil {
+		t.Fatal(err)
+	}
+
+	eh := func(err error) {
+		t.Error(err)
+	}
+	state := func() LivenessState {
+		i, err := GetInstanceByName(s.DB, "Liveness Machine", "Liveness Service")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i.HeartbeatSeconds != 10 {
+			t.Errorf("Expected heartbeat interval to be stored, got %d", i.HeartbeatSeconds)
+		}
+		return i.Liveness
+	}
+
+	steps := []struct {
+		After    time.Duration
+		Expected LivenessState
+		Alert    bool
+	}{
+		{time.Second, LivenessAlive, false},
+		{30 * time.Second, LivenessStale, false},
+		{time.Minute, LivenessDead, true},
+		{2 * time.Minute, LivenessDead, false},
+		{time.Second, LivenessAlive, true},
+	}
+	for idx, step := range steps {
+		s.checkLiveness(time.Now().Add(step.After), eh)
+		if st := state(); st != step.Expected {
+			t.Errorf("[%d] Expected %s, got %s", idx, step.Expected, st)
+		}
+		if !step.Alert {
+			if len(received) != 0 {
+				t.Errorf("[%d] Expected no alert, got %d", idx, len(received))
+			}
+			continue
+		}
+		if len(received) != 1 {
+			t.Errorf("[%d] Expected an alert, got %d", idx, len(received))
+			continue
+		}
+		a := <-received
+		if a.Rule != LivenessAlertRule || a.Liveness == nil || a.Liveness.State != step.Expected || a.Service != "Liveness Service" {
+			t.Errorf("[%d] Expected liveness alert, got %v", idx, a)
+		}
+	}
+
+	var changes int
+	if err := s.DB.Get(&changes, `SELECT COUNT(*) FROM `+TableLiveness+` WHERE instance_id=$1`, instance.Id); err != nil {
+		t.Fatal(err)
+	}
+	if changes != 3 {
+		t.Errorf("Expected 3 recorded changes, got %d", changes)
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
 	dbutil "github.com/monstercat/golib/db"
-	pgUtils "github.com/monstercat/golib/db/postgres"
-	"github.com/monstercat/gologx/utils"
 )
 
 type Log struct {
@@ -36,7 +34,13 @@ func SelectLogs(db sqlx.Queryer, q squirrel.SelectBuilder) ([]*Log, error) {
 }
 
 func GetLog(db sqlx.Queryer, id string) (*Log, error) {
-	var q = psql.Select(ColsLogs...).From(ViewLog).Where("id = '" + id + "'")
+	return GetLogInScope(db, id, nil)
+}
+
+// GetLogInScope is like GetLog, but logs of services outside the scope are
+// not found.
+func GetLogInScope(db sqlx.Queryer, id string, scope *AccessScope) (*Log, error) {
+	var q = scopeWhere(SelectLogQry.Where(squirrel.Eq{"id": id}), scope)
 
 	var l Log
 	if err := dbutil.Get(db, &l, q); err != nil {
@@ -46,31 +50,116 @@ func GetLog(db sqlx.Queryer, id string) (*Log, error) {
 	return &l, nil
 }
 
-func GetLogSearchQuery(service string, matcher string, limit int, datebefore string, dateafter string, orderby string) squirrel.SelectBuilder {
-	q := SelectLogQry.Where("service ILIKE '" + service + "'")
+// SearchLogs returns the logs matching the query.
+func SearchLogs(db sqlx.Queryer, q *LogQuery) ([]*Log, error) {
+	sel, err := q.Select()
+	if err != nil {
+		return nil, err
+	}
+	logs, err := SelectLogs(db, sel)
+	if err != nil {
+		return nil, err
+	}
+	if q.isBackwards() {
+		reverseLogs(logs)
+	}
+	return logs, nil
+}
 
-	if matcher != "" {
-		q = q.Where(squirrel.Or{
-			squirrel.ILike{"machine": "'" + matcher + "'"},
-			squirrel.ILike{"message": "'" + matcher + "'"},
-		})
+func reverseLogs(logs []*Log) {
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
 	}
+}
 
-	if datebefore != "" {
-		q = q.Where("log_time < '" + datebefore + "'")
+// LogPage is a page of search results. Next and Prev are the cursors of the
+// following and preceding pages, to be used as PageAfter and PageBefore.
+// They are nil if there are no more logs in that direction, or if the
+// query has a custom order.
+type LogPage struct {
+	Logs []*Log
+	Next *Cursor
+	Prev *Cursor
+}
+
+// SearchLogPage returns a page of the logs matching the query.
+func SearchLogPage(db sqlx.Queryer, q *LogQuery) (*LogPage, error) {
+	sel, err := q.Select()
+	if err != nil {
+		return nil, err
 	}
 
-	if dateafter != "" {
-		q = q.Where("log_time >= '" + dateafter + "'")
+	// One more log is fetched to know if there is a following page.
+	limit := q.limit()
+	logs, err := SelectLogs(db, sel.Limit(limit+1))
+	if err != nil {
+		return nil, err
+	}
+	more := uint64(len(logs)) > limit
+	if more {
+		logs = logs[:limit]
 	}
 
-	if orderby != "" {
-		orderByArr := utils.ParseFlagStrToArray(orderby, ",")
+	page := &LogPage{Logs: logs}
+	if len(q.OrderBy) > 0 || len(logs) == 0 {
+		return page, nil
+	}
 
-		pgUtils.ApplySortStrings(orderByArr, &q, SortConfig)
+	if q.isBackwards() {
+		reverseLogs(logs)
+		page.Next = LogCursor(logs[len(logs)-1])
+		if more {
+			page.Prev = LogCursor(logs[0])
+		}
+	} else {
+		if more {
+			page.Next = LogCursor(logs[len(logs)-1])
+		}
+		if q.isPaged() {
+			page.Prev = LogCursor(logs[0])
+		}
 	}
+	return page, nil
+}
 
-	q = q.Limit(uint64(limit))
+// StreamLogs calls fn with every page of logs matching the query, newest
+// first, until there are no logs left or fn returns an error. Only one page
+// is held in memory at a time; the limit of the query is the page size.
+// Streaming starts after PageAfter if it is set, and stops at PageBefore.
+// If only PageBefore is set, the logs newer than it are streamed instead,
+// starting with the page closest to it.
+func StreamLogs(db sqlx.Queryer, q *LogQuery, fn func([]*Log) error) error {
+	return streamLogPages(q, func(q *LogQuery) (*LogPage, error) {
+		return SearchLogPage(db, q)
+	}, fn)
+}
 
-	return q
+func streamLogPages(q *LogQuery, search func(*LogQuery) (*LogPage, error), fn func([]*Log) error) error {
+	if len(q.OrderBy) > 0 {
+		return ErrCursorWithOrder
+	}
+	pageQ := *q
+	backwards := pageQ.isBackwards()
+	for {
+		page, err := search(&pageQ)
+		if err != nil {
+			return err
+		}
+		if len(page.Logs) > 0 {
+			if err := fn(page.Logs); err != nil {
+				return err
+			}
+		}
+		if backwards {
+			if page.Prev == nil {
+				return nil
+			}
+			pageQ.PageBefore = page.Prev
+			continue
+		}
+		if page.Next == nil {
+			return nil
+		}
+		pageQ.PageAfter = page.Next
+	}
 }
@@ -0,0 +1,26 @@
+package logxhost
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// Machine is a host on which service instances run.
+type Machine struct {
+	Id   string `setmap:"ignore"`
+	Name string
+}
+
+// EnsureMachine returns the machine with the provided name, creating
+// it if it does not exist yet.
+func EnsureMachine(db sqlx.Queryer, name string) (*Machine, error) {
+	var m Machine
+	err := db.QueryRowx(`
+INSERT INTO machine(name) VALUES($1)
+ON CONFLICT (name) DO UPDATE SET name=EXCLUDED.name
+RETURNING id, name
+`, name).StructScan(&m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
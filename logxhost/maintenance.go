@@ -0,0 +1,53 @@
+package logxhost
+
+import (
+	"time"
+)
+
+const (
+	DefaultMaintenanceInterval = time.Hour
+	DefaultPartitionsAhead     = 7
+
+	// Number of logs removed per statement when applying retention rules.
+	RetentionBatchSize = 10000
+)
+
+// MaintainLogs creates log partitions ahead of time and, if enabled, applies
+// the retention rules. It runs right away and then every MaintenanceInterval
+// until the die channel is closed.
+func (s *Server) MaintainLogs(die chan bool, eh func(error)) {
+	interval := s.MaintenanceInterval
+	if interval == 0 {
+		interval = DefaultMaintenanceInterval
+	}
+	for {
+		s.maintainLogs(eh)
+		select {
+		case <-die:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (s *Server) maintainLogs(eh func(error)) {
+	ahead := s.PartitionsAhead
+	if ahead == 0 {
+		ahead = DefaultPartitionsAhead
+	}
+	if _, err := EnsureLogPartitions(s.DB, time.Now(), ahead); err != nil {
+		eh(err)
+	}
+
+	if !s.RetentionEnabled {
+		return
+	}
+	plan, err := PlanRetention(s.DB, time.Now())
+	if err != nil {
+		eh(err)
+		return
+	}
+	if _, err := ApplyRetention(s.DB, plan, RetentionBatchSize); err != nil {
+		eh(err)
+	}
+}
@@ -0,0 +1,266 @@
+package logxhost
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds, in seconds, of the buckets of the insert latency histogram.
+var InsertDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Number of message types counted separately in the metrics. The types are
+// chosen by the clients, so the others are counted as MessageTypeOther to
+// keep the number of series bounded.
+var MaxMessageTypes = 50
+
+const MessageTypeOther = "other"
+
+// Statuses of registrations in the metrics.
+const (
+	RegistrationSuccessful = "successful"
+	RegistrationFailed     = "failed"
+)
+
+// ServerMetrics counts what goes through the log server. The zero value is
+// ready to use.
+type ServerMetrics struct {
+	mu sync.Mutex
+
+	registrations  map[string]uint64
+	messages       map[messageKey]uint64
+	messageTypes   map[string]bool
+	insertFailures uint64
+	duplicates     uint64
+	pauses         uint64
+	rejections     map[string]uint64
+	decodeErrors   uint64
+	sigCacheHits   uint64
+	sigCacheMisses uint64
+
+	// Cumulative counts of the inserts within each bucket of
+	// InsertDurationBuckets.
+	insertBuckets  []uint64
+	insertCount    uint64
+	insertDuration float64
+}
+
+type messageKey struct {
+	Type    string
+	Service string
+}
+
+func (m *ServerMetrics) Registration(status string) {
+	m.mu.Lock()
+	if m.registrations == nil {
+		m.registrations = make(map[string]uint64)
+	}
+	m.registrations[status]++
+	m.mu.Unlock()
+}
+
+func (m *ServerMetrics) MessageReceived(msgType, service string) {
+	m.mu.Lock()
+	if m.messages == nil {
+		m.messages = make(map[messageKey]uint64)
+		m.messageTypes = make(map[string]bool)
+	}
+	if !m.messageTypes[msgType] {
+		if len(m.messageTypes) < MaxMessageTypes {
+			m.messageTypes[msgType] = true
+		} else {
+			msgType = MessageTypeOther
+		}
+	}
+	m.messages[messageKey{msgType, service}]++
+	m.mu.Unlock()
+}
+
+// Insert records how long inserting a log took, and whether it failed.
+func (m *ServerMetrics) Insert(d time.Duration, err error) {
+	secs := d.Seconds()
+	m.mu.Lock()
+	if m.insertBuckets == nil {
+		m.insertBuckets = make([]uint64, len(InsertDurationBuckets))
+	}
+	for i, le := range InsertDurationBuckets {
+		if secs <= le {
+			m.insertBuckets[i]++
+		}
+	}
+	m.insertCount++
+	m.insertDuration += secs
+	if err != nil {
+		m.insertFailures++
+	}
+	m.mu.Unlock()
+}
+
+// Stored records the result of storing a log, which took d.
+func (m *ServerMetrics) Stored(d time.Duration, err error) {
+	if err == ErrDuplicateLog {
+		m.Duplicate()
+		err = nil
+	}
+	m.Insert(d, err)
+}
+
+// Duplicate records a log which was already stored.
+func (m *ServerMetrics) Duplicate() {
+	m.mu.Lock()
+	m.duplicates++
+	m.mu.Unlock()
+}
+
+// Pause records that the clients were told to pause.
+func (m *ServerMetrics) Pause() {
+	m.mu.Lock()
+	m.pauses++
+	m.mu.Unlock()
+}
+
+// Rejected records a connection or log rejected for being over the limits
+// of the server, with one of the Rejected reasons.
+func (m *ServerMetrics) Rejected(reason string) {
+	m.mu.Lock()
+	if m.rejections == nil {
+		m.rejections = make(map[string]uint64)
+	}
+	m.rejections[reason]++
+	m.mu.Unlock()
+}
+
+func (m *ServerMetrics) DecodeError() {
+	m.mu.Lock()
+	m.decodeErrors++
+	m.mu.Unlock()
+}
+
+func (m *ServerMetrics) SigCacheLookup(hit bool) {
+	m.mu.Lock()
+	if hit {
+		m.sigCacheHits++
+	} else {
+		m.sigCacheMisses++
+	}
+	m.mu.Unlock()
+}
+
+// MetricsHandler serves the metrics of the server in the Prometheus text
+// format.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the metrics of the server in the Prometheus text
+// format.
+func (s *Server) WriteMetrics(w io.Writer) {
+	s.connsMu.Lock()
+	conns := len(s.conns)
+	s.connsMu.Unlock()
+	writeMetric(w, "logx_connections_open", "gauge", "Open client connections.", metricSample{Value: float64(conns)})
+
+	s.SigCacheMutex.RLock()
+	cached := len(s.SigCache)
+	s.SigCacheMutex.RUnlock()
+	writeMetric(w, "logx_sig_cache_size", "gauge", "Certificates in the signature cache.", metricSample{Value: float64(cached)})
+
+	s.Metrics.write(w)
+
+	if s.DB != nil {
+		st := s.DB.Stats()
+		writeMetric(w, "logx_db_open_connections", "gauge", "Open database connections.", metricSample{Value: float64(st.OpenConnections)})
+		writeMetric(w, "logx_db_in_use_connections", "gauge", "Database connections in use.", metricSample{Value: float64(st.InUse)})
+		writeMetric(w, "logx_db_idle_connections", "gauge", "Idle database connections.", metricSample{Value: float64(st.Idle)})
+		writeMetric(w, "logx_db_wait_count_total", "counter", "Times a database connection was waited for.", metricSample{Value: float64(st.WaitCount)})
+		writeMetric(w, "logx_db_wait_duration_seconds_total", "counter", "Time spent waiting for database connections.", metricSample{Value: st.WaitDuration.Seconds()})
+	}
+}
+
+func (m *ServerMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var regs []metricSample
+	for _, status := range []string{RegistrationSuccessful, RegistrationFailed} {
+		regs = append(regs, metricSample{Labels: []string{"status", status}, Value: float64(m.registrations[status])})
+	}
+	writeMetric(w, "logx_registrations_total", "counter", "Registrations of services.", regs...)
+
+	var msgs []metricSample
+	for k, v := range m.messages {
+		msgs = append(msgs, metricSample{Labels: []string{"type", k.Type, "service", k.Service}, Value: float64(v)})
+	}
+	sort.Slice(msgs, func(a, b int) bool {
+		return strings.Join(msgs[a].Labels, "\x00") < strings.Join(msgs[b].Labels, "\x00")
+	})
+	writeMetric(w, "logx_messages_received_total", "counter", "Messages received from clients by type and service.", msgs...)
+
+	writeMetric(w, "logx_insert_failures_total", "counter", "Logs which could not be stored.", metricSample{Value: float64(m.insertFailures)})
+	writeMetric(w, "logx_duplicate_logs_total", "counter", "Logs which were already stored, sent again by clients.", metricSample{Value: float64(m.duplicates)})
+	writeMetric(w, "logx_pauses_total", "counter", "Times the clients were told to pause as storing logs was slow.", metricSample{Value: float64(m.pauses)})
+	var rejected []metricSample
+	for _, reason := range []string{RejectedConnections, RejectedServiceConnections, RejectedRateLimit, RejectedMessageSize} {
+		rejected = append(rejected, metricSample{Labels: []string{"reason", reason}, Value: float64(m.rejections[reason])})
+	}
+	writeMetric(w, "logx_rejected_total", "counter", "Connections and logs rejected for being over the limits.", rejected...)
+	writeMetric(w, "logx_decode_errors_total", "counter", "Messages which could not be decoded.", metricSample{Value: float64(m.decodeErrors)})
+	writeMetric(w, "logx_sig_cache_hits_total", "counter", "Signature verifications answered by the cache.", metricSample{Value: float64(m.sigCacheHits)})
+	writeMetric(w, "logx_sig_cache_misses_total", "counter", "Signature verifications which queried the database.", metricSample{Value: float64(m.sigCacheMisses)})
+
+	var hist []metricSample
+	for i, le := range InsertDurationBuckets {
+		var v uint64
+		if m.insertBuckets != nil {
+			v = m.insertBuckets[i]
+		}
+		hist = append(hist, metricSample{Suffix: "_bucket", Labels: []string{"le", formatMetricValue(le)}, Value: float64(v)})
+	}
+	hist = append(hist,
+		metricSample{Suffix: "_bucket", Labels: []string{"le", "+Inf"}, Value: float64(m.insertCount)},
+		metricSample{Suffix: "_sum", Value: m.insertDuration},
+		metricSample{Suffix: "_count", Value: float64(m.insertCount)},
+	)
+	writeMetric(w, "logx_insert_duration_seconds", "histogram", "Time taken to store a log.", hist...)
+}
+
+type metricSample struct {
+	// Appended to the name, e.g. for the buckets of a histogram.
+	Suffix string
+
+	// Pairs of label names and values.
+	Labels []string
+	Value  float64
+}
+
+func writeMetric(w io.Writer, name, kind, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		fmt.Fprint(w, name+s.Suffix)
+		if len(s.Labels) > 0 {
+			var pairs []string
+			for i := 0; i+1 < len(s.Labels); i += 2 {
+				pairs = append(pairs, s.Labels[i]+`="`+escapeLabelValue(s.Labels[i+1])+`"`)
+			}
+			fmt.Fprint(w, "{"+strings.Join(pairs, ",")+"}")
+		}
+		fmt.Fprintf(w, " %s\n", formatMetricValue(s.Value))
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
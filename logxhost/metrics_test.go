@@ -0,0 +1,79 @@
+package logxhost
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	s := &Server{}
+	s.Metrics.Registration(RegistrationSuccessful)
+	s.Metrics.Registration(RegistrationSuccessful)
+	s.Metrics.Registration(RegistrationFailed)
+	s.Metrics.MessageReceived("Log", "api")
+	s.Metrics.MessageReceived("Log", "api")
+	s.Metrics.MessageReceived("Heartbeat", `quoted "api"`)
+	s.Metrics.Insert(2*time.Millisecond, nil)
+	s.Metrics.Insert(2*time.Second, errors.New("failed"))
+	s.Metrics.Duplicate()
+	s.Metrics.Pause()
+	s.Metrics.Rejected(RejectedRateLimit)
+	s.Metrics.Rejected(RejectedRateLimit)
+	s.Metrics.DecodeError()
+	s.Metrics.SigCacheLookup(true)
+	s.Metrics.SigCacheLookup(false)
+
+	var buf bytes.Buffer
+	s.WriteMetrics(&buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE logx_connections_open gauge\nlogx_connections_open 0\n",
+		`logx_registrations_total{status="successful"} 2`,
+		`logx_registrations_total{status="failed"} 1`,
+		`logx_messages_received_total{type="Heartbeat",service="quoted \"api\""} 1`,
+		`logx_messages_received_total{type="Log",service="api"} 2`,
+		"logx_insert_failures_total 1\n",
+		"logx_duplicate_logs_total 1\n",
+		"logx_pauses_total 1\n",
+		`logx_rejected_total{reason="connections"} 0`,
+		`logx_rejected_total{reason="rate_limit"} 2`,
+		"logx_decode_errors_total 1\n",
+		"logx_sig_cache_hits_total 1\n",
+		"logx_sig_cache_misses_total 1\n",
+		`logx_insert_duration_seconds_bucket{le="0.001"} 0`,
+		`logx_insert_duration_seconds_bucket{le="0.0025"} 1`,
+		`logx_insert_duration_seconds_bucket{le="2.5"} 2`,
+		`logx_insert_duration_seconds_bucket{le="+Inf"} 2`,
+		"logx_insert_duration_seconds_sum 2.002\n",
+		"logx_insert_duration_seconds_count 2\n",
+	}
+	for idx, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("[%d] Expected metrics to contain %q. Got:\n%s", idx, e, out)
+		}
+	}
+}
+
+func TestMessageTypeMetrics(t *testing.T) {
+	var m ServerMetrics
+	for i := 0; i < MaxMessageTypes+10; i++ {
+		m.MessageReceived(fmt.Sprintf("Type%d", i), "api")
+	}
+	// Types seen before the limit are still counted separately.
+	m.MessageReceived("Type0", "api")
+
+	if len(m.messageTypes) != MaxMessageTypes {
+		t.Errorf("Expected %d types, got %d", MaxMessageTypes, len(m.messageTypes))
+	}
+	if n := m.messages[messageKey{"Type0", "api"}]; n != 2 {
+		t.Errorf("Expected 2 messages of the first type, got %d", n)
+	}
+	if n := m.messages[messageKey{MessageTypeOther, "api"}]; n != 10 {
+		t.Errorf("Expected 10 messages of other types, got %d", n)
+	}
+}
@@ -0,0 +1,258 @@
+package logxhost
+
+import (
+	"embed"
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	dbutil "github.com/monstercat/golib/db"
+	errs "github.com/pkg/errors"
+)
+
+// Migrations are stored as pairs of files named {version}_{name}.up.sql
+// and {version}_{name}.down.sql. Versions must be sequential.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key for the advisory lock which stops two servers from
+// migrating at the same time.
+const migrationLockKey = 7236501
+
+var (
+	ErrInvalidMigration = errors.New("invalid migration")
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied *time.Time
+}
+
+// Migrations returns all embedded migrations ordered by version.
+func Migrations() ([]*Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		parts := strings.SplitN(name, "_", 2)
+		if len(parts) != 2 {
+			return nil, errs.Wrap(ErrInvalidMigration, name)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, errs.Wrap(ErrInvalidMigration, name)
+		}
+		byt, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(parts[1], ".up.sql"):
+			m.Name = strings.TrimSuffix(parts[1], ".up.sql")
+			m.Up = string(byt)
+		case strings.HasSuffix(parts[1], ".down.sql"):
+			m.Down = string(byt)
+		default:
+			return nil, errs.Wrap(ErrInvalidMigration, name)
+		}
+	}
+
+	xs := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		xs = append(xs, m)
+	}
+	sort.Slice(xs, func(i, j int) bool {
+		return xs[i].Version < xs[j].Version
+	})
+	for idx, m := range xs {
+		if m.Version != idx+1 || m.Up == "" || m.Down == "" {
+			return nil, errs.Wrapf(ErrInvalidMigration, "version %d", m.Version)
+		}
+	}
+	return xs, nil
+}
+
+// Creates the migration table if needed. Databases which were created by
+// hand from the original create.sql are detected by the presence of the
+// service table, and have the initial migration marked as applied.
+func ensureMigrationTable(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE IF NOT EXISTS ` + TableSchemaMigration + `
+(
+    version INT PRIMARY KEY,
+    name    TEXT        NOT NULL,
+    applied TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+INSERT INTO ` + TableSchemaMigration + `(version, name)
+SELECT 1, 'initial'
+WHERE to_regclass('service') IS NOT NULL
+  AND NOT EXISTS(SELECT 1 FROM ` + TableSchemaMigration + `)`)
+	return err
+}
+
+func lockMigrations(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockKey)
+	return err
+}
+
+func appliedMigrations(db sqlx.Queryer) (map[int]time.Time, error) {
+	rows, err := db.Queryx(`SELECT version, applied FROM ` + TableSchemaMigration)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var t time.Time
+		if err := rows.Scan(&version, &t); err != nil {
+			return nil, err
+		}
+		applied[version] = t
+	}
+	return applied, rows.Err()
+}
+
+// GetMigrationStatus returns every known migration along with the time
+// it was applied, if it was.
+func GetMigrationStatus(db *sqlx.DB) ([]*MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied map[int]time.Time
+	err = dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+		if err := ensureMigrationTable(tx); err != nil {
+			return err
+		}
+		applied, err = appliedMigrations(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	xs := make([]*MigrationStatus, len(migrations))
+	for idx, m := range migrations {
+		xs[idx] = &MigrationStatus{Migration: *m}
+		if t, ok := applied[m.Version]; ok {
+			xs[idx].Applied = &t
+		}
+	}
+	return xs, nil
+}
+
+// MigrateUp applies up to steps pending migrations, or all of them if
+// steps is zero. Each migration is applied in its own transaction.
+func MigrateUp(db *sqlx.DB, steps int) ([]*Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for _, m := range migrations {
+		if steps > 0 && len(done) >= steps {
+			break
+		}
+		var ran bool
+		err := dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+			if err := lockMigrations(tx); err != nil {
+				return err
+			}
+			if err := ensureMigrationTable(tx); err != nil {
+				return err
+			}
+			applied, err := appliedMigrations(tx)
+			if err != nil {
+				return err
+			}
+			if _, ok := applied[m.Version]; ok {
+				return nil
+			}
+			if _, err := tx.Exec(m.Up); err != nil {
+				return err
+			}
+			ran = true
+			_, err = tx.Exec(`INSERT INTO `+TableSchemaMigration+`(version, name) VALUES($1, $2)`, m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return done, errs.Wrapf(err, "migration %d (%s)", m.Version, m.Name)
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last steps applied migrations.
+func MigrateDown(db *sqlx.DB, steps int) ([]*Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for idx := len(migrations) - 1; idx >= 0 && len(done) < steps; idx-- {
+		m := migrations[idx]
+		var ran bool
+		err := dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+			if err := lockMigrations(tx); err != nil {
+				return err
+			}
+			if err := ensureMigrationTable(tx); err != nil {
+				return err
+			}
+			applied, err := appliedMigrations(tx)
+			if err != nil {
+				return err
+			}
+			if _, ok := applied[m.Version]; !ok {
+				return nil
+			}
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+			ran = true
+			_, err = tx.Exec(`DELETE FROM `+TableSchemaMigration+` WHERE version=$1`, m.Version)
+			return err
+		})
+		if err != nil {
+			return done, errs.Wrapf(err, "migration %d (%s)", m.Version, m.Name)
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
@@ -0,0 +1,36 @@
+package logxhost
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+
+	for idx, m := range migrations {
+		if m.Version != idx+1 {
+			t.Errorf("Expected version %d, got %d", idx+1, m.Version)
+		}
+		if m.Name == "" {
+			t.Errorf("[%d] Expected migration to have a name", m.Version)
+		}
+	}
+
+	// log_view is required by the log queries and must be defined.
+	var found bool
+	for _, m := range migrations {
+		if strings.Contains(m.Up, "CREATE VIEW log_view") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected a migration to create log_view")
+	}
+}
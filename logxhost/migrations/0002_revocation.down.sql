@@ -0,0 +1,5 @@
+DROP TABLE revocation_audit;
+DROP TABLE revoked_hash;
+
+ALTER TABLE service
+    DROP COLUMN status;
@@ -0,0 +1,21 @@
+ALTER TABLE service
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'Active';
+
+-- Certificate hashes which may never be used to register or log again.
+CREATE TABLE revoked_hash
+(
+    sig_hash TEXT PRIMARY KEY,
+    created  TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Record of who revoked, disabled or enabled which service or hash.
+CREATE TABLE revocation_audit
+(
+    id         UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created    TIMESTAMPTZ DEFAULT NOW(),
+    actor      TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    service_id UUID REFERENCES service (id),
+    sig_hash   TEXT NOT NULL DEFAULT '',
+    reason     TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,35 @@
+-- Reverting is only possible while every service runs on a single machine,
+-- as the previous model required service names and machines to be unique.
+
+DROP VIEW instance_view;
+
+ALTER TABLE service
+    ADD COLUMN machine   TEXT,
+    ADD COLUMN last_seen TIMESTAMPTZ,
+    ADD COLUMN sig_hash  TEXT NOT NULL DEFAULT '';
+
+UPDATE service s
+SET machine   = m.name,
+    last_seen = i.last_seen,
+    sig_hash  = i.sig_hash
+FROM instance i
+         JOIN machine m ON m.id = i.machine_id
+WHERE i.service_id = s.id;
+
+ALTER TABLE log
+    DROP CONSTRAINT log_instance_id_fkey;
+UPDATE log l
+SET instance_id = i.service_id
+FROM instance i
+WHERE i.id = l.instance_id;
+ALTER TABLE log
+    RENAME COLUMN instance_id TO service_id;
+ALTER TABLE log
+    ADD CONSTRAINT log_service_id_fkey FOREIGN KEY (service_id) REFERENCES service (id);
+
+ALTER TABLE service
+    ALTER COLUMN machine SET NOT NULL,
+    ADD CONSTRAINT service_machine_key UNIQUE (machine);
+
+DROP TABLE instance;
+DROP TABLE machine;
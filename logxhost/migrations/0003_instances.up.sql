@@ -0,0 +1,57 @@
+-- Splits the service table, which held a service on a single machine, into
+-- services, machines and instances (a service on a machine).
+--
+-- Every existing service row becomes an instance with the same id, so that
+-- logs keep pointing at the right place.
+
+CREATE TABLE machine
+(
+    id   UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name TEXT NOT NULL UNIQUE
+);
+
+INSERT INTO machine(name)
+SELECT DISTINCT machine
+FROM service;
+
+CREATE TABLE instance
+(
+    id         UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    service_id UUID NOT NULL REFERENCES service (id),
+    machine_id UUID NOT NULL REFERENCES machine (id),
+    last_seen  TIMESTAMPTZ,
+    sig_hash   TEXT NOT NULL    DEFAULT '',
+    UNIQUE (service_id, machine_id)
+);
+
+CREATE INDEX instance_sig_hash_idx ON instance (sig_hash);
+
+INSERT INTO instance(id, service_id, machine_id, last_seen, sig_hash)
+SELECT s.id, s.id, m.id, s.last_seen, s.sig_hash
+FROM service s
+         JOIN machine m ON m.name = s.machine;
+
+ALTER TABLE service
+    DROP COLUMN machine,
+    DROP COLUMN last_seen,
+    DROP COLUMN sig_hash;
+
+ALTER TABLE log
+    DROP CONSTRAINT log_service_id_fkey;
+ALTER TABLE log
+    RENAME COLUMN service_id TO instance_id;
+ALTER TABLE log
+    ADD CONSTRAINT log_instance_id_fkey FOREIGN KEY (instance_id) REFERENCES instance (id);
+
+CREATE VIEW instance_view AS
+SELECT i.id,
+       i.service_id,
+       i.machine_id,
+       i.last_seen,
+       i.sig_hash,
+       s.name   AS service,
+       m.name   AS machine,
+       s.status AS status
+FROM instance i
+         JOIN service s ON s.id = i.service_id
+         JOIN machine m ON m.id = i.machine_id;
@@ -0,0 +1 @@
+DROP VIEW log_view;
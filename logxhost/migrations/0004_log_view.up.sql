@@ -0,0 +1,12 @@
+-- Logs along with the names of the service and machine which sent them.
+CREATE VIEW log_view AS
+SELECT l.id,
+       i.machine,
+       i.service,
+       l.context::TEXT AS context,
+       l.message,
+       l.log_type,
+       l.log_time,
+       l.created
+FROM log l
+         JOIN instance_view i ON i.id = l.instance_id;
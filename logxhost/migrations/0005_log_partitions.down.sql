@@ -0,0 +1,37 @@
+DROP TABLE retention_rule;
+
+DROP VIEW log_view;
+
+ALTER TABLE log
+    RENAME TO log_partitioned;
+ALTER TABLE log_partitioned
+    RENAME CONSTRAINT log_pkey TO log_partitioned_pkey;
+
+CREATE TABLE log
+(
+    id          UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    instance_id UUID REFERENCES instance (id),
+    created     TIMESTAMPTZ      DEFAULT NOW(),
+    log_type    TEXT        NOT NULL,
+    log_time    TIMESTAMPTZ NOT NULL,
+    message     TEXT        NOT NULL,
+    context     JSONB       NOT NULL
+);
+
+INSERT INTO log(id, instance_id, created, log_type, log_time, message, context)
+SELECT id, instance_id, created, log_type, log_time, message, context
+FROM log_partitioned;
+
+DROP TABLE log_partitioned;
+
+CREATE VIEW log_view AS
+SELECT l.id,
+       i.machine,
+       i.service,
+       l.context::TEXT AS context,
+       l.message,
+       l.log_type,
+       l.log_time,
+       l.created
+FROM log l
+         JOIN instance_view i ON i.id = l.instance_id;
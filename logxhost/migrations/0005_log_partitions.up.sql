@@ -0,0 +1,86 @@
+-- Recreates the log table partitioned by day on log_time. The existing table
+-- becomes the partition for everything before tomorrow. Rows after that (from
+-- clients with bad clocks) are moved to the new daily partitions, or to the
+-- default partition if they are too far in the future.
+--
+-- Partitions are named log_pYYYYMMDD and cover one UTC day.
+
+SET LOCAL TIME ZONE 'UTC';
+
+DROP VIEW log_view;
+
+ALTER TABLE log
+    RENAME TO log_legacy;
+ALTER TABLE log_legacy
+    RENAME CONSTRAINT log_pkey TO log_legacy_pkey;
+ALTER TABLE log_legacy
+    RENAME CONSTRAINT log_instance_id_fkey TO log_legacy_instance_id_fkey;
+
+CREATE TABLE log
+(
+    id          UUID        NOT NULL DEFAULT uuid_generate_v4(),
+    instance_id UUID REFERENCES instance (id),
+    created     TIMESTAMPTZ          DEFAULT NOW(),
+    log_type    TEXT        NOT NULL,
+    log_time    TIMESTAMPTZ NOT NULL,
+    message     TEXT        NOT NULL,
+    context     JSONB       NOT NULL,
+    PRIMARY KEY (id, log_time)
+) PARTITION BY RANGE (log_time);
+
+CREATE TABLE log_default PARTITION OF log DEFAULT;
+
+DO
+$$
+    DECLARE
+        cutoff TIMESTAMPTZ := date_trunc('day', NOW()) + INTERVAL '1 day';
+        day    TIMESTAMPTZ;
+    BEGIN
+        CREATE TEMPORARY TABLE log_future ON COMMIT DROP AS
+        SELECT id, instance_id, created, log_type, log_time, message, context
+        FROM log_legacy
+        WHERE log_time >= cutoff;
+        DELETE FROM log_legacy WHERE log_time >= cutoff;
+
+        EXECUTE format('ALTER TABLE log ATTACH PARTITION log_legacy FOR VALUES FROM (MINVALUE) TO (%L)', cutoff);
+
+        -- The server keeps creating partitions ahead of time. These are only
+        -- here so logs don't land in the default partition in the meantime.
+        FOR i IN 0..6
+            LOOP
+                day := cutoff + i * INTERVAL '1 day';
+                EXECUTE format('CREATE TABLE %I PARTITION OF log FOR VALUES FROM (%L) TO (%L)',
+                               'log_p' || to_char(day, 'YYYYMMDD'), day, day + INTERVAL '1 day');
+            END LOOP;
+
+        INSERT INTO log(id, instance_id, created, log_type, log_time, message, context)
+        SELECT id, instance_id, created, log_type, log_time, message, context
+        FROM log_future;
+    END
+$$;
+
+CREATE VIEW log_view AS
+SELECT l.id,
+       i.machine,
+       i.service,
+       l.context::TEXT AS context,
+       l.message,
+       l.log_type,
+       l.log_time,
+       l.created
+FROM log l
+         JOIN instance_view i ON i.id = l.instance_id;
+
+-- How long to keep logs. A rule may apply to a single service, a single
+-- severity (the Severity field of the log context), both, or neither. The
+-- most specific rule matching a log applies; logs without a rule are kept.
+CREATE TABLE retention_rule
+(
+    id         UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created    TIMESTAMPTZ      DEFAULT NOW(),
+    service_id UUID REFERENCES service (id),
+    severity   TEXT,
+    keep_days  INT  NOT NULL CHECK (keep_days > 0)
+);
+
+CREATE UNIQUE INDEX retention_rule_scope_idx ON retention_rule (COALESCE(service_id::TEXT, ''), COALESCE(severity, ''));
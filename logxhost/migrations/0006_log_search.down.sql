@@ -0,0 +1,23 @@
+DROP VIEW log_view;
+CREATE VIEW log_view AS
+SELECT l.id,
+       i.machine,
+       i.service,
+       l.context::TEXT AS context,
+       l.message,
+       l.log_type,
+       l.log_time,
+       l.created
+FROM log l
+         JOIN instance_view i ON i.id = l.instance_id;
+
+DROP INDEX service_name_trgm_idx;
+DROP INDEX machine_name_trgm_idx;
+
+DROP INDEX log_instance_time_idx;
+DROP INDEX log_context_idx;
+DROP INDEX log_message_trgm_idx;
+DROP INDEX log_message_tsv_idx;
+
+ALTER TABLE log
+    DROP COLUMN message_tsv;
@@ -0,0 +1,36 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Full text search on the message. The simple configuration is used as logs
+-- are rarely prose, and stemming would make exact searches miss.
+ALTER TABLE log
+    ADD COLUMN message_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', message)) STORED;
+
+-- Indexes on a partitioned table are created on every partition, including
+-- the ones created later.
+CREATE INDEX log_message_tsv_idx ON log USING GIN (message_tsv);
+CREATE INDEX log_message_trgm_idx ON log USING GIN (message gin_trgm_ops);
+CREATE INDEX log_context_idx ON log USING GIN (context jsonb_path_ops);
+CREATE INDEX log_instance_time_idx ON log (instance_id, log_time);
+
+CREATE INDEX machine_name_trgm_idx ON machine USING GIN (name gin_trgm_ops);
+CREATE INDEX service_name_trgm_idx ON service USING GIN (name gin_trgm_ops);
+
+-- The view exposes the raw columns as well so that queries on it can use
+-- the indexes above.
+DROP VIEW log_view;
+CREATE VIEW log_view AS
+SELECT l.id,
+       i.machine,
+       i.service,
+       l.context::TEXT AS context,
+       l.message,
+       l.log_type,
+       l.log_time,
+       l.created,
+       l.instance_id,
+       i.service_id,
+       i.machine_id,
+       l.context       AS context_data,
+       l.message_tsv
+FROM log l
+         JOIN instance_view i ON i.id = l.instance_id;
@@ -0,0 +1 @@
+DROP INDEX log_time_id_idx;
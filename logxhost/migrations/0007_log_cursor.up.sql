@@ -0,0 +1,2 @@
+-- Keyset pagination orders and compares on (log_time, id).
+CREATE INDEX log_time_id_idx ON log (log_time, id);
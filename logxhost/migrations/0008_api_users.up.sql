@@ -0,0 +1,10 @@
+-- Users of the read API. They authenticate with a token, a client
+-- certificate, or both. Only hashes are stored.
+CREATE TABLE api_user
+(
+    id         UUID PRIMARY KEY     DEFAULT uuid_generate_v4(),
+    created    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name       TEXT        NOT NULL UNIQUE,
+    token_hash TEXT UNIQUE,
+    cert_hash  TEXT UNIQUE
+);
@@ -0,0 +1,3 @@
+DROP TABLE read_audit;
+DROP TABLE api_user_role;
+DROP TABLE role;
@@ -0,0 +1,29 @@
+-- Roles grant read access to the logs of the services matching one of their
+-- patterns (* matches anything).
+CREATE TABLE role
+(
+    id       UUID PRIMARY KEY     DEFAULT uuid_generate_v4(),
+    created  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name     TEXT        NOT NULL UNIQUE,
+    services TEXT[]      NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE api_user_role
+(
+    user_id UUID NOT NULL REFERENCES api_user (id) ON DELETE CASCADE,
+    role_id UUID NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- Every read through the API. The name is kept in case the user is removed.
+CREATE TABLE read_audit
+(
+    id        UUID PRIMARY KEY     DEFAULT uuid_generate_v4(),
+    created   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id   UUID REFERENCES api_user (id) ON DELETE SET NULL,
+    user_name TEXT        NOT NULL,
+    action    TEXT        NOT NULL,
+    query     TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX read_audit_created_idx ON read_audit (created);
@@ -0,0 +1,29 @@
+-- Alert rules fire when at least threshold logs matching the filter were
+-- logged within the window, per group of the group_by columns.
+CREATE TABLE alert_rule
+(
+    id               UUID PRIMARY KEY     DEFAULT uuid_generate_v4(),
+    created          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name             TEXT        NOT NULL UNIQUE,
+    filter           TEXT        NOT NULL DEFAULT '',
+    threshold        INT         NOT NULL DEFAULT 1 CHECK (threshold > 0),
+    window_seconds   INT         NOT NULL CHECK (window_seconds > 0),
+    group_by         TEXT[]      NOT NULL DEFAULT '{}',
+    cooldown_seconds INT         NOT NULL DEFAULT 0 CHECK (cooldown_seconds >= 0),
+    sink_type        TEXT        NOT NULL,
+    sink_target      TEXT        NOT NULL,
+    enabled          BOOLEAN     NOT NULL DEFAULT TRUE
+);
+
+-- Every time a rule fired. Used for the cooldown of the rule per group.
+CREATE TABLE alert_event
+(
+    id        UUID PRIMARY KEY     DEFAULT uuid_generate_v4(),
+    created   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    rule_id   UUID        NOT NULL REFERENCES alert_rule (id) ON DELETE CASCADE,
+    group_key TEXT        NOT NULL DEFAULT '',
+    count     INT         NOT NULL,
+    error     TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX alert_event_rule_idx ON alert_event (rule_id, group_key, created);
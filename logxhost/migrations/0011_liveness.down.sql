@@ -0,0 +1,40 @@
+DROP TABLE instance_liveness;
+
+-- Columns can't be removed from a view, so it is recreated along with the
+-- log view depending on it.
+DROP VIEW log_view;
+DROP VIEW instance_view;
+
+CREATE VIEW instance_view AS
+SELECT i.id,
+       i.service_id,
+       i.machine_id,
+       i.last_seen,
+       i.sig_hash,
+       s.name   AS service,
+       m.name   AS machine,
+       s.status AS status
+FROM instance i
+         JOIN service s ON s.id = i.service_id
+         JOIN machine m ON m.id = i.machine_id;
+
+CREATE VIEW log_view AS
+SELECT l.id,
+       i.machine,
+       i.service,
+       l.context::TEXT AS context,
+       l.message,
+       l.log_type,
+       l.log_time,
+       l.created,
+       l.instance_id,
+       i.service_id,
+       i.machine_id,
+       l.context       AS context_data,
+       l.message_tsv
+FROM log l
+         JOIN instance_view i ON i.id = l.instance_id;
+
+ALTER TABLE instance
+    DROP COLUMN heartbeat_seconds,
+    DROP COLUMN liveness;
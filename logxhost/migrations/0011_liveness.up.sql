@@ -0,0 +1,43 @@
+-- Interval at which the instance sends heartbeats, as sent when it
+-- registers, and its state according to them.
+ALTER TABLE instance
+    ADD COLUMN heartbeat_seconds INT  NOT NULL DEFAULT 0,
+    ADD COLUMN liveness          TEXT NOT NULL DEFAULT 'alive';
+
+-- Existing instances start in their current state, having missed 2 or 5
+-- heartbeats of the default interval of a minute, so that those long gone
+-- aren't reported as dying.
+UPDATE instance
+SET liveness = CASE
+                   WHEN last_seen IS NULL OR last_seen < NOW() - INTERVAL '5 minutes' THEN 'dead'
+                   WHEN last_seen < NOW() - INTERVAL '2 minutes' THEN 'stale'
+                   ELSE 'alive'
+    END;
+
+CREATE OR REPLACE VIEW instance_view AS
+SELECT i.id,
+       i.service_id,
+       i.machine_id,
+       i.last_seen,
+       i.sig_hash,
+       s.name   AS service,
+       m.name   AS machine,
+       s.status AS status,
+       i.heartbeat_seconds,
+       i.liveness
+FROM instance i
+         JOIN service s ON s.id = i.service_id
+         JOIN machine m ON m.id = i.machine_id;
+
+-- Every change of the liveness of an instance.
+CREATE TABLE instance_liveness
+(
+    id             UUID PRIMARY KEY     DEFAULT uuid_generate_v4(),
+    created        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    instance_id    UUID        NOT NULL REFERENCES instance (id) ON DELETE CASCADE,
+    state          TEXT        NOT NULL,
+    previous_state TEXT        NOT NULL,
+    last_seen      TIMESTAMPTZ
+);
+
+CREATE INDEX instance_liveness_instance_idx ON instance_liveness (instance_id, created);
@@ -0,0 +1,2 @@
+DROP VIEW instance_session_view;
+DROP TABLE instance_session;
@@ -0,0 +1,27 @@
+-- Periods during which an instance was connected and sending heartbeats.
+-- Open sessions have no end.
+CREATE TABLE instance_session
+(
+    id          UUID PRIMARY KEY     DEFAULT uuid_generate_v4(),
+    instance_id UUID        NOT NULL REFERENCES instance (id) ON DELETE CASCADE,
+    started     TIMESTAMPTZ NOT NULL,
+    last_seen   TIMESTAMPTZ NOT NULL,
+    ended       TIMESTAMPTZ,
+    end_reason  TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX instance_session_instance_idx ON instance_session (instance_id, started);
+CREATE UNIQUE INDEX instance_session_open_idx ON instance_session (instance_id) WHERE ended IS NULL;
+
+CREATE VIEW instance_session_view AS
+SELECT s.id,
+       s.instance_id,
+       s.started,
+       s.last_seen,
+       s.ended,
+       s.end_reason,
+       i.service,
+       i.machine,
+       i.heartbeat_seconds
+FROM instance_session s
+         JOIN instance_view i ON i.id = s.instance_id;
@@ -0,0 +1,3 @@
+DROP INDEX log_client_id_idx;
+ALTER TABLE log
+    DROP COLUMN client_id;
@@ -0,0 +1,7 @@
+-- The id generated by the client for each log. Clients send a log again when
+-- its acknowledgement is lost, so logs already stored with the same id are
+-- not inserted again. Logs from older clients have no id.
+ALTER TABLE log
+    ADD COLUMN client_id TEXT;
+
+CREATE INDEX log_client_id_idx ON log (instance_id, client_id, log_time) WHERE client_id IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP INDEX log_client_id_key;
+CREATE INDEX log_client_id_idx ON log (instance_id, client_id, log_time) WHERE client_id IS NOT NULL;
+
+ALTER TABLE log
+    DROP COLUMN service_id;
@@ -0,0 +1,24 @@
+-- Client ids are unique per service rather than per instance, as a client
+-- moving to another machine sends its cached logs as another instance. The
+-- service is copied from the instance so that the unique index, which must
+-- include the partition key, can cover it.
+ALTER TABLE log
+    ADD COLUMN service_id UUID;
+
+UPDATE log l
+SET service_id = i.service_id
+FROM instance i
+WHERE i.id = l.instance_id
+  AND l.client_id IS NOT NULL;
+
+-- Copies stored by different instances of the same service.
+DELETE
+FROM log a
+    USING log b
+WHERE a.client_id = b.client_id
+  AND a.service_id = b.service_id
+  AND a.log_time = b.log_time
+  AND (a.created, a.id) > (b.created, b.id);
+
+DROP INDEX log_client_id_idx;
+CREATE UNIQUE INDEX log_client_id_key ON log (service_id, client_id, log_time);
@@ -0,0 +1,2 @@
+-- The hashes of the signatures can't be restored.
+SELECT 1;
@@ -0,0 +1,7 @@
+-- Certificates of API users are identified by the hash of their public key
+-- instead of their signature, which anyone who has seen the certificate can
+-- copy. The previous hashes can't be converted, so those users need to be
+-- added again with their certificate.
+UPDATE api_user
+SET cert_hash = NULL
+WHERE cert_hash IS NOT NULL;
@@ -0,0 +1,142 @@
+package logxhost
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// The log table is partitioned by day (UTC) on log_time. Each partition is
+// named after the day it covers. Logs outside of every partition are stored
+// in the default partition.
+const (
+	LogPartitionPrefix  = "log_p"
+	LogPartitionDefault = "log_default"
+)
+
+// LogPartition is a partition of the log table. Start is nil if the
+// partition has no lower bound, and both are nil for the default partition.
+type LogPartition struct {
+	Name  string
+	Start *time.Time `db:"range_start"`
+	End   *time.Time `db:"range_end"`
+}
+
+func (p *LogPartition) IsDefault() bool {
+	return p.Start == nil && p.End == nil
+}
+
+// LogPartitionName returns the name of the partition holding the logs of
+// the provided day.
+func LogPartitionName(day time.Time) string {
+	return LogPartitionPrefix + day.UTC().Format("20060102")
+}
+
+// SelectLogPartitions returns all partitions of the log table ordered by
+// their upper bound. The default partition is last.
+func SelectLogPartitions(db sqlx.Queryer) ([]*LogPartition, error) {
+	var xs []*LogPartition
+	err := sqlx.Select(db, &xs, `
+SELECT c.relname                                                                                 AS name,
+       (regexp_match(pg_get_expr(c.relpartbound, c.oid), 'FROM \(''([^'']+)''\)'))[1]::TIMESTAMPTZ AS range_start,
+       (regexp_match(pg_get_expr(c.relpartbound, c.oid), 'TO \(''([^'']+)''\)'))[1]::TIMESTAMPTZ   AS range_end
+FROM pg_inherits i
+         JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = 'log'::REGCLASS
+ORDER BY range_end NULLS LAST`)
+	if err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+// EnsureLogPartitions creates the partitions for today and the provided
+// number of days after, if they don't exist yet. It returns the names of
+// the partitions created.
+func EnsureLogPartitions(db *sqlx.DB, now time.Time, days int) ([]string, error) {
+	existing, err := SelectLogPartitions(db)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, p := range existing {
+		names[p.Name] = true
+	}
+
+	var created []string
+	today := now.UTC().Truncate(24 * time.Hour)
+	for i := 0; i <= days; i++ {
+		day := today.AddDate(0, 0, i)
+		name := LogPartitionName(day)
+		if names[name] {
+			continue
+		}
+		if err := dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+			return createLogPartition(tx, name, day, day.AddDate(0, 0, 1))
+		}); err != nil {
+			return created, err
+		}
+		created = append(created, name)
+	}
+	return created, nil
+}
+
+// Creates a partition for the provided range. Any logs in the default partition
+// belonging to the range are moved first, as Postgres refuses to create the
+// partition otherwise.
+func createLogPartition(tx *sqlx.Tx, name string, start, end time.Time) error {
+	ident := pq.QuoteIdentifier(name)
+	queries := []string{
+		`LOCK TABLE ` + LogPartitionDefault + ` IN ACCESS EXCLUSIVE MODE`,
+		`CREATE TABLE ` + ident + ` (LIKE log INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING GENERATED)`,
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	cols, err := logPartitionCols(tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+WITH moved AS (
+    DELETE FROM `+LogPartitionDefault+` WHERE log_time >= $1 AND log_time < $2 RETURNING *
+)
+INSERT INTO `+ident+`(`+cols+`)
+SELECT `+cols+` FROM moved`, start, end)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE log ATTACH PARTITION ` + ident + ` FOR VALUES FROM (` +
+		pq.QuoteLiteral(start.Format(time.RFC3339)) + `) TO (` +
+		pq.QuoteLiteral(end.Format(time.RFC3339)) + `)`)
+	return err
+}
+
+// Columns copied when moving logs between partitions, which are all the
+// columns of the log table but the generated ones, as they cannot be
+// inserted.
+func logPartitionCols(tx *sqlx.Tx) (string, error) {
+	var cols []string
+	err := tx.Select(&cols, `
+SELECT quote_ident(attname)
+FROM pg_attribute
+WHERE attrelid = 'log'::REGCLASS
+  AND attnum > 0
+  AND NOT attisdropped
+  AND attgenerated = ''
+ORDER BY attnum`)
+	return strings.Join(cols, ", "), err
+}
+
+// DropLogPartition removes the partition along with all of its logs.
+func DropLogPartition(db sqlx.Execer, name string) error {
+	_, err := db.Exec(`DROP TABLE ` + pq.QuoteIdentifier(name))
+	return err
+}
@@ -0,0 +1,79 @@
+package logxhost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestEnsureLogPartitions(t *testing.T) {
+	s := &Server{DB: DefaultTestPostgres(), SigCache: make(map[string]*Instance)}
+	instance := registerTestInstance(t, s, "Partition Machine", "Partition Service")
+	defer cleanupTestInstance(s, instance)
+
+	// Far enough in the future for the days not to have partitions yet.
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 2000)
+	names := []string{LogPartitionName(day), LogPartitionName(day.AddDate(0, 0, 1))}
+	for _, name := range names {
+		s.DB.Exec(`DROP TABLE IF EXISTS ` + pq.QuoteIdentifier(name))
+		defer s.DB.Exec(`DROP TABLE IF EXISTS ` + pq.QuoteIdentifier(name))
+	}
+
+	// Stored in the default partition until the partition of its day exists.
+	msg := logx.HostMessage{
+		Id:      "partition-1",
+		Type:    "TestLog",
+		Time:    day.Add(time.Hour),
+		Message: []byte("moved"),
+		Context: []byte("{}"),
+	}
+	if err := InsertHostMessage(s.DB, msg, instance.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := EnsureLogPartitions(s.DB, day.Add(time.Hour), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || created[0] != names[0] || created[1] != names[1] {
+		t.Errorf("Expected partitions %v to be created, got %v", names, created)
+	}
+
+	created, err = EnsureLogPartitions(s.DB, day, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 0 {
+		t.Errorf("Expected the partitions to exist, got %v", created)
+	}
+
+	var n int
+	if err := s.DB.Get(&n, `SELECT COUNT(*) FROM `+pq.QuoteIdentifier(names[0])+` WHERE instance_id=$1`, instance.Id); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected the log to be moved to its partition, got %d logs", n)
+	}
+
+	// Added after the partitions, and needed to find the log when it is
+	// sent again.
+	var moved struct {
+		ClientId  *string `db:"client_id"`
+		ServiceId *string `db:"service_id"`
+	}
+	if err := s.DB.Get(&moved, `SELECT client_id, service_id FROM log WHERE instance_id=$1`, instance.Id); err != nil {
+		t.Fatal(err)
+	}
+	if moved.ClientId == nil || *moved.ClientId != msg.Id {
+		t.Errorf("Expected the client id to be moved, got %v", moved.ClientId)
+	}
+	if moved.ServiceId == nil || *moved.ServiceId != instance.ServiceId {
+		t.Errorf("Expected the service id to be moved, got %v", moved.ServiceId)
+	}
+	if err := InsertHostMessage(s.DB, msg, instance.Id); err != ErrDuplicateLog {
+		t.Errorf("Expected the moved log to be a duplicate, got %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package logxhost
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	pgUtils "github.com/monstercat/golib/db/postgres"
+	errs "github.com/pkg/errors"
+)
+
+const (
+	DefaultLogQueryLimit = 50
+	MaxLogQueryLimit     = 1000
+
+	// OrderByRelevance orders a text search by rank instead of log time.
+	OrderByRelevance = "relevance"
+)
+
+var (
+	ErrCursorWithOrder  = errors.New("a cursor cannot be used with a custom order")
+	ErrEmptyContextPath = errors.New("context predicate requires a path")
+	ErrInvalidCursor    = errors.New("invalid cursor")
+)
+
+// LogQuery describes a search for logs. Every field is optional; filters
+// which are set must all match. All values are passed to Postgres as
+// parameters and never concatenated into the query.
+type LogQuery struct {
+	// Names of services and machines. They are matched case insensitively
+	// and may contain % wildcards.
+	Services []string
+	Machines []string
+
+	// Log types, e.g. RouteHostLog.
+	Types []string
+
+	// Severities, matched against the Severity field of the context.
+	Severities []string
+
+	// Time range of the log time. Zero values are unbounded.
+	After  time.Time
+	Before time.Time
+
+	// Full text search on the message. See ParseTextQuery.
+	Text string
+
+	// Predicates on the context of the log.
+	Context []ContextPredicate
+
+	// Filter expression, see ParseFilter.
+	Filter *Filter
+
+	// Pages through the results, which are ordered by log time, newest
+	// first. PageAfter returns the logs following the cursor (older) and
+	// PageBefore the logs preceding it (newer). See LogPage.
+	PageAfter  *Cursor
+	PageBefore *Cursor
+
+	// Order as accepted by SortConfig, e.g. "-logTime", or OrderByRelevance
+	// to rank a text search. By default, logs are ordered newest first.
+	OrderBy []string
+
+	Limit int
+
+	// Services the query is restricted to, regardless of the filters above.
+	// Not encoded by Values, as it is set by the server.
+	Scope *AccessScope
+}
+
+// ContextPredicate matches logs whose context contains Value at Path. For
+// example, Path ["Method"] and Value "POST" matches {"Method": "POST"}.
+// Arrays match if they contain the value.
+type ContextPredicate struct {
+	Path  []string
+	Value interface{}
+}
+
+// ParseContextPredicate parses Path.To.Field=value into a context predicate.
+// The value is decoded as JSON if possible, so numbers and arrays can be
+// matched too.
+func ParseContextPredicate(v string) (ContextPredicate, error) {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ContextPredicate{}, fmt.Errorf("context filter %s should be of the form Path.To.Field=value", v)
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(parts[1]), &value); err != nil {
+		value = parts[1]
+	}
+	return ContextPredicate{
+		Path:  strings.Split(parts[0], "."),
+		Value: value,
+	}, nil
+}
+
+// String is the inverse of ParseContextPredicate.
+func (p ContextPredicate) String() string {
+	value := ""
+	if s, ok := p.Value.(string); ok {
+		// Strings which would be read as JSON must be quoted.
+		var v interface{}
+		if json.Unmarshal([]byte(s), &v) != nil {
+			value = s
+		}
+	}
+	if value == "" {
+		byt, _ := json.Marshal(p.Value)
+		value = string(byt)
+	}
+	return strings.Join(p.Path, ".") + "=" + value
+}
+
+// Cursor is the position of a log in the default order.
+type Cursor struct {
+	Time time.Time
+	Id   string
+}
+
+// LogCursor returns the position of the log.
+func LogCursor(l *Log) *Cursor {
+	return &Cursor{Time: l.LogTime, Id: l.Id}
+}
+
+// String encodes the cursor into an opaque token. See ParseCursor.
+func (c *Cursor) String() string {
+	v := strconv.FormatInt(c.Time.UnixNano(), 10) + ":" + c.Id
+	return base64.RawURLEncoding.EncodeToString([]byte(v))
+}
+
+// Cursors are encoded as their token in JSON.
+func (c *Cursor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Cursor) UnmarshalText(text []byte) error {
+	parsed, err := ParseCursor(string(text))
+	if err != nil {
+		return err
+	}
+	*c = *parsed
+	return nil
+}
+
+// ParseCursor decodes a token returned by Cursor.String.
+func ParseCursor(token string) (*Cursor, error) {
+	byt, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(byt), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, ErrInvalidCursor
+	}
+	nano, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{Time: time.Unix(0, nano), Id: parts[1]}, nil
+}
+
+// Builds the JSON document to use with the @> operator, which can use
+// the index on the context.
+func (p ContextPredicate) ToSql() (string, []interface{}, error) {
+	if len(p.Path) == 0 {
+		return "", nil, ErrEmptyContextPath
+	}
+	var doc interface{} = p.Value
+	for i := len(p.Path) - 1; i >= 0; i-- {
+		doc = map[string]interface{}{p.Path[i]: doc}
+	}
+	byt, err := json.Marshal(doc)
+	if err != nil {
+		return "", nil, err
+	}
+	return "context_data @> ?::JSONB", []interface{}{string(byt)}, nil
+}
+
+func (q *LogQuery) limit() uint64 {
+	if q.Limit <= 0 {
+		return DefaultLogQueryLimit
+	}
+	if q.Limit > MaxLogQueryLimit {
+		return MaxLogQueryLimit
+	}
+	return uint64(q.Limit)
+}
+
+// Where returns the conditions of the query, without order or limit.
+func (q *LogQuery) Where() (squirrel.And, error) {
+	where := squirrel.And{}
+
+	if len(q.Services) > 0 {
+		where = append(where, squirrel.Expr("service ILIKE ANY(?)", pq.StringArray(q.Services)))
+	}
+	if len(q.Machines) > 0 {
+		where = append(where, squirrel.Expr("machine ILIKE ANY(?)", pq.StringArray(q.Machines)))
+	}
+	if len(q.Types) > 0 {
+		where = append(where, squirrel.Expr("log_type = ANY(?)", pq.StringArray(q.Types)))
+	}
+	if len(q.Severities) > 0 {
+		where = append(where, squirrel.Expr("context_data ->> 'Severity' = ANY(?)", pq.StringArray(q.Severities)))
+	}
+	if !q.After.IsZero() {
+		where = append(where, squirrel.GtOrEq{"log_time": q.After})
+	}
+	if !q.Before.IsZero() {
+		where = append(where, squirrel.Lt{"log_time": q.Before})
+	}
+
+	text, err := ParseTextQuery(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	if !text.IsEmpty() {
+		where = append(where, text)
+	}
+
+	for _, p := range q.Context {
+		where = append(where, p)
+	}
+
+	if q.Filter != nil {
+		where = append(where, q.Filter)
+	}
+
+	if q.Scope != nil {
+		where = append(where, q.Scope)
+	}
+
+	if q.PageAfter != nil {
+		where = append(where, squirrel.Expr("(log_time, id) < (?, ?)", q.PageAfter.Time, q.PageAfter.Id))
+	}
+	if q.PageBefore != nil {
+		where = append(where, squirrel.Expr("(log_time, id) > (?, ?)", q.PageBefore.Time, q.PageBefore.Id))
+	}
+	return where, nil
+}
+
+// FilterWhere returns the conditions of the query without the cursors.
+func (q *LogQuery) FilterWhere() (squirrel.And, error) {
+	filters := *q
+	filters.PageAfter, filters.PageBefore = nil, nil
+	return filters.Where()
+}
+
+func (q *LogQuery) isPaged() bool {
+	return q.PageAfter != nil || q.PageBefore != nil
+}
+
+// Logs are fetched oldest first when only paging backwards, so the limit
+// keeps the logs closest to the cursor. They are put back in order after.
+func (q *LogQuery) isBackwards() bool {
+	return q.PageBefore != nil && q.PageAfter == nil
+}
+
+// Select compiles the query into a select on the log view.
+func (q *LogQuery) Select() (squirrel.SelectBuilder, error) {
+	sel := SelectLogQry
+	where, err := q.Where()
+	if err != nil {
+		return sel, err
+	}
+	if len(where) > 0 {
+		sel = sel.Where(where)
+	}
+
+	switch {
+	case len(q.OrderBy) > 0 && q.isPaged():
+		return sel, ErrCursorWithOrder
+	case len(q.OrderBy) == 1 && q.OrderBy[0] == OrderByRelevance:
+		text, _ := ParseTextQuery(q.Text)
+		if !text.IsEmpty() {
+			rank, args := text.Rank()
+			sel = sel.OrderByClause(rank, args...)
+		}
+		sel = sel.OrderBy("log_time DESC", "id DESC")
+	case len(q.OrderBy) > 0:
+		pgUtils.ApplySortStrings(q.OrderBy, &sel, SortConfig)
+	case q.isBackwards():
+		sel = sel.OrderBy("log_time", "id")
+	default:
+		sel = sel.OrderBy("log_time DESC", "id DESC")
+	}
+
+	return sel.Limit(q.limit()), nil
+}
+
+// Values encodes the query as URL parameters. See ParseLogQueryValues.
+func (q *LogQuery) Values() url.Values {
+	v := url.Values{}
+	setList := func(key string, xs []string) {
+		if len(xs) > 0 {
+			v.Set(key, strings.Join(xs, ","))
+		}
+	}
+	setList("service", q.Services)
+	setList("machine", q.Machines)
+	setList("type", q.Types)
+	setList("severity", q.Severities)
+	setList("order", q.OrderBy)
+	if !q.After.IsZero() {
+		v.Set("time_after", q.After.Format(time.RFC3339Nano))
+	}
+	if !q.Before.IsZero() {
+		v.Set("time_before", q.Before.Format(time.RFC3339Nano))
+	}
+	if q.Text != "" {
+		v.Set("text", q.Text)
+	}
+	for _, p := range q.Context {
+		v.Add("context", p.String())
+	}
+	if q.Filter != nil {
+		v.Set("filter", q.Filter.Expr)
+	}
+	if q.PageAfter != nil {
+		v.Set("page_after", q.PageAfter.String())
+	}
+	if q.PageBefore != nil {
+		v.Set("page_before", q.PageBefore.String())
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// ParseLogQueryValues decodes a query encoded by LogQuery.Values.
+func ParseLogQueryValues(v url.Values) (*LogQuery, error) {
+	q := &LogQuery{
+		Services:   splitList(v.Get("service")),
+		Machines:   splitList(v.Get("machine")),
+		Types:      splitList(v.Get("type")),
+		Severities: splitList(v.Get("severity")),
+		OrderBy:    splitList(v.Get("order")),
+		Text:       v.Get("text"),
+	}
+
+	var err error
+	if t := v.Get("time_after"); t != "" {
+		if q.After, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, errs.Wrap(err, "time_after")
+		}
+	}
+	if t := v.Get("time_before"); t != "" {
+		if q.Before, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, errs.Wrap(err, "time_before")
+		}
+	}
+	for _, c := range v["context"] {
+		p, err := ParseContextPredicate(c)
+		if err != nil {
+			return nil, err
+		}
+		q.Context = append(q.Context, p)
+	}
+	if q.Filter, err = ParseFilter(v.Get("filter")); err != nil {
+		return nil, err
+	}
+	if c := v.Get("page_after"); c != "" {
+		if q.PageAfter, err = ParseCursor(c); err != nil {
+			return nil, err
+		}
+	}
+	if c := v.Get("page_before"); c != "" {
+		if q.PageBefore, err = ParseCursor(c); err != nil {
+			return nil, err
+		}
+	}
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil {
+			return nil, errs.Wrap(err, "limit")
+		}
+	}
+	return q, nil
+}
+
+func splitList(v string) []string {
+	var xs []string
+	for _, x := range strings.Split(v, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			xs = append(xs, x)
+		}
+	}
+	return xs
+}
@@ -0,0 +1,180 @@
+package logxhost
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogQuery(t *testing.T) {
+	injection := "x' OR '1'='1"
+	after := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	q := &LogQuery{
+		Services:   []string{injection},
+		Machines:   []string{"machine%"},
+		Types:      []string{"RouteHostLog"},
+		Severities: []string{"FATAL"},
+		After:      after,
+		Text:       "reset",
+		Context: []ContextPredicate{
+			{Path: []string{"Headers", "User-Agent"}, Value: []string{"curl"}},
+		},
+		OrderBy: []string{OrderByRelevance},
+		Limit:   5000,
+	}
+	sel, err := q.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args, err := sel.ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(query, injection) {
+		t.Errorf("Expected user input to be parameterized. Got %s", query)
+	}
+	if !strings.Contains(query, "LIMIT 1000") {
+		t.Errorf("Expected limit to be capped at 1000. Got %s", query)
+	}
+	if !strings.Contains(query, "ORDER BY ts_rank(") {
+		t.Errorf("Expected text search to be ordered by rank. Got %s", query)
+	}
+
+	var found bool
+	for _, a := range args {
+		if a == `{"Headers":{"User-Agent":["curl"]}}` {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected context predicate in args. Got %v", args)
+	}
+
+	// Cursors only work on the default order.
+	q = &LogQuery{PageAfter: &Cursor{Time: after, Id: "id"}, OrderBy: []string{"service"}}
+	if _, err := q.Select(); err != ErrCursorWithOrder {
+		t.Errorf("Expected %s, got %v", ErrCursorWithOrder, err)
+	}
+
+	// Paging backwards fetches the closest logs first.
+	q = &LogQuery{PageBefore: &Cursor{Time: after, Id: "id"}}
+	sel, err = q.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _, err = sel.ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "((log_time, id) > ($1, $2)) ORDER BY log_time, id") {
+		t.Errorf("Expected ascending keyset condition. Got %s", query)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := &Cursor{Time: time.Date(2020, 1, 2, 3, 4, 5, 123456000, time.UTC), Id: "5f0c:9"}
+	parsed, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Time.Equal(c.Time) || parsed.Id != c.Id {
+		t.Errorf("Expected %v, got %v", c, parsed)
+	}
+
+	for idx, token := range []string{"", "!!", "MTIz", "YWJjOmlk"} {
+		if _, err := ParseCursor(token); err != ErrInvalidCursor {
+			t.Errorf("[%d] Expected %s, got %v", idx, ErrInvalidCursor, err)
+		}
+	}
+}
+
+func TestStreamLogPages(t *testing.T) {
+	before := &Cursor{Time: time.Unix(10, 0), Id: "before"}
+	after := &Cursor{Time: time.Unix(20, 0), Id: "after"}
+	next := &Cursor{Time: time.Unix(30, 0), Id: "next"}
+	tests := []struct {
+		Query LogQuery
+
+		// Cursors of the queries expected for the second page.
+		PageAfter  *Cursor
+		PageBefore *Cursor
+	}{
+		{LogQuery{}, next, nil},
+		{LogQuery{PageAfter: after}, next, nil},
+		// The logs newer than the cursor, towards the newest.
+		{LogQuery{PageBefore: before}, nil, next},
+		// PageBefore bounds the logs after PageAfter.
+		{LogQuery{PageAfter: after, PageBefore: before}, next, before},
+	}
+	for i, test := range tests {
+		var queries []LogQuery
+		var streamed int
+		err := streamLogPages(&test.Query, func(q *LogQuery) (*LogPage, error) {
+			queries = append(queries, *q)
+			page := &LogPage{Logs: []*Log{{Id: "log"}}}
+			if len(queries) == 1 {
+				page.Next, page.Prev = next, next
+			}
+			return page, nil
+		}, func(logs []*Log) error {
+			streamed += len(logs)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if len(queries) != 2 || streamed != 2 {
+			t.Fatalf("[%d] Expected 2 pages, got %d queries and %d logs", i, len(queries), streamed)
+		}
+		if queries[0].PageAfter != test.Query.PageAfter || queries[0].PageBefore != test.Query.PageBefore {
+			t.Errorf("[%d] Expected the first page at the cursors of the query, got %v and %v", i, queries[0].PageAfter, queries[0].PageBefore)
+		}
+		if queries[1].PageAfter != test.PageAfter || queries[1].PageBefore != test.PageBefore {
+			t.Errorf("[%d] Expected the second page after %v and before %v, got %v and %v", i, test.PageAfter, test.PageBefore, queries[1].PageAfter, queries[1].PageBefore)
+		}
+	}
+}
+
+func TestLogQueryValues(t *testing.T) {
+	filter, err := ParseFilter(`ctx.Path~"/users/*"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &LogQuery{
+		Services:   []string{"api", "web%"},
+		Severities: []string{"WARN"},
+		After:      time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Text:       `"connection reset"`,
+		Context: []ContextPredicate{
+			{Path: []string{"Method"}, Value: "POST"},
+			{Path: []string{"Status"}, Value: float64(500)},
+			{Path: []string{"Code"}, Value: "500"},
+		},
+		Filter:    filter,
+		PageAfter: &Cursor{Time: time.Unix(10, 0), Id: "id"},
+		Limit:     20,
+	}
+
+	parsed, err := ParseLogQueryValues(q.Values())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Services, q.Services) || !reflect.DeepEqual(parsed.Severities, q.Severities) {
+		t.Errorf("Expected lists to match. Got %v %v", parsed.Services, parsed.Severities)
+	}
+	if !parsed.After.Equal(q.After) || parsed.Text != q.Text || parsed.Limit != q.Limit {
+		t.Errorf("Expected %v, got %v", q, parsed)
+	}
+	if !reflect.DeepEqual(parsed.Context, q.Context) {
+		t.Errorf("Expected context %v, got %v", q.Context, parsed.Context)
+	}
+	if parsed.Filter == nil || parsed.Filter.Expr != filter.Expr {
+		t.Errorf("Expected filter %s, got %v", filter.Expr, parsed.Filter)
+	}
+	if parsed.PageAfter == nil || parsed.PageAfter.Id != "id" || !parsed.PageAfter.Time.Equal(q.PageAfter.Time) {
+		t.Errorf("Expected cursor %v, got %v", q.PageAfter, parsed.PageAfter)
+	}
+}
@@ -0,0 +1,56 @@
+package logxhost
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// Actions recorded in the read audit.
+const (
+	ReadActionSearch    = "search"
+	ReadActionDetails   = "details"
+	ReadActionInstances = "instances"
+	ReadActionTail      = "tail"
+	ReadActionHistogram = "histogram"
+	ReadActionSessions  = "sessions"
+)
+
+// ReadAudit records a read through the API: who made it, what kind of read
+// and the parameters, e.g. the search query.
+type ReadAudit struct {
+	Id       string    `setmap:"ignore"`
+	Created  time.Time `setmap:"ignore"`
+	UserId   *string   `db:"user_id"`
+	UserName string    `db:"user_name"`
+	Action   string
+	Query    string
+}
+
+var (
+	ColsReadAudit = dbutil.GetColumnsList(&ReadAudit{}, "")
+)
+
+func (a *ReadAudit) Insert(db sqlx.Ext) error {
+	return psql.Insert(TableReadAudit).
+		SetMap(dbutil.SetMap(a, true)).
+		Suffix("RETURNING id, created").
+		RunWith(db).
+		QueryRow().
+		Scan(&a.Id, &a.Created)
+}
+
+// SelectReadAudits returns the latest reads, newest first. If user is not
+// empty, only the reads of that user name are returned.
+func SelectReadAudits(db sqlx.Queryer, user string, limit uint64) ([]*ReadAudit, error) {
+	q := psql.Select(ColsReadAudit...).From(TableReadAudit).OrderBy("created DESC").Limit(limit)
+	if user != "" {
+		q = q.Where("user_name = ?", user)
+	}
+	var xs []*ReadAudit
+	if err := dbutil.Select(db, &xs, q); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
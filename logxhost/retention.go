@@ -0,0 +1,180 @@
+package logxhost
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// RetentionRule determines how long logs are kept. A rule can apply to a
+// single service, a single severity (the Severity field of the log context,
+// e.g. DEBUG or ERROR), both, or to every log if neither is set.
+//
+// The most specific rule matching a log applies. Service rules are more
+// specific than severity rules. Logs without any rule are kept forever.
+type RetentionRule struct {
+	Id        string  `setmap:"ignore"`
+	ServiceId *string `db:"service_id"`
+	Severity  *string
+	KeepDays  int `db:"keep_days"`
+
+	// Name of the service, read when selecting rules.
+	Service *string `setmap:"ignore"`
+}
+
+func (r *RetentionRule) IsGlobal() bool {
+	return r.ServiceId == nil && r.Severity == nil
+}
+
+func (r *RetentionRule) Insert(tx *sqlx.Tx) error {
+	return psql.Insert(TableRetentionRule).
+		SetMap(dbutil.SetMap(r, true)).
+		Suffix("RETURNING id").
+		RunWith(tx).
+		QueryRow().
+		Scan(&r.Id)
+}
+
+func SelectRetentionRules(db sqlx.Queryer) ([]*RetentionRule, error) {
+	var xs []*RetentionRule
+	err := sqlx.Select(db, &xs, `
+SELECT r.id, r.service_id, r.severity, r.keep_days, s.name AS service
+FROM retention_rule r
+         LEFT JOIN service s ON s.id = r.service_id
+ORDER BY s.name NULLS FIRST, r.severity NULLS FIRST`)
+	if err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+func DeleteRetentionRule(db sqlx.Execer, id string) error {
+	_, err := db.Exec(`DELETE FROM retention_rule WHERE id=$1`, id)
+	return err
+}
+
+// Selects the logs which have expired according to the most specific
+// retention rule matching them.
+//
+// The first parameter is the current time. The second is the time before
+// which logs may have expired, i.e., the current time minus the shortest
+// retention, which allows for partition pruning. The third is the names of
+// the partitions which are dropped entirely, so their logs are not counted
+// twice.
+const expiredLogsQuery = `
+FROM log l
+         JOIN instance i ON i.id = l.instance_id
+         CROSS JOIN LATERAL (
+    SELECT r.keep_days
+    FROM retention_rule r
+    WHERE (r.service_id IS NULL OR r.service_id = i.service_id)
+      AND (r.severity IS NULL OR r.severity = l.context ->> 'Severity')
+    ORDER BY r.service_id IS NULL, r.severity IS NULL
+    LIMIT 1
+    ) r
+WHERE l.log_time < $1::TIMESTAMPTZ - make_interval(days => r.keep_days)
+  AND l.log_time < $2
+  AND l.tableoid <> ALL ($3::TEXT[]::REGCLASS[])`
+
+// RetentionPlan describes what applying the retention rules would remove.
+type RetentionPlan struct {
+	// Partitions in which every log has expired.
+	Partitions []*LogPartition
+
+	// Number of expired logs outside of the partitions above.
+	Rows int64
+
+	now, minCutoff time.Time
+}
+
+// PlanRetention determines which partitions can be dropped and how many
+// logs need to be deleted individually.
+//
+// A whole partition can only be dropped if a rule applies to every log
+// (i.e., there is a global rule) and the partition ends before the
+// longest retention.
+func PlanRetention(db sqlx.Queryer, now time.Time) (*RetentionPlan, error) {
+	rules, err := SelectRetentionRules(db)
+	if err != nil {
+		return nil, err
+	}
+	plan := &RetentionPlan{now: now}
+	if len(rules) == 0 {
+		return plan, nil
+	}
+
+	minKeep, maxKeep := rules[0].KeepDays, rules[0].KeepDays
+	var global bool
+	for _, r := range rules {
+		if r.KeepDays < minKeep {
+			minKeep = r.KeepDays
+		}
+		if r.KeepDays > maxKeep {
+			maxKeep = r.KeepDays
+		}
+		if r.IsGlobal() {
+			global = true
+		}
+	}
+	plan.minCutoff = now.AddDate(0, 0, -minKeep)
+
+	if global {
+		partitions, err := SelectLogPartitions(db)
+		if err != nil {
+			return nil, err
+		}
+		maxCutoff := now.AddDate(0, 0, -maxKeep)
+		for _, p := range partitions {
+			if p.End == nil || p.End.After(maxCutoff) {
+				continue
+			}
+			plan.Partitions = append(plan.Partitions, p)
+		}
+	}
+
+	dropped := pq.StringArray{}
+	for _, p := range plan.Partitions {
+		dropped = append(dropped, p.Name)
+	}
+	err = db.QueryRowx(`SELECT COUNT(*) `+expiredLogsQuery, plan.now, plan.minCutoff, dropped).Scan(&plan.Rows)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ApplyRetention drops the partitions in the plan and deletes the remaining
+// expired logs in batches, so the log table is never locked for long. It
+// returns the number of logs deleted individually.
+func ApplyRetention(db *sqlx.DB, plan *RetentionPlan, batchSize int) (int64, error) {
+	for _, p := range plan.Partitions {
+		if err := DropLogPartition(db, p.Name); err != nil {
+			return 0, err
+		}
+	}
+	if plan.Rows == 0 {
+		return 0, nil
+	}
+
+	// The partitions are gone, so there are none to exclude.
+	var deleted int64
+	for {
+		res, err := db.Exec(`
+DELETE FROM log
+WHERE (id, log_time) IN (SELECT l.id, l.log_time `+expiredLogsQuery+` LIMIT $4)`,
+			plan.now, plan.minCutoff, pq.StringArray{}, batchSize)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < int64(batchSize) {
+			return deleted, nil
+		}
+	}
+}
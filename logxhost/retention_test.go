@@ -0,0 +1,166 @@
+package logxhost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestRetention(t *testing.T) {
+	s := &Server{DB: DefaultTestPostgres(), SigCache: make(map[string]*Instance)}
+	a := registerTestInstance(t, s, "Retention Machine", "Retention Service A")
+	defer cleanupTestInstance(s, a)
+	b := registerTestInstance(t, s, "Retention Machine", "Retention Service B")
+	defer cleanupTestInstance(s, b)
+
+	// Far enough in the future for the partition not to overlap existing
+	// ones. Expired logs left by other tests are deleted as well.
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1000)
+	now := day.AddDate(0, 0, 500)
+	name := LogPartitionName(day)
+	s.DB.Exec(`DROP TABLE IF EXISTS ` + pq.QuoteIdentifier(name))
+	defer s.DB.Exec(`DROP TABLE IF EXISTS ` + pq.QuoteIdentifier(name))
+
+	logs := []struct {
+		Instance *Instance
+		Message  string
+		Severity string
+		Days     int
+		Kept     bool
+	}{
+		// In the partition, expired for every rule.
+		{a, "a partition", "", 0, false},
+		{b, "b partition", "", 0, false},
+		// Before the partition, in the default partition.
+		{b, "b before partition", "", -1, false},
+		// The rule of service A is longer than the global rule.
+		{a, "a 400 days", "", 400, true},
+		{b, "b 400 days", "", 400, false},
+		// The rule of service A is more specific than the severity rule.
+		{a, "a debug", "DEBUG", 490, true},
+		{b, "b debug", "DEBUG", 490, false},
+		// The rule of service B for errors is the most specific.
+		{b, "b error", "ERROR", 440, true},
+	}
+	for i, l := range logs {
+		msg := logx.HostMessage{
+			Type:    "TestLog",
+			Time:    day.AddDate(0, 0, l.Days).Add(time.Hour),
+			Message: []byte(l.Message),
+			Context: []byte(`{"Severity": "` + l.Severity + `"}`),
+		}
+		if err := InsertHostMessage(s.DB, msg, l.Instance.Id); err != nil {
+			t.Fatalf("[%d] Could not insert log: %s", i, err)
+		}
+	}
+	if err := dbutil.TxNow(s.DB, func(tx *sqlx.Tx) error {
+		return createLogPartition(tx, name, day, day.AddDate(0, 0, 1))
+	}); err != nil {
+		t.Fatalf("Could not create partition: %s", err)
+	}
+
+	debug, errorSeverity := "DEBUG", "ERROR"
+	rules := []*RetentionRule{
+		{ServiceId: &a.ServiceId, KeepDays: 365},
+		{Severity: &debug, KeepDays: 7},
+		{ServiceId: &b.ServiceId, Severity: &errorSeverity, KeepDays: 90},
+	}
+	insertRules := func(rules ...*RetentionRule) {
+		for _, r := range rules {
+			if err := dbutil.TxNow(s.DB, r.Insert); err != nil {
+				t.Fatalf("Could not insert rule: %s", err)
+			}
+		}
+	}
+	defer func() {
+		for _, r := range rules {
+			if r.Id != "" {
+				DeleteRetentionRule(s.DB, r.Id)
+			}
+		}
+	}()
+	insertRules(rules...)
+
+	// Without a global rule, some logs of the partition may have to be kept.
+	plan, err := PlanRetention(s.DB, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasPartition(plan, name) {
+		t.Error("Expected the partition not to be dropped without a global rule")
+	}
+
+	global := &RetentionRule{KeepDays: 30}
+	rules = append(rules, global)
+	insertRules(global)
+
+	plan, err = PlanRetention(s.DB, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasPartition(plan, name) {
+		t.Fatalf("Expected partition %s to be dropped, got %v", name, plan.Partitions)
+	}
+	if plan.Rows < 3 {
+		t.Errorf("Expected at least 3 logs to be deleted, got %d", plan.Rows)
+	}
+
+	// Only the partition of this test is dropped. The logs of the others
+	// are deleted one by one instead.
+	for _, p := range plan.Partitions {
+		if p.Name == name {
+			plan.Partitions = []*LogPartition{p}
+		}
+	}
+	deleted, err := ApplyRetention(s.DB, plan, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted < 3 {
+		t.Errorf("Expected at least 3 logs to be deleted in batches, got %d", deleted)
+	}
+
+	var kept []string
+	err = s.DB.Select(&kept, `SELECT message FROM log WHERE instance_id=ANY($1) ORDER BY message`, pq.StringArray([]string{a.Id, b.Id}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	for _, l := range logs {
+		if l.Kept {
+			expected = append(expected, l.Message)
+		}
+	}
+	if len(kept) != len(expected) {
+		t.Fatalf("Expected logs %v to be kept, got %v", expected, kept)
+	}
+	for i := range expected {
+		if kept[i] != expected[i] {
+			t.Errorf("[%d] Expected %q to be kept, got %q", i, expected[i], kept[i])
+		}
+	}
+
+	partitions, err := SelectLogPartitions(s.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range partitions {
+		if p.Name == name {
+			t.Error("Expected the partition to be dropped")
+		}
+	}
+}
+
+func hasPartition(plan *RetentionPlan, name string) bool {
+	for _, p := range plan.Partitions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
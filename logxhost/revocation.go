@@ -0,0 +1,170 @@
+package logxhost
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// Postgres channel on which revocations are announced so that running
+// servers can drop cached signatures and open connections immediately.
+const RevocationChannel = "logx_revocation"
+
+const (
+	RevocationActionRevokeService  = "RevokeService"
+	RevocationActionDisableService = "DisableService"
+	RevocationActionEnableService  = "EnableService"
+	RevocationActionRevokeHash     = "RevokeHash"
+)
+
+var (
+	ErrServiceRevoked = errors.New("service has been revoked or disabled")
+	ErrHashRevoked    = errors.New("certificate has been revoked")
+)
+
+// Revocation is the payload sent over the RevocationChannel.
+// Only one of the fields is filled in.
+type Revocation struct {
+	ServiceId string `json:",omitempty"`
+	SigHash   string `json:",omitempty"`
+}
+
+// RevocationAudit records who changed the status of a service
+// or revoked a certificate hash.
+type RevocationAudit struct {
+	Id        string    `setmap:"ignore"`
+	Created   time.Time `setmap:"ignore"`
+	Actor     string
+	Action    string
+	ServiceId *string `db:"service_id"`
+	SigHash   string  `db:"sig_hash"`
+	Reason    string
+}
+
+var (
+	ColsRevocationAudit = dbutil.GetColumnsList(&RevocationAudit{}, "")
+)
+
+func (a *RevocationAudit) Insert(tx *sqlx.Tx) error {
+	return psql.Insert(TableRevocationAudit).
+		SetMap(dbutil.SetMap(a, true)).
+		Suffix("RETURNING id").
+		RunWith(tx).
+		QueryRow().
+		Scan(&a.Id)
+}
+
+func notifyRevocation(tx *sqlx.Tx, r Revocation) error {
+	byt, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`SELECT pg_notify($1, $2)`, RevocationChannel, string(byt))
+	return err
+}
+
+// SetServiceStatus changes the status of the service, records the change in
+// the audit table and notifies any listening servers, all in one transaction.
+func SetServiceStatus(db *sqlx.DB, service *Service, status ServiceStatus, actor, reason string) error {
+	action := RevocationActionEnableService
+	switch status {
+	case ServiceStatusRevoked:
+		action = RevocationActionRevokeService
+	case ServiceStatusDisabled:
+		action = RevocationActionDisableService
+	}
+
+	return dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+		service.Status = status
+		if err := service.UpdateStatus(tx); err != nil {
+			return err
+		}
+		audit := &RevocationAudit{
+			Actor:     actor,
+			Action:    action,
+			ServiceId: &service.Id,
+			Reason:    reason,
+		}
+		if err := audit.Insert(tx); err != nil {
+			return err
+		}
+		return notifyRevocation(tx, Revocation{ServiceId: service.Id})
+	})
+}
+
+// RevokeHash stops the certificate with the provided hash from being used
+// to register or to log. Services using the hash are left untouched; they
+// may register again using a new certificate.
+func RevokeHash(db *sqlx.DB, hash []byte, actor, reason string) error {
+	return dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`INSERT INTO revoked_hash(sig_hash) VALUES($1) ON CONFLICT DO NOTHING`, hash)
+		if err != nil {
+			return err
+		}
+		audit := &RevocationAudit{
+			Actor:   actor,
+			Action:  RevocationActionRevokeHash,
+			SigHash: string(hash),
+			Reason:  reason,
+		}
+		if err := audit.Insert(tx); err != nil {
+			return err
+		}
+		return notifyRevocation(tx, Revocation{SigHash: string(hash)})
+	})
+}
+
+func IsHashRevoked(db sqlx.Queryer, hash []byte) (bool, error) {
+	var revoked bool
+	err := db.QueryRowx(`SELECT EXISTS(SELECT 1 FROM revoked_hash WHERE sig_hash=$1)`, hash).Scan(&revoked)
+	return revoked, err
+}
+
+// ListenRevocations listens for revocations sent by other processes (e.g.,
+// the revoke commands) and invalidates the signature cache and open
+// connections accordingly. It blocks until the die channel is closed.
+func (s *Server) ListenRevocations(url string, die chan bool, eh func(error)) error {
+	connStr, err := pq.ParseURL(url)
+	if err != nil {
+		return err
+	}
+	listener := pq.NewListener(connStr, 10*time.Millisecond, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			eh(err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(RevocationChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-die:
+			return nil
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established and
+			// notifications may have been missed. Drop the whole cache.
+			if n == nil {
+				s.clearSigCache()
+				continue
+			}
+			var r Revocation
+			if err := json.Unmarshal([]byte(n.Extra), &r); err != nil {
+				eh(err)
+				continue
+			}
+			if r.ServiceId != "" {
+				s.InvalidateService(r.ServiceId)
+			}
+			if r.SigHash != "" {
+				s.InvalidateHash([]byte(r.SigHash))
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package logxhost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestRevocation(t *testing.T) {
+
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	var ids []string
+	var hashes []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableRevocationAudit+` WHERE service_id=ANY($1) OR sig_hash=ANY($2)`, pq.StringArray(ids), pq.StringArray(hashes))
+		s.DB.Exec(`DELETE FROM `+TableRevokedHash+` WHERE sig_hash=ANY($1)`, pq.StringArray(hashes))
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE service_id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, "Revocation Machine")
+	}()
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	msg := logx.HostMessage{
+		Type:    logx.MsgTypeRegister,
+		Machine: "Revocation Machine",
+		Service: "Revocation Service",
+	}
+	details := ConnDetails{
+		Hash: s.marshalHash(cert.Signature),
+	}
+
+	instance, err := s.RegisterService(msg, details)
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, instance.ServiceId)
+
+	service, err := GetService(s.DB, squirrel.Eq{"id": instance.ServiceId})
+	if err != nil {
+		t.Fatalf("Could not get service: %s", err)
+	}
+
+	if _, err := s.VerifySignature(details.Hash); err != nil {
+		t.Fatalf("Expected signature to be valid: %s", err)
+	}
+
+	// Revoking the service should remove it from the cache and stop it
+	// from registering again.
+	if err := SetServiceStatus(s.DB, service, ServiceStatusRevoked, "test", "compromised"); err != nil {
+		t.Fatalf("Could not revoke service: %s", err)
+	}
+	s.InvalidateService(service.Id)
+
+	if _, err := s.VerifySignature(details.Hash); err != ErrServiceRevoked {
+		t.Errorf("Expected %s after revoking service, got %v", ErrServiceRevoked, err)
+	}
+	if _, err := s.RegisterService(msg, details); err != ErrServiceRevoked {
+		t.Errorf("Expected %s when registering revoked service, got %v", ErrServiceRevoked, err)
+	}
+
+	// Enabling it again should allow registration.
+	if err := SetServiceStatus(s.DB, service, ServiceStatusActive, "test", ""); err != nil {
+		t.Fatalf("Could not enable service: %s", err)
+	}
+	s.InvalidateService(service.Id)
+
+	if _, err := s.RegisterService(msg, details); err != nil {
+		t.Errorf("Expected enabled service to register, got %s", err)
+	}
+
+	// Revoking the hash stops the certificate from being used at all.
+	hashes = append(hashes, string(details.Hash))
+	if err := RevokeHash(s.DB, details.Hash, "test", "leaked"); err != nil {
+		t.Fatalf("Could not revoke hash: %s", err)
+	}
+	s.InvalidateHash(details.Hash)
+
+	if _, err := s.VerifySignature(details.Hash); err != ErrHashRevoked {
+		t.Errorf("Expected %s after revoking hash, got %v", ErrHashRevoked, err)
+	}
+	if _, err := s.RegisterService(msg, details); err != ErrHashRevoked {
+		t.Errorf("Expected %s when registering with revoked hash, got %v", ErrHashRevoked, err)
+	}
+
+	var count int
+	if err := s.DB.Get(&count, `SELECT COUNT(*) FROM `+TableRevocationAudit+` WHERE service_id=$1 OR sig_hash=$2`, service.Id, string(details.Hash)); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 audit entries, got %d", count)
+	}
+}
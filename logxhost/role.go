@@ -0,0 +1,136 @@
+package logxhost
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// Role grants read access to the logs and instances of the services
+// matching one of its patterns. Patterns may contain * wildcards; "*" alone
+// grants access to every service.
+type Role struct {
+	Id       string    `setmap:"ignore"`
+	Created  time.Time `setmap:"ignore"`
+	Name     string
+	Services pq.StringArray
+}
+
+var (
+	ColsRole = dbutil.GetColumnsList(&Role{}, "")
+)
+
+func (r *Role) Insert(tx *sqlx.Tx) error {
+	return psql.Insert(TableRole).
+		SetMap(dbutil.SetMap(r, true)).
+		Suffix("RETURNING id, created").
+		RunWith(tx).
+		QueryRow().
+		Scan(&r.Id, &r.Created)
+}
+
+func GetRole(db sqlx.Queryer, where interface{}) (*Role, error) {
+	var r Role
+	if err := dbutil.Get(db, &r, psql.Select(ColsRole...).From(TableRole).Where(where)); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func SelectRoles(db sqlx.Queryer) ([]*Role, error) {
+	var xs []*Role
+	if err := dbutil.Select(db, &xs, psql.Select(ColsRole...).From(TableRole).OrderBy("name")); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+func DeleteRole(db sqlx.Execer, name string) error {
+	_, err := db.Exec(`DELETE FROM `+TableRole+` WHERE name=$1`, name)
+	return err
+}
+
+func GrantRole(db sqlx.Execer, userId, roleId string) error {
+	_, err := db.Exec(`INSERT INTO `+TableAPIUserRole+`(user_id, role_id) VALUES($1, $2) ON CONFLICT DO NOTHING`, userId, roleId)
+	return err
+}
+
+func RevokeRole(db sqlx.Execer, userId, roleId string) error {
+	_, err := db.Exec(`DELETE FROM `+TableAPIUserRole+` WHERE user_id=$1 AND role_id=$2`, userId, roleId)
+	return err
+}
+
+// SelectUserRoles returns the roles granted to the API user.
+func SelectUserRoles(db sqlx.Queryer, userId string) ([]*Role, error) {
+	var xs []*Role
+	q := psql.Select(ColsRole...).
+		From(TableRole).
+		Where("id IN (SELECT role_id FROM "+TableAPIUserRole+" WHERE user_id = ?)", userId).
+		OrderBy("name")
+	if err := dbutil.Select(db, &xs, q); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+// AccessScope is the set of services a user may read. It can be used as a
+// condition on any view with a service column, such as the log and
+// instance views. An empty scope matches nothing.
+type AccessScope struct {
+	Services []string
+}
+
+// GetAccessScope returns the scope granted by all the roles of the user.
+func GetAccessScope(db sqlx.Queryer, userId string) (*AccessScope, error) {
+	roles, err := SelectUserRoles(db, userId)
+	if err != nil {
+		return nil, err
+	}
+	scope := &AccessScope{}
+	for _, r := range roles {
+		scope.Services = append(scope.Services, r.Services...)
+	}
+	return scope, nil
+}
+
+func (a *AccessScope) IsAll() bool {
+	for _, s := range a.Services {
+		if s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AccessScope) ToSql() (string, []interface{}, error) {
+	if a.IsAll() {
+		return "TRUE", nil, nil
+	}
+	if len(a.Services) == 0 {
+		return "FALSE", nil, nil
+	}
+	patterns := make(pq.StringArray, len(a.Services))
+	for i, s := range a.Services {
+		patterns[i] = globToLike(s)
+	}
+	return "service ILIKE ANY(?)", []interface{}{patterns}, nil
+}
+
+func (a *AccessScope) String() string {
+	if len(a.Services) == 0 {
+		return "(none)"
+	}
+	return strings.Join(a.Services, ",")
+}
+
+// Used by GetLog and SelectInstances, which may be used without a scope.
+func scopeWhere(sel squirrel.SelectBuilder, scope *AccessScope) squirrel.SelectBuilder {
+	if scope == nil {
+		return sel
+	}
+	return sel.Where(scope)
+}
@@ -0,0 +1,39 @@
+package logxhost
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestAccessScope(t *testing.T) {
+	tests := []struct {
+		Services []string
+		Sql      string
+		Args     []interface{}
+	}{
+		{Services: nil, Sql: "FALSE"},
+		{Services: []string{"api", "*"}, Sql: "TRUE"},
+		{
+			Services: []string{"api", "web-*", "100%"},
+			Sql:      "service ILIKE ANY(?)",
+			Args:     []interface{}{pq.StringArray{"api", "web-%", `100\%`}},
+		},
+	}
+
+	for idx, test := range tests {
+		scope := &AccessScope{Services: test.Services}
+		sql, args, err := scope.ToSql()
+		if err != nil {
+			t.Errorf("[%d] Unexpected error %s", idx, err)
+			continue
+		}
+		if sql != test.Sql {
+			t.Errorf("[%d] Expected %s, got %s", idx, test.Sql, sql)
+		}
+		if !reflect.DeepEqual(args, test.Args) {
+			t.Errorf("[%d] Expected args %v, got %v", idx, test.Args, args)
+		}
+	}
+}
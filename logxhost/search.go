@@ -0,0 +1,145 @@
+package logxhost
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Text search configuration used for the message_tsv column.
+const TextSearchConfig = "simple"
+
+var (
+	ErrUnterminatedPhrase = errors.New("unterminated phrase in text query")
+)
+
+// TextQuery is a full text search on log messages. It is created from a
+// search string by ParseTextQuery and can be used as a squirrel condition.
+//
+// The search string supports:
+//
+//	error timeout       both words must match
+//	"connection reset"  the words must match in order
+//	conn*               words starting with conn
+//	-debug              the word must not match
+//	error OR warning    either must match
+type TextQuery struct {
+	// The query in tsquery syntax.
+	TsQuery string
+}
+
+// ToSql allows the query to be used in a where clause.
+func (q TextQuery) ToSql() (string, []interface{}, error) {
+	return "message_tsv @@ to_tsquery('" + TextSearchConfig + "', ?)", []interface{}{q.TsQuery}, nil
+}
+
+// Rank returns an expression to order results by relevance.
+func (q TextQuery) Rank() (string, []interface{}) {
+	return "ts_rank(message_tsv, to_tsquery('" + TextSearchConfig + "', ?)) DESC", []interface{}{q.TsQuery}
+}
+
+func (q TextQuery) IsEmpty() bool {
+	return q.TsQuery == ""
+}
+
+// ParseTextQuery converts a search string into a TextQuery. All user input
+// is quoted as lexemes so it can never be interpreted as tsquery operators.
+func ParseTextQuery(text string) (TextQuery, error) {
+	var terms []string
+	var op string
+	runes := []rune(text)
+
+	add := func(term string) {
+		if term == "" {
+			return
+		}
+		if len(terms) > 0 {
+			if op == "" {
+				op = "&"
+			}
+			terms = append(terms, op)
+		}
+		terms = append(terms, term)
+		op = ""
+	}
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		negate := false
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			negate = true
+			i++
+		}
+
+		var term string
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return TextQuery{}, ErrUnterminatedPhrase
+			}
+			term = phraseTerm(string(runes[i+1 : end]))
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			word := string(runes[i:end])
+			i = end
+			if word == "OR" && !negate {
+				if len(terms) > 0 {
+					op = "|"
+				}
+				continue
+			}
+			term = wordTerm(word)
+		}
+
+		if term != "" && negate {
+			term = "!" + term
+		}
+		add(term)
+	}
+
+	return TextQuery{TsQuery: strings.Join(terms, " ")}, nil
+}
+
+// Words in a phrase must follow each other.
+func phraseTerm(phrase string) string {
+	var words []string
+	for _, w := range strings.Fields(phrase) {
+		if t := wordTerm(w); t != "" {
+			words = append(words, t)
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
+
+// Quotes the word as a lexeme. A trailing * makes it a prefix match.
+func wordTerm(word string) string {
+	prefix := strings.HasSuffix(word, "*")
+	word = strings.TrimRight(word, "*")
+	if word == "" {
+		return ""
+	}
+	word = strings.ReplaceAll(word, `\`, `\\`)
+	word = strings.ReplaceAll(word, `'`, `''`)
+	term := "'" + word + "'"
+	if prefix {
+		term += ":*"
+	}
+	return term
+}
@@ -0,0 +1,37 @@
+package logxhost
+
+import (
+	"testing"
+)
+
+func TestParseTextQuery(t *testing.T) {
+	tests := []struct {
+		Text     string
+		Expected string
+		Err      error
+	}{
+		{Text: "", Expected: ""},
+		{Text: "timeout", Expected: "'timeout'"},
+		{Text: "error  timeout", Expected: "'error' & 'timeout'"},
+		{Text: `"connection reset" peer`, Expected: "('connection' <-> 'reset') & 'peer'"},
+		{Text: "conn*", Expected: "'conn':*"},
+		{Text: "error -debug", Expected: "'error' & !'debug'"},
+		{Text: "error OR warning", Expected: "'error' | 'warning'"},
+		{Text: `-"not found"`, Expected: "!('not' <-> 'found')"},
+		{Text: "it's", Expected: "'it''s'"},
+		{Text: `a\b`, Expected: `'a\\b'`},
+		{Text: "'' & | !", Expected: "'''''' & '&' & '|' & '!'"},
+		{Text: `"unterminated`, Err: ErrUnterminatedPhrase},
+	}
+
+	for idx, test := range tests {
+		q, err := ParseTextQuery(test.Text)
+		if err != test.Err {
+			t.Errorf("[%d] Expected error %v, got %v", idx, test.Err, err)
+			continue
+		}
+		if q.TsQuery != test.Expected {
+			t.Errorf("[%d] Expected %s, got %s", idx, test.Expected, q.TsQuery)
+		}
+	}
+}
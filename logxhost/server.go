@@ -9,10 +9,13 @@ import (
 	"errors"
 	"io"
 	"net"
+	"net/smtp"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
 
 	dbutil "github.com/monstercat/golib/db"
@@ -28,8 +31,103 @@ type Server struct {
 
 	DB *sqlx.DB
 
-	SigCache      map[string]*Service
+	// Postgres URL used to listen for new logs when tailing through the API.
+	PostgresURL string
+
+	// Receives the errors of API requests which failed on the server. They
+	// are not shown to the clients. Defaults to the standard logger.
+	APIErrorHandler func(error)
+
+	SigCache      map[string]*Instance
 	SigCacheMutex sync.RWMutex
+
+	// Number of days of log partitions to create ahead of time.
+	PartitionsAhead int
+
+	// Whether to apply the retention rules during maintenance.
+	RetentionEnabled bool
+
+	// Interval between maintenance runs. Defaults to an hour.
+	MaintenanceInterval time.Duration
+
+	// Interval between evaluations of the alert rules. Defaults to a minute.
+	AlertInterval time.Duration
+
+	// SMTP server used by alert rules sending emails.
+	SMTPAddr string
+	SMTPAuth smtp.Auth
+	SMTPFrom string
+
+	// Whether alert rules may run commands on the server.
+	AlertCommandsEnabled bool
+
+	// Interval between liveness checks of the instances. Defaults to
+	// DefaultLivenessInterval.
+	LivenessInterval time.Duration
+
+	// Where to send an alert when an instance dies or comes back. No alerts
+	// are sent if the type is empty.
+	LivenessSinkType   AlertSinkType
+	LivenessSinkTarget string
+
+	// Counts of what goes through the server. See MetricsHandler.
+	Metrics ServerMetrics
+
+	// If set, logs are stored in batches by the ingester, which must be
+	// running. Otherwise each log is inserted as it is received.
+	Ingester *Ingester
+
+	// Connections beyond MaxConnections, or MaxServiceConnections for a
+	// service, are rejected. Unlimited if zero.
+	MaxConnections        int
+	MaxServiceConnections int
+
+	// Time allowed for the TLS handshake, for a client to stay without
+	// sending anything, and to write a message to a client. Default to
+	// DefaultHandshakeTimeout, DefaultIdleTimeout and DefaultWriteTimeout.
+	HandshakeTimeout time.Duration
+	IdleTimeout      time.Duration
+	WriteTimeout     time.Duration
+
+	// Largest message accepted from clients, in bytes. Defaults to
+	// DefaultMaxMessageSize; unlimited if negative.
+	MaxMessageSize int64
+
+	// Logs per second accepted from each service, in bursts of up to
+	// ServiceRateBurst. Logs over the limit are rejected and stay in the
+	// cache of the client. Unlimited if zero.
+	ServiceRateLimit float64
+	ServiceRateBurst int
+	rateLimiter      rateLimiter
+
+	// Connections being handled, including the ones not yet tracked.
+	active int64
+
+	// Connections being told why they are rejected. See reject.
+	rejecting int64
+
+	// Storing a log taking at least PauseInsertLatency tells the clients to
+	// stop sending logs for PauseDuration, keeping them in their cache
+	// rather than piling up in the server. They are told to resume earlier
+	// if logs are stored quickly again. Default to DefaultPauseInsertLatency
+	// and DefaultPauseDuration; clients are never paused if the latency is
+	// negative.
+	PauseInsertLatency time.Duration
+	PauseDuration      time.Duration
+	pausedUntil        time.Time
+	pauseMu            sync.Mutex
+
+	// Open connections, so they can be closed when their
+	// service or certificate is revoked.
+	conns   map[net.Conn]*ConnDetails
+	connsMu sync.Mutex
+
+	// Listeners and connections being handled, so that Shutdown can stop
+	// them.
+	listeners    map[net.Listener]bool
+	handling     sync.WaitGroup
+	shuttingDown bool
+	shutdownMu   sync.Mutex
 }
 
 func (s *Server) CheckPassword(password string) bool {
@@ -41,7 +139,7 @@ type ConnDetails struct {
 
 	// These details will be filled in if the user is
 	// authenticated
-	Service *Service
+	Instance *Instance
 
 	// Write Channel
 	WrCh chan logx.ClientMessage
@@ -94,14 +192,28 @@ func (s *Server) Serve(listener net.Listener, eh func(error)) {
 
 	// Initialize this if not yet initialized.
 	if s.SigCache == nil {
-		s.SigCache = make(map[string]*Service)
+		s.SigCache = make(map[string]*Instance)
+	}
+	s.connsMu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]*ConnDetails)
+	}
+	s.connsMu.Unlock()
+
+	if !s.trackListener(listener) {
+		listener.Close()
+		return
 	}
+	defer s.untrackListener(listener)
 
 	var tempDelay time.Duration
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if s.isShuttingDown() {
+				return
+			}
 			if err != io.EOF {
 				eh(err)
 			}
@@ -116,36 +228,160 @@ func (s *Server) Serve(listener net.Listener, eh func(error)) {
 			return
 		}
 
+		if s.MaxConnections > 0 && atomic.LoadInt64(&s.active) >= int64(s.MaxConnections) {
+			s.reject(conn, RejectedConnections)
+			continue
+		}
+
 		// handle the connection
-		go s.handleConn(conn, eh)
+		if !s.startHandling() {
+			conn.Close()
+			return
+		}
+		atomic.AddInt64(&s.active, 1)
+		go func() {
+			defer s.handling.Done()
+			defer atomic.AddInt64(&s.active, -1)
+			s.handleConn(conn, eh)
+		}()
 	}
 }
 
 // This function verifies the signature of the incoming connection.
-// It will return the service that corresponds to the signature,
+// It will return the service instance that corresponds to the signature,
 // if any.
 //
 // This should be called after Serve() is called, as the
 // signature cache needs to be initiated.
-func (s *Server) VerifySignature(sig []byte) (*Service, error) {
+func (s *Server) VerifySignature(sig []byte) (*Instance, error) {
 	s.SigCacheMutex.RLock()
-	service, ok := s.SigCache[string(sig)]
+	instance, ok := s.SigCache[string(sig)]
 	s.SigCacheMutex.RUnlock()
+	s.Metrics.SigCacheLookup(ok)
 	if ok {
-		return service, nil
+		return instance, nil
 	}
-	service, err := GetServiceByHash(s.DB, sig)
+	revoked, err := IsHashRevoked(s.DB, sig)
 	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, ErrHashRevoked
+	}
+	instance, err = GetInstanceByHash(s.DB, sig)
+	if err != nil {
+		return nil, err
+	}
+	if !instance.IsActive() {
+		return nil, ErrServiceRevoked
+	}
+	s.addToSigCache(instance, sig)
+
+	return instance, nil
+}
+
+// InvalidateService removes all instances of the service from the signature
+// cache and closes all of their open connections. It should be called whenever
+// the status of the service changes.
+func (s *Server) InvalidateService(id string) {
+	s.SigCacheMutex.Lock()
+	for k, v := range s.SigCache {
+		if v.ServiceId == id {
+			delete(s.SigCache, k)
+		}
+	}
+	s.SigCacheMutex.Unlock()
+
+	s.closeConns(func(d *ConnDetails) bool {
+		return d.Instance != nil && d.Instance.ServiceId == id
+	})
+}
+
+// InvalidateHash removes the hash from the signature cache and closes
+// all open connections using a certificate with said hash.
+func (s *Server) InvalidateHash(hash []byte) {
 	s.SigCacheMutex.Lock()
-	s.SigCache[string(sig)] = service
+	delete(s.SigCache, string(hash))
 	s.SigCacheMutex.Unlock()
 
-	return service, nil
+	s.closeConns(func(d *ConnDetails) bool {
+		return string(d.Hash) == string(hash)
+	})
+}
+
+func (s *Server) clearSigCache() {
+	s.SigCacheMutex.Lock()
+	s.SigCache = make(map[string]*Instance)
+	s.SigCacheMutex.Unlock()
+}
+
+func (s *Server) trackConn(conn net.Conn, details *ConnDetails) {
+	s.connsMu.Lock()
+	s.conns[conn] = details
+	s.connsMu.Unlock()
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.connsMu.Unlock()
+}
+
+// Ends the session of the instance of the connection, unless the instance
+// is still connected through another connection.
+func (s *Server) disconnectSession(details *ConnDetails) error {
+	s.connsMu.Lock()
+	instance := details.Instance
+	connected := false
+	for _, d := range s.conns {
+		if instance != nil && d.Instance != nil && d.Instance.Id == instance.Id {
+			connected = true
+		}
+	}
+	s.connsMu.Unlock()
+	if instance == nil || connected {
+		return nil
+	}
+	return EndSession(s.DB, instance.Id, time.Now(), SessionEndDisconnect)
+}
+
+// Sets the instance of the connection. This is guarded as the
+// instance is read when closing revoked connections.
+func (s *Server) setConnInstance(details *ConnDetails, instance *Instance) {
+	s.connsMu.Lock()
+	details.Instance = instance
+	s.connsMu.Unlock()
+}
+
+// Closes all connections matching the provided function after
+// telling the client why.
+func (s *Server) closeConns(match func(*ConnDetails) bool) {
+	var matched []net.Conn
+	s.connsMu.Lock()
+	for conn, details := range s.conns {
+		if match(details) {
+			matched = append(matched, conn)
+		}
+	}
+	s.connsMu.Unlock()
+
+	for _, conn := range matched {
+		s.send(conn, logx.ClientMessage{
+			Type:    logx.MsgTypeAuthorization,
+			Status:  logx.ClientMessageStatusFailed,
+			Message: "Revoked",
+		})
+		conn.Close()
+	}
 }
 
 func (s *Server) marshalHash(sig []byte) []byte {
+	return SignatureHash(sig)
+}
+
+// SignatureHash returns the hash under which a certificate with the
+// provided signature is stored.
+func SignatureHash(sig []byte) []byte {
 	return []byte(base64.StdEncoding.EncodeToString(sig))
 }
 
@@ -160,11 +396,13 @@ func (s *Server) handleConn(conn net.Conn, eh func(error)) {
 		conn.Close()
 		return
 	}
+	conn.SetDeadline(time.Now().Add(s.handshakeTimeout()))
 	if err := tlsConn.Handshake(); err != nil {
 		eh(err)
 		conn.Close()
 		return
 	}
+	conn.SetDeadline(time.Time{})
 
 	// Writer channel initiation to write messages back to the client.
 	wrCh := make(chan logx.ClientMessage)
@@ -177,7 +415,7 @@ func (s *Server) handleConn(conn net.Conn, eh func(error)) {
 		for {
 			select {
 			case msg := <-wrCh:
-				if err := sendToClient(conn, msg); err != nil {
+				if err := s.send(conn, msg); err != nil {
 					eh(err)
 				}
 			case <-done:
@@ -186,9 +424,20 @@ func (s *Server) handleConn(conn net.Conn, eh func(error)) {
 		}
 	}()
 
+	// Logs queued in the ingester are acknowledged before the writer stops.
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
 	connDetails := ConnDetails{
 		WrCh: wrCh,
 	}
+	s.trackConn(conn, &connDetails)
+	defer func() {
+		s.untrackConn(conn)
+		if err := s.disconnectSession(&connDetails); err != nil {
+			eh(err)
+		}
+	}()
 
 	state := tlsConn.ConnectionState()
 	if len(state.PeerCertificates) == 0 {
@@ -200,39 +449,73 @@ func (s *Server) handleConn(conn net.Conn, eh func(error)) {
 	// in a way that it can be represented.
 	connDetails.Hash = s.marshalHash(tlsConn.ConnectionState().PeerCertificates[0].Signature)
 
-	service, err := s.VerifySignature(connDetails.Hash)
+	instance, err := s.VerifySignature(connDetails.Hash)
+	if err == ErrServiceRevoked || err == ErrHashRevoked {
+		s.send(conn, logx.ClientMessage{
+			Type:    logx.MsgTypeAuthorization,
+			Status:  logx.ClientMessageStatusFailed,
+			Message: err.Error(),
+		})
+		return
+	}
 	if err != sql.ErrNoRows && err != nil {
 		eh(err)
 		return
 	}
 
-	// Service in the connection details would be
+	// Instance in the connection details would be
 	// completed if verified. Otherwise, it would
 	// be nil. By being nil, the connection would be
 	// considered unauthorized.
-	connDetails.Service = service
+	if reason := s.checkServiceConns(conn, instance); reason != "" {
+		s.Metrics.Rejected(reason)
+		s.send(conn, rejection(logx.MsgTypeLimit, "", reason))
+		return
+	}
+	s.setConnInstance(&connDetails, instance)
+	if instance != nil {
+		if err := TouchSession(s.DB, instance, time.Now()); err != nil {
+			eh(err)
+		}
+		if msg := s.currentPause(time.Now()); msg != nil {
+			wrCh <- *msg
+		}
+	}
 
 	//Parse message right away.
-	dec := json.NewDecoder(conn)
+	dec := newMessageDecoder(conn, s.maxMessageSize())
 	for {
 		var m logx.HostMessage
 
 		//TODO: log all incoming message errors somewhere including the service details
 		// ONLY if the service is available.
+		conn.SetReadDeadline(time.Now().Add(s.idleTimeout(connDetails.Instance)))
+
+		// Checked after the deadline is set, which would otherwise replace
+		// the one set by Shutdown.
+		if s.isShuttingDown() {
+			return
+		}
 		if err := dec.Decode(&m); err != nil {
-			if err == io.EOF {
+			if err == io.EOF || s.isShuttingDown() {
 				return
 			}
 			eh(err)
+			s.Metrics.DecodeError()
+			if err == ErrMessageTooLarge {
+				s.Metrics.Rejected(RejectedMessageSize)
+				s.send(conn, rejection(logx.MsgTypeDecode, "", RejectedMessageSize))
+				return
+			}
 			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
-				sendToClient(conn, logx.ClientMessage{
+				s.send(conn, logx.ClientMessage{
 					Type:    logx.MsgTypeDecode,
 					Status:  logx.ClientMessageStatusFailed,
 					Message: "Timeout",
 				})
 				return
 			}
-			sendToClient(conn, logx.ClientMessage{
+			s.send(conn, logx.ClientMessage{
 				Type:    logx.MsgTypeDecode,
 				Status:  logx.ClientMessageStatusFailed,
 				Message: "400: Could not decode message. " + err.Error(),
@@ -244,23 +527,26 @@ func (s *Server) handleConn(conn net.Conn, eh func(error)) {
 		// processing if the passwords don't match.
 		if m.Type == logx.MsgTypeRegister {
 			if !s.CheckPassword(string(m.Message)) {
-				sendToClient(conn, logx.ClientMessage{
+				s.Metrics.Registration(RegistrationFailed)
+				s.send(conn, logx.ClientMessage{
 					Type:    logx.MsgTypeRegister,
 					Status:  logx.ClientMessageStatusFailed,
 					Message: "Password doesn't match",
 				})
 				return
 			}
-			service, err := s.RegisterService(m, connDetails)
+			instance, err := s.RegisterService(m, connDetails)
 			if err != nil {
-				sendToClient(conn, logx.ClientMessage{
+				s.Metrics.Registration(RegistrationFailed)
+				s.send(conn, logx.ClientMessage{
 					Type:    logx.MsgTypeRegister,
 					Status:  logx.ClientMessageStatusFailed,
 					Message: "Could not register service: " + err.Error(),
 				})
 			} else {
-				connDetails.Service = service
-				sendToClient(conn, logx.ClientMessage{
+				s.Metrics.Registration(RegistrationSuccessful)
+				s.setConnInstance(&connDetails, instance)
+				s.send(conn, logx.ClientMessage{
 					Type:   logx.MsgTypeRegister,
 					Status: logx.ClientMessageStatusSuccessful,
 				})
@@ -276,11 +562,23 @@ func (s *Server) handleConn(conn net.Conn, eh func(error)) {
 
 		// At this point, we got a proper log message.
 		// We can hand off the logging.
+		s.Metrics.MessageReceived(m.Type, connDetails.Instance.Service)
 		switch m.Type {
 		case logx.MsgTypeHeartbeat:
 			HeartbeatHandler(s.DB, m, connDetails)
 		default:
-			DefaultMessageHandler(s.DB, m, connDetails)
+			if !s.rateLimiter.allow(connDetails.Instance.ServiceId, s.ServiceRateLimit, s.ServiceRateBurst, time.Now()) {
+				s.Metrics.Rejected(RejectedRateLimit)
+				wrCh <- rejection(m.Type, m.Id, RejectedRateLimit)
+				continue
+			}
+			if s.Ingester != nil {
+				s.ingestMessage(m, connDetails, &pending)
+				continue
+			}
+			start := time.Now()
+			storeMessage(s.DB, m, connDetails, &s.Metrics)
+			s.checkBackpressure(time.Since(start))
 		}
 	}
 }
@@ -295,102 +593,160 @@ func sendToClient(conn net.Conn, msg logx.ClientMessage) error {
 }
 
 func IsAuthorized(details ConnDetails) bool {
-	if details.Service == nil {
+	if details.Instance == nil {
 		return false
 	}
-	if details.Service.Id == "" {
+	if details.Instance.Id == "" {
 		return false
 	}
 	return true
 }
 
 func DefaultMessageHandler(db *sqlx.DB, msg logx.HostMessage, conn ConnDetails) {
+	storeMessage(db, msg, conn, nil)
+}
+
+// Stores the message and tells the client whether it was stored. The insert
+// is recorded in the metrics, if provided.
+func storeMessage(db *sqlx.DB, msg logx.HostMessage, conn ConnDetails, metrics *ServerMetrics) {
 	if !IsAuthorized(conn) {
 		return
 	}
-	err := InsertHostMessage(db, msg, conn.Service.Id)
-	if err != nil {
-		conn.WrCh <- logx.ClientMessage{
+	start := time.Now()
+	err := InsertHostMessage(db, msg, conn.Instance.Id)
+	if metrics != nil {
+		metrics.Stored(time.Since(start), err)
+	}
+	conn.WrCh <- storedAck(msg, err)
+}
+
+// Queues the message to be stored by the ingester. The client is told
+// whether it was stored once its batch is written.
+func (s *Server) ingestMessage(msg logx.HostMessage, conn ConnDetails, pending *sync.WaitGroup) {
+	pending.Add(1)
+	start := time.Now()
+	s.Ingester.Ingest(msg, conn.Instance, func(err error) {
+		d := time.Since(start)
+		s.Metrics.Stored(d, err)
+		s.checkBackpressure(d)
+
+		// Sent apart so that a slow client doesn't hold up the batches.
+		go func() {
+			defer pending.Done()
+			conn.WrCh <- storedAck(msg, err)
+		}()
+	})
+}
+
+// Returns the acknowledgement of the message, given the result of storing
+// it.
+func storedAck(msg logx.HostMessage, err error) logx.ClientMessage {
+	switch {
+	case err == ErrDuplicateLog:
+		// Already stored, so the client can remove it.
+		return logx.ClientMessage{
+			Type:   msg.Type,
+			Status: logx.ClientMessageStatusDuplicate,
+			Id:     msg.Id,
+		}
+	case err != nil:
+		return logx.ClientMessage{
 			Type:    msg.Type,
 			Status:  logx.ClientMessageStatusFailed,
 			Id:      msg.Id,
 			Message: "Failed to store messaage: " + err.Error(),
 		}
-		return
 	}
-	conn.WrCh <- logx.ClientMessage{
+	return logx.ClientMessage{
 		Type:   msg.Type,
 		Status: logx.ClientMessageStatusSuccessful,
 		Id:     msg.Id,
 	}
 }
 
-func (s *Server) addToSigCache(service *Service, hash []byte) {
+func (s *Server) addToSigCache(instance *Instance, hash []byte) {
 	s.SigCacheMutex.Lock()
-	s.SigCache[string(hash)] = service
+	s.SigCache[string(hash)] = instance
 	s.SigCacheMutex.Unlock()
 }
 
-// This function registers the service with the current host.
-// if the service is already registered, it will return the registered service
-// after ensuring that the name of origin / service is updated.
+// This function registers the service instance with the current host.
+// The Register message names the service and the machine; together they
+// identify the instance, which is created if it doesn't exist yet.
 //
-// if the service hasn't been already registered, it will attempt to register the
-// service.
-func (s *Server) RegisterService(msg logx.HostMessage, conn ConnDetails) (*Service, error) {
+// The certificate hash of the connection is associated with the instance,
+// and removed from any instance it was previously associated with. This
+// allows a client to move to a different machine or service name by
+// registering again.
+func (s *Server) RegisterService(msg logx.HostMessage, conn ConnDetails) (*Instance, error) {
 	db := s.DB
 
-	// First, check if the service exists by hash!
-	service, err := GetServiceByHash(db, conn.Hash)
-	if err != sql.ErrNoRows && err != nil {
+	// Revoked certificates can never register again.
+	revoked, err := IsHashRevoked(db, conn.Hash)
+	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, ErrHashRevoked
+	}
 
-	// If the name / machine doesn't match, we can assume the register is trying
-	// to update the machine or service name. We can do that here.
-	if service != nil {
-		if service.Name != msg.Service || service.Machine != msg.Machine {
-			service.Name = msg.Service
-			service.Machine = msg.Machine
-			if err := dbutil.TxNow(db, service.Update); err != nil {
-				return nil, err
-			}
+	var instance *Instance
+	err = dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+		service, err := EnsureService(tx, msg.Service)
+		if err != nil {
+			return err
+		}
+		if !service.IsActive() {
+			return ErrServiceRevoked
+		}
+		machine, err := EnsureMachine(tx, msg.Machine)
+		if err != nil {
+			return err
 		}
-		s.addToSigCache(service, conn.Hash)
-		return service, nil
-	}
 
-	// If the service is null, that means we couldn't find it by
-	// hash. We should find it by name instead. Then, we can update the hash.
-	service, err = GetServiceByName(db, msg.Machine, msg.Service)
-	if err != sql.ErrNoRows && err != nil {
-		return nil, err
-	}
-	if service != nil {
-		if err := service.UpdateHash(db); err != nil {
-			return nil, err
+		instance, err = GetInstance(tx, squirrel.Eq{"service_id": service.Id, "machine_id": machine.Id})
+		if err != sql.ErrNoRows && err != nil {
+			return err
 		}
-		s.addToSigCache(service, conn.Hash)
-		return service, nil
-	}
 
-	// Otherwise, we don't have this value in the database *at all*. So
-	// we need to insert it.
-	service = &Service{
-		Machine:  msg.Machine,
-		Name:     msg.Service,
-		LastSeen: time.Now(),
-		SigHash:  conn.Hash,
-	}
-	if err := dbutil.TxNow(db, service.Insert); err != nil {
+		// Otherwise, we don't have this instance in the database *at all*. So
+		// we need to insert it.
+		if instance == nil {
+			instance = &Instance{
+				ServiceId: service.Id,
+				MachineId: machine.Id,
+				LastSeen:  time.Now(),
+				SigHash:   conn.Hash,
+			}
+			if err := instance.Insert(tx); err != nil {
+				return err
+			}
+		}
+
+		instance.Service = service.Name
+		instance.Machine = machine.Name
+		instance.Status = service.Status
+		instance.SigHash = conn.Hash
+		if err := instance.UpdateHash(tx); err != nil {
+			return err
+		}
+		instance.HeartbeatSeconds = int(msg.HeartbeatInterval / time.Second)
+		return instance.UpdateHeartbeatInterval(tx)
+	})
+	if err != nil {
 		return nil, err
 	}
-	s.addToSigCache(service, conn.Hash)
-	return service, nil
+
+	s.addToSigCache(instance, conn.Hash)
+	return instance, nil
 }
 
 func HeartbeatHandler(db *sqlx.DB, msg logx.HostMessage, conn ConnDetails) {
-	if err := conn.Service.UpdateLastSeen(db); err != nil {
+	err := conn.Instance.UpdateLastSeen(db)
+	if err == nil {
+		err = TouchSession(db, conn.Instance, time.Now())
+	}
+	if err != nil {
 		conn.WrCh <- logx.ClientMessage{
 			Type:    logx.MsgTypeHeartbeat,
 			Status:  logx.ClientMessageStatusFailed,
@@ -1,9 +1,12 @@
 package logxhost
 
 import (
+	"database/sql"
 	"testing"
 	"time"
 
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 
 	dbutil "github.com/monstercat/golib/db"
@@ -15,17 +18,20 @@ func TestRegisterService(t *testing.T) {
 	s := &Server{
 		DB:       DefaultTestPostgres(),
 		Password: "testpassword",
-		SigCache: make(map[string]*Service),
+		SigCache: make(map[string]*Instance),
 	}
 
 	// List of ids to delete at the end.
 	var ids []string
+	var serviceNames, machineNames []string
 
 	// Hashes if we want to test multiple operations
 	// on the same hashes
 	var hashes [][]byte
 	defer func() {
-		s.DB.Exec(`DELETE FROM `+TableService+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=ANY($1)`, pq.StringArray(serviceNames))
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=ANY($1)`, pq.StringArray(machineNames))
 	}()
 
 	tests := []struct {
@@ -49,18 +55,32 @@ func TestRegisterService(t *testing.T) {
 	}
 
 	for idx, test := range tests {
+		serviceNames = append(serviceNames, test.Service)
+		machineNames = append(machineNames, test.Machine)
+
 		cert, _, err := logx.GenerateCerts(time.Hour)
 		if err != nil {
 			t.Errorf("[%d] Could not generate cert: %s", idx, err)
 			continue
 		}
 		if test.StoreHash {
-			service := &Service{
-				Machine: test.Machine,
-				Name:    test.Service,
-				SigHash: s.marshalHash(cert.Signature),
-			}
-			if err := dbutil.TxNow(s.DB, service.Insert); err != nil {
+			err := dbutil.TxNow(s.DB, func(tx *sqlx.Tx) error {
+				service, err := EnsureService(tx, test.Service)
+				if err != nil {
+					return err
+				}
+				machine, err := EnsureMachine(tx, test.Machine)
+				if err != nil {
+					return err
+				}
+				instance := &Instance{
+					ServiceId: service.Id,
+					MachineId: machine.Id,
+					SigHash:   s.marshalHash(cert.Signature),
+				}
+				return instance.Insert(tx)
+			})
+			if err != nil {
 				t.Errorf("[%d] Could not insert hash: %s", idx, err)
 				continue
 			}
@@ -72,23 +92,23 @@ func TestRegisterService(t *testing.T) {
 			Service: test.Service,
 		}
 
-		service, err := s.RegisterService(msg, ConnDetails{
+		instance, err := s.RegisterService(msg, ConnDetails{
 			Hash: s.marshalHash(cert.Signature),
 		})
 		if err != nil {
 			t.Errorf("[%d] Could not register service: %s", idx, err)
-
+			continue
 		}
-		if service.Id == "" {
-			t.Errorf("[%d] Expecting service ID to be filled in ", idx)
+		if instance.Id == "" {
+			t.Errorf("[%d] Expecting instance ID to be filled in ", idx)
 		}
-		ids = append(ids, service.Id)
-		hashes = append(hashes, service.SigHash)
-		if service.Name != test.Service {
-			t.Errorf("[%d] Expecting service name to be %s, got %s", idx, test.Service, service.Name)
+		ids = append(ids, instance.Id)
+		hashes = append(hashes, instance.SigHash)
+		if instance.Service != test.Service {
+			t.Errorf("[%d] Expecting service name to be %s, got %s", idx, test.Service, instance.Service)
 		}
-		if service.Machine != test.Machine {
-			t.Errorf("[%d] Expecting service machine to be %s, got %s", idx, test.Machine, service.Machine)
+		if instance.Machine != test.Machine {
+			t.Errorf("[%d] Expecting service machine to be %s, got %s", idx, test.Machine, instance.Machine)
 		}
 	}
 
@@ -97,7 +117,9 @@ func TestRegisterService(t *testing.T) {
 		t.Fatal("Need at least one hash to continue!")
 	}
 
-	service, err := s.RegisterService(logx.HostMessage{
+	serviceNames = append(serviceNames, "Updated")
+	machineNames = append(machineNames, "Updated")
+	instance, err := s.RegisterService(logx.HostMessage{
 		Type:    logx.MsgTypeRegister,
 		Machine: "Updated",
 		Service: "Updated",
@@ -107,11 +129,64 @@ func TestRegisterService(t *testing.T) {
 	if err != nil {
 		t.Fatal("Could not register a second time: " + err.Error())
 	}
+	ids = append(ids, instance.Id)
+
+	if instance.Service != "Updated" {
+		t.Errorf("Expected service to be updated to 'Updated', but got %s", instance.Service)
+	}
+	if instance.Machine != "Updated" {
+		t.Errorf("Expected machine to be updated to 'Updated', but got %s", instance.Machine)
+	}
+
+	// The hash should have moved to the new instance.
+	if _, err := GetInstance(s.DB, squirrel.Eq{"id": ids[0], "sig_hash": hashes[0]}); err != sql.ErrNoRows {
+		t.Errorf("Expected hash to be removed from the previous instance, got %v", err)
+	}
+}
+
+func TestRegisterServiceOnManyMachines(t *testing.T) {
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	machines := []string{"Shared Machine 1", "Shared Machine 2"}
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "Shared Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=ANY($1)`, pq.StringArray(machines))
+	}()
+
+	// The same service should be able to run on two machines.
+	for idx, machine := range machines {
+		cert, _, err := logx.GenerateCerts(time.Hour)
+		if err != nil {
+			t.Fatalf("[%d] Could not generate cert: %s", idx, err)
+		}
+		instance, err := s.RegisterService(logx.HostMessage{
+			Type:    logx.MsgTypeRegister,
+			Machine: machine,
+			Service: "Shared Service",
+		}, ConnDetails{
+			Hash: s.marshalHash(cert.Signature),
+		})
+		if err != nil {
+			t.Fatalf("[%d] Could not register service: %s", idx, err)
+		}
+		ids = append(ids, instance.Id)
+	}
+
+	if ids[0] == ids[1] {
+		t.Error("Expected a separate instance for each machine")
+	}
 
-	if service.Name != "Updated" {
-		t.Errorf("Expected service to be updated to 'Updated', but got %s", service.Name)
+	instances, err := SelectInstances(s.DB, []string{"Shared Service"})
+	if err != nil {
+		t.Fatal(err)
 	}
-	if service.Machine != "Updated" {
-		t.Errorf("Expected machine to be updated to 'Updated', but got %s", service.Machine)
+	if len(instances) != 2 {
+		t.Errorf("Expected 2 instances, got %d", len(instances))
 	}
 }
@@ -1,63 +1,63 @@
 package logxhost
 
 import (
-	"time"
-
-	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	dbutil "github.com/monstercat/golib/db"
 )
 
+// Service is a named application which logs to the host. The same
+// service can run on many machines; each of those is an Instance.
 type Service struct {
-	Id       string `setmap:"ignore"`
-	Machine  string
-	Name     string
-	LastSeen time.Time `db:"last_seen"`
-	SigHash  []byte    `db:"sig_hash"`
+	Id     string `setmap:"ignore"`
+	Name   string
+	Status ServiceStatus
 }
 
-var (
-	ColsService = dbutil.GetColumnsList(&Service{}, "")
-)
+// ServiceStatus determines whether a service is allowed to register
+// and send logs to the host.
+type ServiceStatus string
 
-func (s *Service) Insert(tx *sqlx.Tx) error {
-	return psql.Insert(TableService).
-		SetMap(dbutil.SetMap(s, true)).
-		Suffix("RETURNING id").
-		RunWith(tx).
-		QueryRow().
-		Scan(&s.Id)
-}
+const (
+	ServiceStatusActive ServiceStatus = "Active"
 
-func (s *Service) Update(tx *sqlx.Tx) error {
-	if s.Id == "" {
-		return ErrInvalidId
-	}
+	// Disabled services have been decommissioned. They can be enabled again.
+	ServiceStatusDisabled ServiceStatus = "Disabled"
 
-	s.LastSeen = time.Now()
-	_, err := psql.Update(TableService).
-		SetMap(dbutil.SetMap(s, false)).
-		Where("id=?", s.Id).
-		RunWith(tx).
-		Exec()
-	return err
+	// Revoked services are considered compromised.
+	ServiceStatusRevoked ServiceStatus = "Revoked"
+)
+
+// IsActive returns whether the service may still log to the host.
+func (s *Service) IsActive() bool {
+	return s.Status == ServiceStatusActive
 }
 
-func (s *Service) UpdateHash(db sqlx.Ext) error {
+var (
+	ColsService = dbutil.GetColumnsList(&Service{}, "")
+)
+
+func (s *Service) UpdateStatus(db sqlx.Ext) error {
 	if s.Id == "" {
 		return ErrInvalidId
 	}
-	_, err := db.Exec(`UPDATE service SET sig_hash=$2 WHERE id=$1`, s.Id, s.SigHash)
+	_, err := db.Exec(`UPDATE service SET status=$2 WHERE id=$1`, s.Id, s.Status)
 	return err
 }
 
-func (s *Service) UpdateLastSeen(db sqlx.Ext) error {
-	if s.Id == "" {
-		return ErrInvalidId
+// EnsureService returns the service with the provided name, creating
+// it if it does not exist yet.
+func EnsureService(db sqlx.Queryer, name string) (*Service, error) {
+	var s Service
+	err := db.QueryRowx(`
+INSERT INTO service(name) VALUES($1)
+ON CONFLICT (name) DO UPDATE SET name=EXCLUDED.name
+RETURNING id, name, status
+`, name).StructScan(&s)
+	if err != nil {
+		return nil, err
 	}
-	_, err := db.Exec(`UPDATE service SET last_seen=NOW() WHERE id=$1`, s.Id)
-	return err
+	return &s, nil
 }
 
 func GetService(db sqlx.Queryer, where interface{}) (*Service, error) {
@@ -68,14 +68,6 @@ func GetService(db sqlx.Queryer, where interface{}) (*Service, error) {
 	return &s, nil
 }
 
-func GetServiceByHash(db sqlx.Queryer, hash []byte) (*Service, error) {
-	return GetService(db, squirrel.Eq{"sig_hash": hash})
-}
-
-func GetServiceByName(db sqlx.Queryer, machine, name string) (*Service, error) {
-	return GetService(db, squirrel.Eq{"name": name, "machine": machine})
-}
-
 func SelectServices(db sqlx.Queryer, names []string) ([]*Service, error) {
 	var xs []*Service
 	var qry = psql.Select(ColsService...).From(TableService)
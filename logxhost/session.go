@@ -0,0 +1,230 @@
+package logxhost
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// Reasons a session ended.
+const (
+	SessionEndDisconnect = "disconnect"
+	SessionEndGap        = "gap"
+)
+
+// Session is a period during which an instance was connected and sending
+// heartbeats. It ends when the instance disconnects, or when it missed too
+// many heartbeats, in which case it ended when the instance was last seen.
+type Session struct {
+	Id         string `setmap:"ignore"`
+	InstanceId string `db:"instance_id"`
+	Started    time.Time
+	LastSeen   time.Time  `db:"last_seen"`
+	Ended      *time.Time `json:",omitempty"`
+	EndReason  string     `db:"end_reason" json:",omitempty"`
+
+	// These are read from the session view.
+	Service          string `setmap:"ignore"`
+	Machine          string `setmap:"ignore"`
+	HeartbeatSeconds int    `db:"heartbeat_seconds" setmap:"ignore" json:"-"`
+}
+
+var (
+	ColsSession = dbutil.GetColumnsList(&Session{}, "")
+)
+
+// End returns when the session ended. Open sessions last until now, unless
+// the instance is dead, in which case they ended when it was last seen.
+func (s *Session) End(now time.Time) time.Time {
+	if s.Ended != nil {
+		return *s.Ended
+	}
+	i := &Instance{HeartbeatSeconds: s.HeartbeatSeconds, LastSeen: s.LastSeen}
+	if i.LivenessAt(now) == LivenessDead {
+		return s.LastSeen
+	}
+	return now
+}
+
+// TouchSession records that the instance was seen. The open session of the
+// instance is extended, unless the instance has been silent for too long,
+// in which case it is ended and a new one started.
+//
+// A session which ended with a disconnect less than a heartbeat interval
+// ago is reopened, so that reconnecting, e.g. after registering, isn't
+// counted as downtime.
+func TouchSession(db *sqlx.DB, i *Instance, now time.Time) error {
+	interval := i.HeartbeatInterval()
+	return dbutil.TxNow(db, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`UPDATE `+TableSession+` SET ended=last_seen, end_reason=$3 WHERE instance_id=$1 AND ended IS NULL AND last_seen < $2`,
+			i.Id, now.Add(-time.Duration(DeadHeartbeats)*interval), SessionEndGap)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE `+TableSession+` SET last_seen=$2 WHERE instance_id=$1 AND ended IS NULL`, i.Id, now)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+		res, err = tx.Exec(`UPDATE `+TableSession+` SET ended=NULL, end_reason='', last_seen=$2
+			WHERE id=(SELECT id FROM `+TableSession+` WHERE instance_id=$1 ORDER BY started DESC LIMIT 1)
+			AND end_reason=$3 AND ended >= $4`, i.Id, now, SessionEndDisconnect, now.Add(-interval))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO `+TableSession+` (instance_id, started, last_seen) VALUES ($1, $2, $2)`, i.Id, now)
+		return err
+	})
+}
+
+// EndSession ends the open session of the instance, if any.
+func EndSession(db sqlx.Execer, instanceId string, at time.Time, reason string) error {
+	_, err := db.Exec(`UPDATE `+TableSession+` SET ended=$2, end_reason=$3 WHERE instance_id=$1 AND ended IS NULL`, instanceId, at, reason)
+	return err
+}
+
+// SelectSessions returns the sessions of the matching services which
+// overlap the time range, in the scope if one is provided.
+func SelectSessions(db sqlx.Queryer, services []string, from, to time.Time, scope *AccessScope) ([]*Session, error) {
+	qry := psql.Select(ColsSession...).
+		From(ViewSession).
+		Where("started < ?", to).
+		Where("(ended IS NULL OR ended > ?)", from).
+		OrderBy("service", "machine", "started")
+	qry = scopeWhere(qry, scope)
+	if len(services) > 0 {
+		qry = qry.Where("service LIKE ANY(?)", pq.StringArray(services))
+	}
+	var xs []*Session
+	if err := dbutil.Select(db, &xs, qry); err != nil {
+		return nil, err
+	}
+	return xs, nil
+}
+
+// UptimePeriod is a period during which an instance or service was up or
+// down. Down periods have the reason the preceding session ended.
+type UptimePeriod struct {
+	Start  time.Time
+	End    time.Time
+	Up     bool
+	Reason string `json:",omitempty"`
+}
+
+func (p *UptimePeriod) Duration() time.Duration {
+	return p.End.Sub(p.Start)
+}
+
+// Uptime of an instance, or of a service when the instance fields are empty.
+type Uptime struct {
+	Service    string
+	Machine    string `json:",omitempty"`
+	InstanceId string `json:",omitempty"`
+
+	// Percentage of the time range that was up.
+	Percent float64
+	Periods []*UptimePeriod
+}
+
+// InstanceUptimes returns the uptime of each instance between from and to.
+// Times after now are left out.
+func InstanceUptimes(instances []*Instance, sessions []*Session, from, to, now time.Time) []*Uptime {
+	byInstance := make(map[string][]*Session)
+	for _, s := range sessions {
+		byInstance[s.InstanceId] = append(byInstance[s.InstanceId], s)
+	}
+	xs := make([]*Uptime, 0, len(instances))
+	for _, i := range instances {
+		u := calculateUptime(byInstance[i.Id], from, to, now)
+		u.Service = i.Service
+		u.Machine = i.Machine
+		u.InstanceId = i.Id
+		xs = append(xs, u)
+	}
+	return xs
+}
+
+// ServiceUptimes returns the uptime of the services of the instances
+// between from and to. A service is up while any of its instances is.
+func ServiceUptimes(instances []*Instance, sessions []*Session, from, to, now time.Time) []*Uptime {
+	byService := make(map[string][]*Session)
+	for _, s := range sessions {
+		byService[s.Service] = append(byService[s.Service], s)
+	}
+	var xs []*Uptime
+	seen := make(map[string]bool)
+	for _, i := range instances {
+		if seen[i.Service] {
+			continue
+		}
+		seen[i.Service] = true
+		u := calculateUptime(byService[i.Service], from, to, now)
+		u.Service = i.Service
+		xs = append(xs, u)
+	}
+	return xs
+}
+
+func calculateUptime(sessions []*Session, from, to, now time.Time) *Uptime {
+	if now.Before(to) {
+		to = now
+	}
+	u := &Uptime{}
+	if !to.After(from) {
+		return u
+	}
+
+	// Up periods within the range, merging overlapping sessions.
+	var ups []*UptimePeriod
+	for _, s := range sessions {
+		p := &UptimePeriod{Start: s.Started, End: s.End(now), Up: true, Reason: s.EndReason}
+		if p.Start.Before(from) {
+			p.Start = from
+		}
+		if p.End.After(to) {
+			p.End = to
+		}
+		if p.End.After(p.Start) {
+			ups = append(ups, p)
+		}
+	}
+	sort.Slice(ups, func(a, b int) bool {
+		return ups[a].Start.Before(ups[b].Start)
+	})
+	var merged []*UptimePeriod
+	for _, p := range ups {
+		if n := len(merged); n > 0 && !p.Start.After(merged[n-1].End) {
+			if p.End.After(merged[n-1].End) {
+				merged[n-1].End = p.End
+				merged[n-1].Reason = p.Reason
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+
+	// Fill the gaps with down periods.
+	var up time.Duration
+	start, reason := from, ""
+	for _, p := range merged {
+		if p.Start.After(start) {
+			u.Periods = append(u.Periods, &UptimePeriod{Start: start, End: p.Start, Reason: reason})
+		}
+		u.Periods = append(u.Periods, &UptimePeriod{Start: p.Start, End: p.End, Up: true})
+		up += p.Duration()
+		start, reason = p.End, p.Reason
+	}
+	if to.After(start) {
+		u.Periods = append(u.Periods, &UptimePeriod{Start: start, End: to, Reason: reason})
+	}
+	u.Percent = float64(up) / float64(to.Sub(from)) * 100
+	return u
+}
@@ -0,0 +1,180 @@
+package logxhost
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestUptimes(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+	at := func(h int) time.Time {
+		return from.Add(time.Duration(h) * time.Hour)
+	}
+	ended := func(h int) *time.Time {
+		t := at(h)
+		return &t
+	}
+
+	instances := []*Instance{
+		{Id: "a", Service: "api", Machine: "one"},
+		{Id: "b", Service: "api", Machine: "two"},
+		{Id: "c", Service: "worker", Machine: "one"},
+	}
+	sessions := []*Session{
+		// Started before the range.
+		{InstanceId: "a", Service: "api", Started: from.Add(-time.Hour), LastSeen: at(2), Ended: ended(2), EndReason: SessionEndDisconnect},
+		{InstanceId: "a", Service: "api", Started: at(4), LastSeen: at(6), Ended: ended(6), EndReason: SessionEndGap},
+		{InstanceId: "b", Service: "api", Started: at(1), LastSeen: at(5), Ended: ended(5), EndReason: SessionEndDisconnect},
+	}
+
+	uptimes := InstanceUptimes(instances, sessions, from, to, to)
+	tests := []struct {
+		Percent float64
+		Periods []UptimePeriod
+	}{
+		{40, []UptimePeriod{
+			{Start: from, End: at(2), Up: true},
+			{Start: at(2), End: at(4), Reason: SessionEndDisconnect},
+			{Start: at(4), End: at(6), Up: true},
+			{Start: at(6), End: to, Reason: SessionEndGap},
+		}},
+		{40, []UptimePeriod{
+			{Start: from, End: at(1)},
+			{Start: at(1), End: at(5), Up: true},
+			{Start: at(5), End: to, Reason: SessionEndDisconnect},
+		}},
+		{0, []UptimePeriod{
+			{Start: from, End: to},
+		}},
+	}
+	for idx, test := range tests {
+		u := uptimes[idx]
+		if u.InstanceId != instances[idx].Id {
+			t.Errorf("[%d] Expected instance %s, got %s", idx, instances[idx].Id, u.InstanceId)
+		}
+		if math.Abs(u.Percent-test.Percent) > 0.001 {
+			t.Errorf("[%d] Expected %.2f%%, got %.2f%%", idx, test.Percent, u.Percent)
+		}
+		if len(u.Periods) != len(test.Periods) {
+			t.Errorf("[%d] Expected %d periods, got %d", idx, len(test.Periods), len(u.Periods))
+			continue
+		}
+		for pidx, p := range u.Periods {
+			if *p != test.Periods[pidx] {
+				t.Errorf("[%d:%d] Expected %v, got %v", idx, pidx, test.Periods[pidx], *p)
+			}
+		}
+	}
+
+	services := ServiceUptimes(instances, sessions, from, to, to)
+	if len(services) != 2 {
+		t.Fatalf("Expected 2 services, got %d", len(services))
+	}
+	if services[0].Service != "api" || math.Abs(services[0].Percent-60) > 0.001 {
+		t.Errorf("Expected api to be up 60%%, got %s %.2f%%", services[0].Service, services[0].Percent)
+	}
+	if services[1].Service != "worker" || services[1].Percent != 0 {
+		t.Errorf("Expected worker to be up 0%%, got %s %.2f%%", services[1].Service, services[1].Percent)
+	}
+
+	// Open sessions last until now, unless the instance is dead.
+	open := []*Session{
+		{InstanceId: "a", Service: "api", Started: at(8), LastSeen: at(9), HeartbeatSeconds: 60},
+		{InstanceId: "b", Service: "api", Started: at(2), LastSeen: at(3), HeartbeatSeconds: 60},
+	}
+	uptimes = InstanceUptimes(instances[:2], open, from, to, at(9).Add(time.Minute))
+	if p := uptimes[0].Periods[1]; !p.Up || !p.End.Equal(at(9).Add(time.Minute)) {
+		t.Errorf("Expected open session to last until now, got %v", *p)
+	}
+	if p := uptimes[1].Periods[1]; !p.Up || !p.End.Equal(at(3)) {
+		t.Errorf("Expected dead session to end when last seen, got %v", *p)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "Session Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, "Session Machine")
+	}()
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	instance, err := s.RegisterService(logx.HostMessage{
+		Type:              logx.MsgTypeRegister,
+		Machine:           "Session Machine",
+		Service:           "Session Service",
+		HeartbeatInterval: 10 * time.Second,
+	}, ConnDetails{Hash: s.marshalHash(cert.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, instance.Id)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	at := func(secs int) time.Time {
+		return start.Add(time.Duration(secs) * time.Second)
+	}
+	steps := []func() error{
+		func() error { return TouchSession(s.DB, instance, at(0)) },
+		func() error { return TouchSession(s.DB, instance, at(10)) },
+		// Reconnecting right away continues the session.
+		func() error { return EndSession(s.DB, instance.Id, at(15), SessionEndDisconnect) },
+		func() error { return TouchSession(s.DB, instance, at(20)) },
+		func() error { return TouchSession(s.DB, instance, at(30)) },
+		// Too long without heartbeats.
+		func() error { return TouchSession(s.DB, instance, at(200)) },
+		func() error { return TouchSession(s.DB, instance, at(210)) },
+		// Disconnecting for longer than a heartbeat.
+		func() error { return EndSession(s.DB, instance.Id, at(220), SessionEndDisconnect) },
+		func() error { return TouchSession(s.DB, instance, at(300)) },
+	}
+	for idx, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("[%d] %s", idx, err)
+		}
+	}
+
+	sessions, err := SelectSessions(s.DB, []string{"Session Service"}, start, at(400), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		Started, LastSeen time.Time
+		Reason            string
+	}{
+		{at(0), at(30), SessionEndGap},
+		{at(200), at(210), SessionEndDisconnect},
+		{at(300), at(300), ""},
+	}
+	if len(sessions) != len(expected) {
+		t.Fatalf("Expected %d sessions, got %d", len(expected), len(sessions))
+	}
+	for idx, e := range expected {
+		x := sessions[idx]
+		if !x.Started.Equal(e.Started) || !x.LastSeen.Equal(e.LastSeen) || x.EndReason != e.Reason {
+			t.Errorf("[%d] Expected session %v, got %v", idx, e, x)
+		}
+		if (x.Ended == nil) != (e.Reason == "") {
+			t.Errorf("[%d] Expected session to be ended: %v", idx, e.Reason != "")
+		}
+		if x.Machine != "Session Machine" {
+			t.Errorf("[%d] Expected machine from the view, got %s", idx, x.Machine)
+		}
+	}
+}
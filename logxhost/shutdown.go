@@ -0,0 +1,107 @@
+package logxhost
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/monstercat/gologx"
+)
+
+// Shutdown stops the server gracefully. It stops accepting connections,
+// tells the clients to pause and stops reading their logs, waits for the
+// logs already received to be stored and acknowledged, stops the ingester,
+// then closes the database.
+//
+// If the context expires first, the remaining connections are closed and
+// its error is returned. Logs which weren't acknowledged stay in the cache
+// of the clients, which send them again once they reconnect.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownMu.Lock()
+	s.shuttingDown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.shutdownMu.Unlock()
+
+	s.broadcast(logx.ClientMessage{
+		Type:    logx.MsgTypePause,
+		Status:  logx.ClientMessageStatusSuccessful,
+		Message: s.pauseDuration().String(),
+	})
+
+	// Unblocks the connections waiting for a message. Each returns once the
+	// logs it received are acknowledged.
+	s.connsMu.Lock()
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.connsMu.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		s.handling.Wait()
+		if s.Ingester != nil {
+			s.Ingester.Stop()
+		}
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.connsMu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.connsMu.Unlock()
+	}
+
+	if s.DB != nil {
+		if cerr := s.DB.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	return s.shuttingDown
+}
+
+// Adds the listener to the ones closed by Shutdown. Returns false if the
+// server is already shutting down.
+func (s *Server) trackListener(l net.Listener) bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[l] = true
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.shutdownMu.Lock()
+	delete(s.listeners, l)
+	s.shutdownMu.Unlock()
+}
+
+// Adds a connection to the ones Shutdown waits for. Returns false if the
+// server is shutting down.
+func (s *Server) startHandling() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.handling.Add(1)
+	return true
+}
@@ -0,0 +1,76 @@
+package logxhost
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestShutdown(t *testing.T) {
+	cert, key, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		ClientAuth:   tls.RequireAnyClientCert,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		HandshakeTimeout: time.Second,
+		Ingester:         &Ingester{write: func([]*ingestItem) error { return nil }},
+	}
+	ingesting := make(chan bool)
+	go func() {
+		s.Ingester.Run(make(chan bool), func(err error) {})
+		close(ingesting)
+	}()
+	served := make(chan bool)
+	go func() {
+		s.Serve(l, func(err error) {})
+		close(served)
+	}()
+
+	// Never completes the handshake, so that it is still being handled.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the shutdown to time out, got %v", err)
+	}
+
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Serve to return")
+	}
+	if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		c.Close()
+		t.Error("Expected the listener to be closed")
+	}
+
+	// Done once the handshake times out.
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Expected the shutdown to complete, got %s", err)
+	}
+	select {
+	case <-ingesting:
+	case <-time.After(time.Second):
+		t.Error("Expected the ingester to be stopped")
+	}
+}
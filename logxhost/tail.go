@@ -0,0 +1,227 @@
+package logxhost
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	dbutil "github.com/monstercat/golib/db"
+)
+
+// LogChannel is notified with the id of every new log.
+const LogChannel = "logx_log"
+
+// How often the listener connection is checked while no logs arrive.
+var TailPingInterval = time.Minute
+
+// Logs are created at the start of their transaction, so a log committed
+// late may have been created before logs already received. Catching up
+// therefore reads again the logs created this long before the last one,
+// skipping those already sent. Logs from longer transactions are missed.
+var TailOverlap = time.Minute
+
+// TailLogs calls fn with new logs matching the query as they are inserted,
+// until die is closed. Cursors, order and limit of the query are ignored.
+//
+// If the connection to Postgres is lost, it is re-established and the logs
+// created in the meantime are fetched, so that none are missed. Errors which
+// don't stop tailing are passed to eh.
+func TailLogs(db *sqlx.DB, url string, q *LogQuery, die chan bool, fn func([]*Log) error, eh func(error)) error {
+	return TailLogsSince(db, url, q, nil, die, fn, eh)
+}
+
+// TailLogsSince is like TailLogs, but first sends the logs created after
+// since, which is the creation time and id of the last log received. See
+// TailCursor. Logs created within TailOverlap before since may be sent
+// again.
+func TailLogsSince(db *sqlx.DB, url string, q *LogQuery, since *Cursor, die chan bool, fn func([]*Log) error, eh func(error)) error {
+	where, err := q.FilterWhere()
+	if err != nil {
+		return err
+	}
+	tail := &logTail{db: db, where: where}
+	if since != nil {
+		tail.last = *since
+	} else if err := sqlx.Get(db, &tail.last.Time, `SELECT NOW()`); err != nil {
+		return err
+	}
+
+	connStr, err := pq.ParseURL(url)
+	if err != nil {
+		return err
+	}
+	listener := pq.NewListener(connStr, 10*time.Millisecond, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			eh(err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(LogChannel); err != nil {
+		return err
+	}
+
+	if since != nil {
+		logs, err := tail.catchUp()
+		if err != nil {
+			return err
+		}
+		if len(logs) > 0 {
+			if err := fn(logs); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		var logs []*Log
+		select {
+		case <-die:
+			return nil
+		case <-time.After(TailPingInterval):
+			go listener.Ping()
+			continue
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established and
+			// notifications may have been missed.
+			if ids, reconnected := drainNotifications(n, listener.Notify); reconnected {
+				logs, err = tail.catchUp()
+			} else {
+				logs, err = tail.fetch(ids)
+			}
+		}
+		if err != nil {
+			eh(err)
+			continue
+		}
+		if len(logs) == 0 {
+			continue
+		}
+		if err := fn(logs); err != nil {
+			return err
+		}
+	}
+}
+
+// Collects the ids of the notifications which are already waiting, so
+// they are fetched together. Returns true instead if one of them is nil, as
+// the logs have to be caught up on.
+func drainNotifications(n *pq.Notification, ch <-chan *pq.Notification) ([]string, bool) {
+	if n == nil {
+		return nil, true
+	}
+	ids := []string{n.Extra}
+	for len(ids) < MaxLogQueryLimit {
+		select {
+		case n := <-ch:
+			if n == nil {
+				return nil, true
+			}
+			ids = append(ids, n.Extra)
+		default:
+			return ids, false
+		}
+	}
+	return ids, false
+}
+
+// TailCursor returns the position of the log when tailing, which is by
+// creation rather than log time.
+func TailCursor(l *Log) *Cursor {
+	return &Cursor{Time: l.Created, Id: l.Id}
+}
+
+type logTail struct {
+	db    *sqlx.DB
+	where squirrel.And
+
+	// Position of the last log received, by creation.
+	last Cursor
+	seen seenLogs
+}
+
+func (t *logTail) selectLogs(sel squirrel.SelectBuilder) ([]*Log, error) {
+	if len(t.where) > 0 {
+		sel = sel.Where(t.where)
+	}
+	sel = sel.OrderBy("created", "id")
+	var logs []*Log
+	if err := dbutil.Select(t.db, &logs, sel); err != nil {
+		return nil, err
+	}
+	for _, l := range logs {
+		if !l.Created.Before(t.last.Time) {
+			t.last = Cursor{Time: l.Created, Id: l.Id}
+		}
+	}
+	return logs, nil
+}
+
+// Removes the logs which were already sent.
+func (t *logTail) unseen(logs []*Log) []*Log {
+	var xs []*Log
+	for _, l := range logs {
+		if t.seen.add(l) {
+			xs = append(xs, l)
+		}
+	}
+	return xs
+}
+
+func (t *logTail) fetch(ids []string) ([]*Log, error) {
+	logs, err := t.selectLogs(SelectLogQry.Where("id = ANY(?)", pq.StringArray(ids)))
+	return t.unseen(logs), err
+}
+
+func (t *logTail) catchUp() ([]*Log, error) {
+	var logs []*Log
+	from := Cursor{Time: t.last.Time.Add(-TailOverlap)}
+	for {
+		sel := SelectLogQry.Where("created >= ?", from.Time)
+		if from.Id != "" {
+			sel = SelectLogQry.Where("(created, id) > (?, ?)", from.Time, from.Id)
+		}
+		xs, err := t.selectLogs(sel.Limit(MaxLogQueryLimit))
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, t.unseen(xs)...)
+		if len(xs) < MaxLogQueryLimit {
+			return logs, nil
+		}
+		from = *TailCursor(xs[len(xs)-1])
+	}
+}
+
+// Ids of the logs sent while tailing, so that logs read again when catching
+// up are not sent twice. Logs created more than TailOverlap before the
+// latest are forgotten, as they aren't read again.
+type seenLogs struct {
+	ids      map[string]time.Time
+	latest   time.Time
+	prunedAt time.Time
+}
+
+// Records the log, returning false if it was already seen.
+func (s *seenLogs) add(l *Log) bool {
+	if s.ids == nil {
+		s.ids = make(map[string]time.Time)
+	}
+	if _, ok := s.ids[l.Id]; ok {
+		return false
+	}
+	s.ids[l.Id] = l.Created
+	if l.Created.After(s.latest) {
+		s.latest = l.Created
+	}
+	if s.latest.Sub(s.prunedAt) > TailOverlap {
+		for id, created := range s.ids {
+			if created.Before(s.latest.Add(-TailOverlap)) {
+				delete(s.ids, id)
+			}
+		}
+		s.prunedAt = s.latest
+	}
+	return true
+}
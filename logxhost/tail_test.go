@@ -0,0 +1,139 @@
+package logxhost
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/monstercat/gologx"
+)
+
+func TestTailLogs(t *testing.T) {
+	s := &Server{
+		DB:       DefaultTestPostgres(),
+		Password: "testpassword",
+		SigCache: make(map[string]*Instance),
+	}
+
+	var ids []string
+	defer func() {
+		s.DB.Exec(`DELETE FROM `+TableLog+` WHERE instance_id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableInstance+` WHERE id=ANY($1)`, pq.StringArray(ids))
+		s.DB.Exec(`DELETE FROM `+TableService+` WHERE name=$1`, "Tail Service")
+		s.DB.Exec(`DELETE FROM `+TableMachine+` WHERE name=$1`, "Tail Machine")
+	}()
+
+	cert, _, err := logx.GenerateCerts(time.Hour)
+	if err != nil {
+		t.Fatalf("Could not generate cert: %s", err)
+	}
+	instance, err := s.RegisterService(logx.HostMessage{
+		Type:    logx.MsgTypeRegister,
+		Machine: "Tail Machine",
+		Service: "Tail Service",
+	}, ConnDetails{Hash: s.marshalHash(cert.Signature)})
+	if err != nil {
+		t.Fatalf("Could not register service: %s", err)
+	}
+	ids = append(ids, instance.Id)
+
+	die := make(chan bool)
+	defer close(die)
+	received := make(chan *Log, 10)
+	go func() {
+		q := &LogQuery{Services: []string{"Tail Service"}, Text: "tailed"}
+		err := TailLogs(s.DB, os.Getenv("TEST_PG"), q, die, func(logs []*Log) error {
+			for _, l := range logs {
+				received <- l
+			}
+			return nil
+		}, func(err error) {
+			t.Error(err)
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Wait for the listener to be ready.
+	time.Sleep(500 * time.Millisecond)
+
+	messages := []string{"ignored message", "tailed message"}
+	for _, m := range messages {
+		msg := logx.HostMessage{Type: "TestLog", Time: time.Now(), Message: []byte(m), Context: []byte("{}")}
+		if err := InsertHostMessage(s.DB, msg, instance.Id); err != nil {
+			t.Fatalf("Could not insert message: %s", err)
+		}
+	}
+
+	select {
+	case l := <-received:
+		if l.Message != "tailed message" {
+			t.Errorf("Expected tailed message, got %s", l.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected log to be tailed")
+	}
+	select {
+	case l := <-received:
+		t.Errorf("Expected a single log, got %s", l.Message)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSeenLogs(t *testing.T) {
+	var s seenLogs
+	start := time.Now()
+	tests := []struct {
+		Id       string
+		After    time.Duration
+		Expected bool
+	}{
+		{"a", 0, true},
+		{"a", 0, false},
+		// Committed late, after a log created later.
+		{"c", 2 * time.Second, true},
+		{"b", time.Second, true},
+		{"b", time.Second, false},
+		// Forgotten once older than the overlap, and not read again.
+		{"d", TailOverlap + 3*time.Second, true},
+		{"a", 0, true},
+	}
+	for i, test := range tests {
+		if added := s.add(&Log{Id: test.Id, Created: start.Add(test.After)}); added != test.Expected {
+			t.Errorf("[%d] Expected %v for %s, got %v", i, test.Expected, test.Id, added)
+		}
+	}
+}
+
+func TestDrainNotifications(t *testing.T) {
+	a, b := &pq.Notification{Extra: "a"}, &pq.Notification{Extra: "b"}
+	tests := []struct {
+		First       *pq.Notification
+		Waiting     []*pq.Notification
+		Ids         []string
+		Reconnected bool
+	}{
+		{a, nil, []string{"a"}, false},
+		{a, []*pq.Notification{b}, []string{"a", "b"}, false},
+		{nil, []*pq.Notification{b}, nil, true},
+		// Missed notifications are caught up on rather than dropped.
+		{a, []*pq.Notification{nil, b}, nil, true},
+	}
+	for i, test := range tests {
+		ch := make(chan *pq.Notification, len(test.Waiting))
+		for _, n := range test.Waiting {
+			ch <- n
+		}
+		ids, reconnected := drainNotifications(test.First, ch)
+		if reconnected != test.Reconnected {
+			t.Errorf("[%d] Expected reconnected to be %v", i, test.Reconnected)
+		}
+		if !reflect.DeepEqual(ids, test.Ids) {
+			t.Errorf("[%d] Expected ids %v, got %v", i, test.Ids, ids)
+		}
+	}
+}